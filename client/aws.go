@@ -1,54 +1,338 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-
-	"github.com/spf13/viper"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// DownloadBackup downloads a SQL backup from a S3 bucket
-func DownloadBackup(bucketName string, filename string, downloadDirectory string) error {
+// LatestFilename is the file name which resolves to the most recent backup of a database
+const LatestFilename = "latest"
+
+const backupTimestampFormat = "20060102150405"
+const backupExtension = ".bak"
+
+// partialExtension is appended to the name of a backup being downloaded
+const partialExtension = ".part"
+
+// differentialMarker precedes the timestamps in the file names of
+// differential backups so that they are not taken as full backups
+const differentialMarker = "diff-"
+
+// GetBackupFilename returns the file name of a backup of the specified type of
+// the specified database taken at the specified time; differential backups are
+// named <database>-diff-<timestamp>.bak
+func GetBackupFilename(databaseName string, backupType string, t time.Time) string {
+	marker := ""
+	if backupType == BackupTypeDifferential {
+		marker = differentialMarker
+	}
+	return fmt.Sprintf("%s-%s%s%s", databaseName, marker, t.UTC().Format(backupTimestampFormat), backupExtension)
+}
+
+// BackupObject is a backup stored in a S3 bucket
+type BackupObject struct {
+	Filename     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ListBackups returns the full backups of the specified database in a S3 bucket, the most recent first
+func ListBackups(ctx context.Context, bucketName string, databaseName string) ([]BackupObject, error) {
+	args := []string{
+		"s3api",
+		"list-objects-v2",
+		"--bucket",
+		bucketName,
+		"--prefix",
+		fmt.Sprintf("%s-", databaseName),
+		"--query",
+		"Contents[].[Key,LastModified,Size]",
+		"--output",
+		"text",
+	}
+	output, err := executeCommand(ctx, args)
+	if err != nil {
+		return nil, NewError(ErrorKindTransfer, err)
+	}
+
+	backups := []BackupObject{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 3 || !isBackupFilename(databaseName, fields[0]) {
+			continue
+		}
+		modified, errTime := time.Parse(time.RFC3339, fields[1])
+		if errTime != nil {
+			continue
+		}
+		size, errSize := strconv.ParseInt(fields[2], 10, 64)
+		if errSize != nil {
+			continue
+		}
+		backups = append(backups, BackupObject{
+			Filename:     fields[0],
+			Size:         size,
+			LastModified: modified,
+		})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].LastModified.After(backups[j].LastModified)
+	})
+	return backups, nil
+}
+
+// GetLatestBackupFilename returns the file name of the most recent full backup of the specified database in a S3 bucket
+func GetLatestBackupFilename(ctx context.Context, bucketName string, databaseName string) (string, error) {
+	backups, err := ListBackups(ctx, bucketName, databaseName)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("Unable to find any backup of database %s in s3://%s", databaseName, bucketName)
+	}
+	return backups[0].Filename, nil
+}
+
+// GetLatestLocalBackupFilename returns the file name of the most recent backup of the specified database in a local directory
+// or, if the directory is not specified, in the backup cache (of the bucket, if it is specified) and the current directory
+func GetLatestLocalBackupFilename(downloadDirectory string, cacheDirectory string, bucketName string, databaseName string) (string, error) {
+	directory := downloadDirectory
+	if directory == "" {
+		directory, _ = os.Getwd()
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return "", err
+	}
+
+	filenames := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if filename, ok := getOriginalFilename(entry.Name()); ok {
+			filenames = append(filenames, filename)
+		}
+	}
+	if downloadDirectory == "" && cacheDirectory != "" && bucketName != "" {
+		cache := &BackupCache{Directory: cacheDirectory}
+		cacheEntries, errCache := cache.List()
+		if errCache != nil {
+			return "", errCache
+		}
+		for _, entry := range cacheEntries {
+			if entry.BucketName == bucketName && entry.IsEncrypted == (encryptionKey != nil) {
+				filenames = append(filenames, entry.Filename)
+			}
+		}
+	}
+
+	latestFilename := ""
+	latestTime := time.Time{}
+	for _, filename := range filenames {
+		backupTime, ok := getBackupTime(databaseName, filename)
+		if ok && backupTime.After(latestTime) {
+			latestFilename = filename
+			latestTime = backupTime
+		}
+	}
+
+	if latestFilename == "" {
+		return "", fmt.Errorf("Unable to find any backup of database %s in %s", databaseName, directory)
+	}
+	return latestFilename, nil
+}
+
+func isBackupFilename(databaseName string, filename string) bool {
+	_, ok := getBackupTime(databaseName, filename)
+	return ok
+}
+
+// getBackupTime returns the time of a full backup of the database from its
+// file name; differential backups are not matched as diff- is not a timestamp
+func getBackupTime(databaseName string, filename string) (time.Time, bool) {
+	prefix := fmt.Sprintf("%s-", databaseName)
+	if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, backupExtension) {
+		return time.Time{}, false
+	}
+	timestamp := strings.TrimSuffix(strings.TrimPrefix(filename, prefix), backupExtension)
+	backupTime, err := time.Parse(backupTimestampFormat, timestamp)
+	return backupTime, err == nil
+}
+
+// DownloadBackup downloads a SQL backup from a S3 bucket; a partial download
+// is removed if the download fails or is cancelled
+func DownloadBackup(ctx context.Context, bucketName string, filename string, downloadDirectory string) error {
 	currentDirectory, _ := os.Getwd()
-	pathToBak := filepath.Join(currentDirectory, filename)
+	pathToBak := filepath.Join(currentDirectory, getStoredFilename(filename))
 	if downloadDirectory != "" {
-		pathToBak = filepath.Join(downloadDirectory, filename)
+		pathToBak = filepath.Join(downloadDirectory, getStoredFilename(filename))
+	}
+
+	log := logger.With("bucket", bucketName, "filename", filename)
+	log.Info("Download of backup from AWS S3 started")
+	startTime := time.Now()
+
+	pathToPartial := pathToBak + partialExtension
+	var err error
+	if encryptionKey != nil {
+		err = downloadEncryptedBackup(ctx, bucketName, filename, pathToPartial)
+	} else {
+		args := []string{
+			"s3",
+			"cp",
+			fmt.Sprintf("s3://%s/%s", bucketName, filename),
+			pathToPartial,
+		}
+		_, err = executeCommand(ctx, args)
+	}
+	if err == nil {
+		err = os.Rename(pathToPartial, pathToBak)
+	}
+
+	if err != nil {
+		os.Remove(pathToPartial)
+	} else {
+		log.Info("Download of the backup has been completed", "path", pathToBak)
+		if info, errStat := os.Stat(pathToBak); errStat == nil {
+			recordDownload(bucketName, info.Size(), time.Since(startTime))
+		}
 	}
+
+	return NewError(ErrorKindTransfer, err)
+}
+
+// downloadEncryptedBackup streams a backup from S3 into an encrypted file so
+// that the backup is never stored in plain text
+func downloadEncryptedBackup(ctx context.Context, bucketName string, filename string, pathToBak string) error {
+	out, err := os.OpenFile(pathToBak, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = writeEncryptedBackup(ctx, bucketName, filename, out)
+	// the file is closed on every path as a failed close loses the end of
+	// the encrypted backup
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func writeEncryptedBackup(ctx context.Context, bucketName string, filename string, out io.Writer) error {
+	writer, err := NewEncryptWriter(out, encryptionKey)
+	if err != nil {
+		return err
+	}
+
 	args := []string{
 		"s3",
 		"cp",
 		fmt.Sprintf("s3://%s/%s", bucketName, filename),
-		pathToBak,
+		"-",
 	}
-
-	fmt.Printf("Download of backup from AWS S3 (s3://%s/%s) started...\n", bucketName, filename)
-	_, err := executeCommand(args)
-
-	if err == nil {
-		fmt.Printf("Download of the backup has been completed (%s)\n", pathToBak)
+	if err = executeCommandToWriter(ctx, args, writer); err != nil {
+		return err
 	}
+	return writer.Close()
+}
 
-	return err
+// DeleteBackup deletes a SQL backup from a S3 bucket
+func DeleteBackup(ctx context.Context, bucketName string, filename string) error {
+	args := []string{
+		"s3",
+		"rm",
+		fmt.Sprintf("s3://%s/%s", bucketName, filename),
+	}
+	_, err := executeCommand(ctx, args)
+	return NewError(ErrorKindTransfer, err)
 }
 
 // IsAwsCliInstalled returns if AWS CLI has been installed
-func IsAwsCliInstalled() bool {
-	_, err := executeCommand([]string{"help"})
+func IsAwsCliInstalled(ctx context.Context) bool {
+	_, err := executeCommand(ctx, []string{"help"})
 	return err == nil
 }
 
 // IsAwsCredentialsConfigured returns if AWS CLI credentials has been configured
-func IsAwsCredentialsConfigured() bool {
-	_, err := executeCommand([]string{"s3", "ls"})
+func IsAwsCredentialsConfigured(ctx context.Context) bool {
+	_, err := executeCommand(ctx, []string{"s3", "ls"})
 	return err == nil
 }
 
-func executeCommand(args []string) (string, error) {
-	if viper.GetBool("verbose") {
-		fmt.Println("Command executed:", "aws", args)
+// GetAwsCallerIdentity returns the ARN of the identity AWS CLI is authenticated as
+func GetAwsCallerIdentity(ctx context.Context) (string, error) {
+	output, err := executeCommand(ctx, []string{"sts", "get-caller-identity", "--query", "Arn", "--output", "text"})
+	if err != nil {
+		return "", NewError(ErrorKindAuth, err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// CheckBucketAccess returns an error if the bucket does not exist or cannot be accessed
+func CheckBucketAccess(ctx context.Context, bucketName string) error {
+	_, err := executeCommand(ctx, []string{"s3api", "head-bucket", "--bucket", bucketName})
+	return NewError(ErrorKindTransfer, err)
+}
+
+// getBucketRegion returns the region of the bucket
+func getBucketRegion(ctx context.Context, bucketName string) (string, error) {
+	args := []string{
+		"s3api",
+		"get-bucket-location",
+		"--bucket",
+		bucketName,
+		"--query",
+		"LocationConstraint",
+		"--output",
+		"text",
+	}
+	output, err := executeCommand(ctx, args)
+	if err != nil {
+		return "", NewError(ErrorKindTransfer, err)
+	}
+	region := strings.TrimSpace(output)
+	// buckets in us-east-1 have no location constraint
+	if region == "" || region == "None" {
+		return "us-east-1", nil
+	}
+	return region, nil
+}
+
+func executeCommand(ctx context.Context, args []string) (string, error) {
+	command, err := getAwsCommand(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	return runCommand(ctx, command)
+}
+
+func executeCommandToWriter(ctx context.Context, args []string, w io.Writer) error {
+	command, err := getAwsCommand(ctx, args)
+	if err != nil {
+		return err
+	}
+	command.Stdout = w
+	_, err = runCommand(ctx, command)
+	return err
+}
+
+// getAwsCommand returns an AWS CLI command with the credentials of the
+// assumed role, which are renewed if they expire soon
+func getAwsCommand(ctx context.Context, args []string) (*Command, error) {
+	command := &Command{Name: "aws", Args: args}
+	credentials, err := getAssumedCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != nil {
+		command.Environment = credentials.environment()
 	}
-	byteOutput, err := exec.Command("aws", args...).Output()
-	return string(byteOutput), err
+	return command, nil
 }
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetBackupFilename(t *testing.T) {
+	backupTime := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		backupType string
+		expected   string
+	}{
+		{BackupTypeFull, "db-20240301102030.bak"},
+		{BackupTypeDifferential, "db-diff-20240301102030.bak"},
+	}
+
+	for _, test := range tests {
+		if actual := GetBackupFilename("db", test.backupType, backupTime); actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.backupType, test.expected, actual)
+		}
+	}
+}
+
+func TestGetLatestBackupFilenameSkipsDifferentialBackups(t *testing.T) {
+	defer UseCommandRunner(nil)
+	UseCommandRunner(&stubRunner{output: "db-20240301020000.bak\t2024-03-01T02:10:00+00:00\t100\n" +
+		"db-diff-20240301100000.bak\t2024-03-01T10:01:00+00:00\t10\n" +
+		"db-archive.bak\t2024-03-01T11:00:00+00:00\t10\n" +
+		"db-20240229020000.bak\t2024-02-29T02:10:00+00:00\t100\n"})
+
+	filename, err := GetLatestBackupFilename(context.Background(), "bucket", "db")
+
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	if filename != "db-20240301020000.bak" {
+		t.Errorf("expected db-20240301020000.bak but got %s", filename)
+	}
+}
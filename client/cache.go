@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCacheSize is the default size limit of the backup cache in megabytes
+const DefaultCacheSize = 20480
+
+// bucketNamePattern matches names of S3 buckets, which are the directories at
+// the top of the cache
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// BackupCache is a local directory of backups downloaded from AWS S3
+type BackupCache struct {
+	Directory string
+	MaxSize   int64
+}
+
+// CacheEntry is a backup stored in the cache
+type CacheEntry struct {
+	BucketName  string
+	Filename    string
+	ETag        string
+	Path        string
+	Size        int64
+	LastUsed    time.Time
+	IsEncrypted bool
+}
+
+// DefaultCacheDirectory returns the default path to the backup cache
+func DefaultCacheDirectory() string {
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDirectory, "rds-backup")
+}
+
+// NewBackupCache returns a backup cache in the specified directory limited to the specified size in megabytes
+func NewBackupCache(directory string, maxSizeInMegabytes int64) *BackupCache {
+	return &BackupCache{
+		Directory: directory,
+		MaxSize:   maxSizeInMegabytes * 1024 * 1024,
+	}
+}
+
+// Download returns the directory of the cached copy of a backup on S3 and
+// downloads it only if the object has changed since it was cached
+func (c *BackupCache) Download(ctx context.Context, bucketName string, filename string) (string, error) {
+	if c.Directory == "" {
+		return "", errors.New("Cache directory is not specified")
+	}
+	etag, err := getObjectETag(ctx, bucketName, filename)
+	if err != nil {
+		return "", err
+	}
+
+	entryDirectory := filepath.Join(c.Directory, bucketName, etag)
+	pathToBak := filepath.Join(entryDirectory, getStoredFilename(filename))
+	if _, errStat := os.Stat(pathToBak); errStat == nil {
+		now := time.Now()
+		os.Chtimes(pathToBak, now, now)
+		logger.Info("Using cached backup", "bucket", bucketName, "filename", filename, "path", pathToBak)
+		return entryDirectory, nil
+	}
+
+	if errDir := os.MkdirAll(filepath.Dir(pathToBak), 0755); errDir != nil {
+		return "", errDir
+	}
+	if errDownload := DownloadBackup(ctx, bucketName, filename, entryDirectory); errDownload != nil {
+		os.Remove(pathToBak)
+		os.Remove(entryDirectory)
+		return "", errDownload
+	}
+
+	if errEvict := c.evict(pathToBak); errEvict != nil {
+		return "", errEvict
+	}
+	return entryDirectory, nil
+}
+
+// Find returns the most recently used cache entry of the specified file name
+// downloaded from the specified bucket
+func (c *BackupCache) Find(bucketName string, filename string) (*CacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].BucketName == bucketName && entries[i].Filename == filename && entries[i].IsEncrypted == (encryptionKey != nil) {
+			return &entries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns the entries in the cache with the most recently used first
+func (c *BackupCache) List() ([]CacheEntry, error) {
+	entries := []CacheEntry{}
+	if c.Directory == "" {
+		return entries, nil
+	}
+	if _, errStat := os.Stat(c.Directory); os.IsNotExist(errStat) {
+		return entries, nil
+	}
+
+	err := filepath.WalkDir(c.Directory, func(path string, d fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			return errWalk
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relativePath, errRel := filepath.Rel(c.Directory, path)
+		if errRel != nil {
+			return errRel
+		}
+		parts := strings.SplitN(filepath.ToSlash(relativePath), "/", 3)
+		if len(parts) != 3 || !bucketNamePattern.MatchString(parts[0]) || !isCachedBackupFilename(parts[2]) {
+			return nil
+		}
+		info, errInfo := d.Info()
+		if errInfo != nil {
+			return errInfo
+		}
+		entries = append(entries, CacheEntry{
+			BucketName:  parts[0],
+			ETag:        parts[1],
+			Filename:    strings.TrimSuffix(parts[2], EncryptedExtension),
+			Path:        path,
+			Size:        info.Size(),
+			LastUsed:    info.ModTime(),
+			IsEncrypted: strings.HasSuffix(parts[2], EncryptedExtension),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Clear removes all entries in the cache; files and directories which do not
+// follow the layout of the cache (bucket/etag/backup) are left untouched in
+// case the directory is not one of the cache
+func (c *BackupCache) Clear() error {
+	if c.Directory == "" {
+		return errors.New("Cache directory is not specified")
+	}
+	buckets, err := os.ReadDir(c.Directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, bucket := range buckets {
+		if !bucket.IsDir() || !bucketNamePattern.MatchString(bucket.Name()) {
+			logger.Debug("Skipped path not in the layout of cache", "path", filepath.Join(c.Directory, bucket.Name()))
+			continue
+		}
+		bucketDirectory := filepath.Join(c.Directory, bucket.Name())
+		etags, errBucket := os.ReadDir(bucketDirectory)
+		if errBucket != nil {
+			return errBucket
+		}
+		for _, etag := range etags {
+			entryDirectory := filepath.Join(bucketDirectory, etag.Name())
+			if !etag.IsDir() || !isCacheEntryDirectory(entryDirectory) {
+				logger.Debug("Skipped path not in the layout of cache", "path", entryDirectory)
+				continue
+			}
+			if errRemove := os.RemoveAll(entryDirectory); errRemove != nil {
+				return errRemove
+			}
+		}
+		// the directory of the bucket is kept if anything else is left in it
+		os.Remove(bucketDirectory)
+	}
+	return nil
+}
+
+// isCacheEntryDirectory returns if the directory contains nothing but backups
+// (including partial downloads) as a directory of an ETag in the cache does
+func isCacheEntryDirectory(directory string) bool {
+	files, err := os.ReadDir(directory)
+	if err != nil || len(files) == 0 {
+		return false
+	}
+	for _, file := range files {
+		if !file.Type().IsRegular() || !isCachedBackupFilename(strings.TrimSuffix(file.Name(), partialExtension)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCachedBackupFilename(filename string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(filename, EncryptedExtension), backupExtension)
+}
+
+// evict removes the least recently used entries until the cache fits in its
+// size limit, except the entry at pathToKeep
+func (c *BackupCache) evict(pathToKeep string) error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	entries, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.Size
+	}
+
+	for i := len(entries) - 1; i >= 0 && totalSize > c.MaxSize; i-- {
+		if entries[i].Path == pathToKeep {
+			continue
+		}
+		if errRemove := os.Remove(entries[i].Path); errRemove != nil {
+			return errRemove
+		}
+		os.Remove(filepath.Dir(entries[i].Path))
+		totalSize -= entries[i].Size
+		logger.Info("Evicted backup from cache", "bucket", entries[i].BucketName, "filename", entries[i].Filename)
+	}
+	return nil
+}
+
+func getObjectETag(ctx context.Context, bucketName string, filename string) (string, error) {
+	info, err := getObjectInfo(ctx, bucketName, filename, "")
+	if err != nil {
+		return "", err
+	}
+	etag := strings.Trim(info.ETag, "\"")
+	if etag == "" {
+		return "", fmt.Errorf("Unable to find ETag of s3://%s/%s", bucketName, filename)
+	}
+	return etag, nil
+}
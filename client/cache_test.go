@@ -0,0 +1,92 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClear(t *testing.T) {
+	directory := t.TempDir()
+	cached := []string{
+		"bucket/etag1/db-20240101000000.bak",
+		"bucket/etag2/db-20240102000000.bak.part",
+		"other-bucket/etag/db-20240101000000.bak.enc",
+	}
+	unrelated := []string{
+		"notes.txt",
+		"Documents/report.docx",
+		"go/pkg/mod/cache.bak",
+		"bucket/etag3/notes.txt",
+		"bucket/readme.bak",
+	}
+	for _, path := range append(append([]string{}, cached...), unrelated...) {
+		writeCacheFile(t, directory, path, time.Now())
+	}
+
+	if err := NewBackupCache(directory, 0).Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range cached {
+		if _, err := os.Stat(filepath.Join(directory, filepath.Dir(path))); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", filepath.Dir(path))
+		}
+	}
+	for _, path := range unrelated {
+		if _, err := os.Stat(filepath.Join(directory, path)); err != nil {
+			t.Errorf("expected %s to be kept but got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, "other-bucket")); !os.IsNotExist(err) {
+		t.Error("expected empty directory of bucket to be removed")
+	}
+}
+
+func TestFind(t *testing.T) {
+	directory := t.TempDir()
+	now := time.Now()
+	writeCacheFile(t, directory, "bucket/etag1/db.bak", now.Add(-time.Hour))
+	writeCacheFile(t, directory, "bucket/etag2/db.bak", now.Add(-2*time.Hour))
+	writeCacheFile(t, directory, "other-bucket/etag3/db.bak", now)
+	cache := NewBackupCache(directory, 0)
+
+	tests := []struct {
+		bucketName   string
+		filename     string
+		expectedPath string
+	}{
+		{"bucket", "db.bak", "bucket/etag1/db.bak"},
+		{"other-bucket", "db.bak", "other-bucket/etag3/db.bak"},
+		{"another-bucket", "db.bak", ""},
+		{"bucket", "other.bak", ""},
+	}
+
+	for _, test := range tests {
+		entry, err := cache.Find(test.bucketName, test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case test.expectedPath == "" && entry != nil:
+			t.Errorf("s3://%s/%s: expected no entry but got %s", test.bucketName, test.filename, entry.Path)
+		case test.expectedPath != "" && (entry == nil || entry.Path != filepath.Join(directory, test.expectedPath)):
+			t.Errorf("s3://%s/%s: expected %s but got %+v", test.bucketName, test.filename, test.expectedPath, entry)
+		}
+	}
+}
+
+func writeCacheFile(t *testing.T, directory string, path string, modified time.Time) {
+	t.Helper()
+	fullPath := filepath.Join(directory, path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fullPath, []byte("backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fullPath, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
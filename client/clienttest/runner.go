@@ -0,0 +1,118 @@
+// Package clienttest provides fakes of external commands and SQL clients so
+// that operations of package client can be tested without AWS CLI, Docker or sqlcmd
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/alexhokl/rds-backup/client"
+)
+
+// CommandHandler returns the output of a command or the error of it
+type CommandHandler func(command *client.Command) (string, error)
+
+type commandResponse struct {
+	prefix  string
+	handler CommandHandler
+}
+
+// CommandRunner records the commands run and replays the outputs registered
+// for them; a command without registered output fails
+type CommandRunner struct {
+	mutex     sync.Mutex
+	responses []commandResponse
+	commands  []client.Command
+}
+
+// NewCommandRunner returns a runner without any registered outputs
+func NewCommandRunner() *CommandRunner {
+	return &CommandRunner{}
+}
+
+// On registers the output and the error of commands whose command lines
+// (such as "aws s3 cp s3://bucket/file") start with the prefix
+func (r *CommandRunner) On(prefix string, output string, err error) *CommandRunner {
+	return r.OnFunc(prefix, func(*client.Command) (string, error) {
+		return output, err
+	})
+}
+
+// OnFunc registers the handler of commands whose command lines start with
+// the prefix; the handler registered last takes precedence
+func (r *CommandRunner) OnFunc(prefix string, handler CommandHandler) *CommandRunner {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.responses = append(r.responses, commandResponse{prefix: prefix, handler: handler})
+	return r
+}
+
+// Run records the command and replays the output registered for it
+func (r *CommandRunner) Run(ctx context.Context, command *client.Command) (string, error) {
+	r.mutex.Lock()
+	r.commands = append(r.commands, *command)
+	handler := r.getHandler(GetCommandLine(command))
+	r.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", &client.CommandError{Err: err}
+	}
+	if handler == nil {
+		return "", &client.CommandError{Err: fmt.Errorf("unexpected command: %s", GetCommandLine(command))}
+	}
+	output, err := handler(command)
+	if err != nil {
+		var commandError *client.CommandError
+		if !errors.As(err, &commandError) {
+			err = &client.CommandError{Err: err}
+		}
+		return output, err
+	}
+	if command.Stdout != nil {
+		_, errWrite := io.WriteString(command.Stdout, output)
+		return "", errWrite
+	}
+	return output, nil
+}
+
+// Commands returns the command lines of the commands run so far
+func (r *CommandRunner) Commands() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	lines := make([]string, 0, len(r.commands))
+	for i := range r.commands {
+		lines = append(lines, GetCommandLine(&r.commands[i]))
+	}
+	return lines
+}
+
+// Count returns the number of commands run so far whose command lines start with the prefix
+func (r *CommandRunner) Count(prefix string) int {
+	count := 0
+	for _, line := range r.Commands() {
+		if strings.HasPrefix(line, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *CommandRunner) getHandler(line string) CommandHandler {
+	for i := len(r.responses) - 1; i >= 0; i-- {
+		if strings.HasPrefix(line, r.responses[i].prefix) {
+			return r.responses[i].handler
+		}
+	}
+	return nil
+}
+
+// GetCommandLine returns the name and the arguments of the command separated by spaces
+func GetCommandLine(command *client.Command) string {
+	return strings.Join(append([]string{command.Name}, command.Args...), " ")
+}
+
+var _ client.CommandRunner = &CommandRunner{}
@@ -0,0 +1,155 @@
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/alexhokl/rds-backup/client"
+)
+
+// statuses of backup tasks in rds_task_status
+const (
+	StatusCreated    = "CREATED"
+	StatusInProgress = "IN_PROGRESS"
+	StatusSuccess    = "SUCCESS"
+	StatusError      = "ERROR"
+	StatusCancelled  = "CANCELLED"
+)
+
+type backupTask struct {
+	params *client.BackupParameters
+	polls  int
+}
+
+// SQLClient is an in-memory SQL client which simulates the lifecycles of
+// backup tasks reported by rds_task_status
+type SQLClient struct {
+	mutex sync.Mutex
+	tasks []*backupTask
+	// Lifecycle is the statuses returned by successive polls of a task; the
+	// last one is returned once the lifecycle is exhausted
+	Lifecycle []string
+	// TaskMessage is the message of tasks (such as the reason of an error)
+	TaskMessage string
+	DataName    string
+	LogName     string
+	// StartError is returned by StartBackup if it is set
+	StartError error
+	// ServerVersion is returned by GetServerVersion unless LoginError is set
+	ServerVersion string
+	LoginError    error
+}
+
+// NewSQLClient returns a client whose backup tasks go through the lifecycle;
+// tasks succeed immediately if no lifecycle is specified
+func NewSQLClient(lifecycle ...string) *SQLClient {
+	if len(lifecycle) == 0 {
+		lifecycle = []string{StatusSuccess}
+	}
+	return &SQLClient{
+		Lifecycle:     lifecycle,
+		DataName:      "Data",
+		LogName:       "Log",
+		ServerVersion: "Microsoft SQL Server 2019 (RTM-CU22) - 15.0.4322.2 (X64)",
+	}
+}
+
+// IsEnvironmentSatisfied returns true
+func (c *SQLClient) IsEnvironmentSatisfied(ctx context.Context) bool {
+	return true
+}
+
+// GetStatus returns the next status in the lifecycle of the task, or of the
+// latest task if taskID is empty
+func (c *SQLClient) GetStatus(ctx context.Context, params *client.DatabaseParameters, taskID string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	task, err := c.getTask(taskID)
+	if err != nil {
+		return "", err
+	}
+	index := task.polls
+	if index >= len(c.Lifecycle) {
+		index = len(c.Lifecycle) - 1
+	}
+	task.polls++
+	return c.Lifecycle[index], nil
+}
+
+// GetCompletionPercentage returns the progress of the latest task through its lifecycle
+func (c *SQLClient) GetCompletionPercentage(ctx context.Context, params *client.DatabaseParameters) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	task, err := c.getTask("")
+	if err != nil {
+		return "", err
+	}
+	if task.polls >= len(c.Lifecycle) {
+		return "100", nil
+	}
+	return strconv.Itoa(task.polls * 100 / len(c.Lifecycle)), nil
+}
+
+// GetTaskMessage returns TaskMessage
+func (c *SQLClient) GetTaskMessage(ctx context.Context, params *client.DatabaseParameters) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := c.getTask(""); err != nil {
+		return "", err
+	}
+	return c.TaskMessage, nil
+}
+
+// StartBackup records the backup and returns the ID of a new task
+func (c *SQLClient) StartBackup(ctx context.Context, params *client.BackupParameters) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.StartError != nil {
+		return "", c.StartError
+	}
+	c.tasks = append(c.tasks, &backupTask{params: params})
+	return strconv.Itoa(len(c.tasks)), nil
+}
+
+// GetLogicalNames returns DataName and LogName
+func (c *SQLClient) GetLogicalNames(ctx context.Context, params *client.DatabaseParameters) (string, string, error) {
+	return c.DataName, c.LogName, nil
+}
+
+// GetServerVersion returns ServerVersion, or LoginError if it is set
+func (c *SQLClient) GetServerVersion(ctx context.Context, params *client.DatabaseParameters) (string, error) {
+	if c.LoginError != nil {
+		return "", c.LoginError
+	}
+	return c.ServerVersion, nil
+}
+
+// Backups returns the parameters of the backups started so far
+func (c *SQLClient) Backups() []client.BackupParameters {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	backups := make([]client.BackupParameters, 0, len(c.tasks))
+	for _, task := range c.tasks {
+		backups = append(backups, *task.params)
+	}
+	return backups
+}
+
+func (c *SQLClient) getTask(taskID string) (*backupTask, error) {
+	if len(c.tasks) == 0 {
+		return nil, errors.New("no backup task has been started")
+	}
+	if taskID == "" {
+		return c.tasks[len(c.tasks)-1], nil
+	}
+	index, err := strconv.Atoi(taskID)
+	if err != nil || index < 1 || index > len(c.tasks) {
+		return nil, fmt.Errorf("unknown task %s", taskID)
+	}
+	return c.tasks[index-1], nil
+}
+
+var _ client.SQLClient = &SQLClient{}
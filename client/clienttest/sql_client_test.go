@@ -0,0 +1,45 @@
+package clienttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexhokl/rds-backup/client"
+)
+
+func TestSQLClientLifecycle(t *testing.T) {
+	c := NewSQLClient(StatusCreated, StatusInProgress, StatusSuccess)
+	params := &client.DatabaseParameters{DatabaseName: "db"}
+	taskID, err := c.StartBackup(context.Background(), &client.BackupParameters{DatabaseParameters: *params})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{StatusCreated, StatusInProgress, StatusSuccess, StatusSuccess} {
+		status, errStatus := c.GetStatus(context.Background(), params, taskID)
+		if errStatus != nil {
+			t.Fatal(errStatus)
+		}
+		if status != expected {
+			t.Errorf("expected %s but got %s", expected, status)
+		}
+	}
+	if _, errUnknown := c.GetStatus(context.Background(), params, "2"); errUnknown == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestCommandRunnerReplaysLatestMatch(t *testing.T) {
+	runner := NewCommandRunner().On("aws s3", "any", nil).On("aws s3 ls", "listed", nil)
+
+	output, err := runner.Run(context.Background(), &client.Command{Name: "aws", Args: []string{"s3", "ls"}})
+	if err != nil || output != "listed" {
+		t.Errorf("expected listed but got %q (%v)", output, err)
+	}
+	if _, err = runner.Run(context.Background(), &client.Command{Name: "docker", Args: []string{"ps"}}); err == nil {
+		t.Error("expected error for unexpected command")
+	}
+	if count := runner.Count("aws s3"); count != 1 {
+		t.Errorf("expected 1 command but got %d", count)
+	}
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// commandWaitDelay is the time given to an interrupted command to clean up before it is killed
+const commandWaitDelay = 10 * time.Second
+
+// cleanupTimeout limits the time spent in cleaning up after an operation is cancelled
+const cleanupTimeout = 30 * time.Second
+
+// Command is an external command (such as aws, docker or sqlcmd) to be run
+type Command struct {
+	Name string
+	Args []string
+	// Environment contains variables in form of KEY=value added to the
+	// environment of this process
+	Environment []string
+	Stdin       io.Reader
+	// Stdout receives the output of the command instead of it being returned
+	Stdout io.Writer
+}
+
+// CommandError is the error of an external command which fails
+type CommandError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// CommandRunner runs external commands and returns their output; errors of
+// failed commands are returned as CommandError
+type CommandRunner interface {
+	Run(ctx context.Context, command *Command) (string, error)
+}
+
+// execRunner runs commands with os/exec
+type execRunner struct{}
+
+var runner = NewExecRunner()
+
+// NewExecRunner returns the runner which runs commands with os/exec
+func NewExecRunner() CommandRunner {
+	return &execRunner{}
+}
+
+// UseCommandRunner sets the runner of all external commands; the runner of
+// os/exec is used if nil is specified
+func UseCommandRunner(r CommandRunner) {
+	if r == nil {
+		r = NewExecRunner()
+	}
+	runner = r
+}
+
+// Run runs the command and interrupts it when the context is done so that it
+// can clean up (such as partial downloads of AWS CLI) and kills it if it does
+// not exit in time
+func (r *execRunner) Run(ctx context.Context, c *Command) (string, error) {
+	command := exec.CommandContext(ctx, c.Name, c.Args...)
+	command.Cancel = func() error {
+		if err := command.Process.Signal(os.Interrupt); err != nil {
+			return command.Process.Kill()
+		}
+		return nil
+	}
+	command.WaitDelay = commandWaitDelay
+	if c.Environment != nil {
+		command.Env = append(os.Environ(), c.Environment...)
+	}
+	command.Stdin = c.Stdin
+	output := &bytes.Buffer{}
+	command.Stdout = output
+	if c.Stdout != nil {
+		command.Stdout = c.Stdout
+	}
+	stderr := &bytes.Buffer{}
+	command.Stderr = stderr
+
+	if err := command.Run(); err != nil {
+		return output.String(), &CommandError{Err: err, Stderr: stderr.String()}
+	}
+	return output.String(), nil
+}
+
+// runCommand logs and runs the command with the runner in use
+func runCommand(ctx context.Context, command *Command) (string, error) {
+	logCommand(command.Name, command.Args)
+	output, err := runner.Run(ctx, command)
+	return output, getCommandError(ctx, err, output)
+}
+
+// getCleanupContext returns a context for cleaning up after an operation
+// which is not cancelled with the operation
+func getCleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
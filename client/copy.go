@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// CopyParameters contains the source and the destination of a copy of a backup
+type CopyParameters struct {
+	SourceBucketName         string
+	SourceFilename           string
+	SourceRegion             string
+	DestinationBucketName    string
+	DestinationFilename      string
+	DestinationRegion        string
+	KmsKeyID                 string
+	IsBucketOwnerFullControl bool
+}
+
+// CopyResult contains the verified details of a copied backup; Checksum is
+// the SHA256 checksum of the copy, which matches the one of the source
+type CopyResult struct {
+	SourceURI      string
+	DestinationURI string
+	Size           int64
+	Checksum       string
+}
+
+type objectInfo struct {
+	ContentLength  int64  `json:"ContentLength"`
+	ETag           string `json:"ETag"`
+	ChecksumSHA256 string `json:"ChecksumSHA256"`
+}
+
+type objectAttributes struct {
+	ObjectParts struct {
+		Parts []struct {
+			Size int64 `json:"Size"`
+		} `json:"Parts"`
+	} `json:"ObjectParts"`
+}
+
+// ParseS3URI returns the bucket name and the key (or prefix) of a S3 URI such as s3://bucket/prefix
+func ParseS3URI(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("%s is not a S3 URI (s3://bucket/prefix)", uri)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// GetDestinationFilename returns the key of a backup copied under the specified prefix
+func GetDestinationFilename(prefix string, filename string) string {
+	if prefix == "" {
+		return filename
+	}
+	return path.Join(prefix, path.Base(filename))
+}
+
+// CopyBackup copies a backup from one S3 bucket to another without downloading it
+// and verifies the size and the SHA256 checksum of the copy
+func CopyBackup(ctx context.Context, params *CopyParameters) (*CopyResult, error) {
+	sourceURI := fmt.Sprintf("s3://%s/%s", params.SourceBucketName, params.SourceFilename)
+	destinationURI := fmt.Sprintf("s3://%s/%s", params.DestinationBucketName, params.DestinationFilename)
+
+	// aws s3 cp switches to multipart copy for large objects
+	args := []string{
+		"s3",
+		"cp",
+		sourceURI,
+		destinationURI,
+		"--checksum-algorithm",
+		"SHA256",
+	}
+	if params.SourceRegion != "" {
+		args = append(args, "--source-region", params.SourceRegion)
+	}
+	if params.DestinationRegion != "" {
+		args = append(args, "--region", params.DestinationRegion)
+	}
+	if params.KmsKeyID != "" {
+		args = append(args, "--sse", "aws:kms", "--sse-kms-key-id", params.KmsKeyID)
+	}
+	if params.IsBucketOwnerFullControl {
+		args = append(args, "--acl", "bucket-owner-full-control")
+	}
+
+	logger.Info("Copy of backup started", "source", sourceURI, "destination", destinationURI)
+	_, err := executeCommand(ctx, args)
+	if err != nil {
+		return nil, NewError(ErrorKindTransfer, err)
+	}
+
+	sourceInfo, errSource := getObjectInfo(ctx, params.SourceBucketName, params.SourceFilename, params.SourceRegion)
+	if errSource != nil {
+		return nil, NewError(ErrorKindTransfer, errSource)
+	}
+	destinationInfo, errDestination := getObjectInfo(ctx, params.DestinationBucketName, params.DestinationFilename, params.DestinationRegion)
+	if errDestination != nil {
+		return nil, NewError(ErrorKindTransfer, errDestination)
+	}
+
+	if sourceInfo.ContentLength != destinationInfo.ContentLength {
+		return nil, NewError(ErrorKindTransfer, fmt.Errorf("Size of %s (%d bytes) does not match the size of %s (%d bytes)", destinationURI, destinationInfo.ContentLength, sourceURI, sourceInfo.ContentLength))
+	}
+	checksum, errChecksum := getVerifiedChecksum(ctx, params, sourceInfo, destinationInfo)
+	if errChecksum != nil {
+		return nil, NewError(ErrorKindTransfer, errChecksum)
+	}
+
+	logger.Info("Copy of the backup has been completed", "source", sourceURI, "destination", destinationURI)
+
+	return &CopyResult{
+		SourceURI:      sourceURI,
+		DestinationURI: destinationURI,
+		Size:           destinationInfo.ContentLength,
+		Checksum:       checksum,
+	}, nil
+}
+
+// getVerifiedChecksum returns the SHA256 checksum of the copy if it matches
+// the one of the source; composite checksums of multipart objects (suffixed by
+// the number of parts) depend on the part sizes and are compared only if the
+// parts of both objects are of the same sizes, and both objects are read to
+// compute their checksums otherwise
+func getVerifiedChecksum(ctx context.Context, params *CopyParameters, sourceInfo *objectInfo, destinationInfo *objectInfo) (string, error) {
+	sourceURI := fmt.Sprintf("s3://%s/%s", params.SourceBucketName, params.SourceFilename)
+	destinationURI := fmt.Sprintf("s3://%s/%s", params.DestinationBucketName, params.DestinationFilename)
+
+	sourceChecksum := sourceInfo.ChecksumSHA256
+	destinationChecksum := destinationInfo.ChecksumSHA256
+	isComparable := sourceChecksum != "" && destinationChecksum != "" && isFullObjectChecksum(sourceChecksum) == isFullObjectChecksum(destinationChecksum)
+	if isComparable && !isFullObjectChecksum(sourceChecksum) {
+		sourceParts, errSource := getObjectPartSizes(ctx, params.SourceBucketName, params.SourceFilename, params.SourceRegion)
+		if errSource != nil {
+			return "", errSource
+		}
+		destinationParts, errDestination := getObjectPartSizes(ctx, params.DestinationBucketName, params.DestinationFilename, params.DestinationRegion)
+		if errDestination != nil {
+			return "", errDestination
+		}
+		isComparable = len(sourceParts) > 0 && slices.Equal(sourceParts, destinationParts)
+	}
+	if !isComparable {
+		logger.Info("Computing checksums of the source and the copy as their checksums cannot be compared", "source", sourceURI, "destination", destinationURI)
+		var err error
+		sourceChecksum, err = computeObjectChecksum(ctx, sourceURI, params.SourceRegion)
+		if err != nil {
+			return "", err
+		}
+		destinationChecksum, err = computeObjectChecksum(ctx, destinationURI, params.DestinationRegion)
+		if err != nil {
+			return "", err
+		}
+	}
+	if sourceChecksum != destinationChecksum {
+		return "", fmt.Errorf("Checksum of %s (%s) does not match the checksum of %s (%s)", destinationURI, destinationChecksum, sourceURI, sourceChecksum)
+	}
+	return destinationChecksum, nil
+}
+
+func isFullObjectChecksum(checksum string) bool {
+	return !strings.Contains(checksum, "-")
+}
+
+// getObjectPartSizes returns the sizes of the parts of a multipart object,
+// which are listed only if the object is uploaded with checksums
+func getObjectPartSizes(ctx context.Context, bucketName string, filename string, region string) ([]int64, error) {
+	args := []string{
+		"s3api",
+		"get-object-attributes",
+		"--bucket",
+		bucketName,
+		"--key",
+		filename,
+		"--object-attributes",
+		"ObjectParts",
+		"--max-parts",
+		"10000",
+		"--output",
+		"json",
+	}
+	if region != "" {
+		args = append(args, "--region", region)
+	}
+	output, err := executeCommand(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	attributes := &objectAttributes{}
+	if errJSON := json.Unmarshal([]byte(output), attributes); errJSON != nil {
+		return nil, errJSON
+	}
+	var sizes []int64
+	for _, part := range attributes.ObjectParts.Parts {
+		sizes = append(sizes, part.Size)
+	}
+	return sizes, nil
+}
+
+// computeObjectChecksum returns the SHA256 checksum of an object, encoded as
+// the checksums of S3, by reading it without writing it to disk
+func computeObjectChecksum(ctx context.Context, uri string, region string) (string, error) {
+	args := []string{"s3", "cp", uri, "-"}
+	if region != "" {
+		args = append(args, "--region", region)
+	}
+	hash := sha256.New()
+	if err := executeCommandToWriter(ctx, args, hash); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+func getObjectInfo(ctx context.Context, bucketName string, filename string, region string) (*objectInfo, error) {
+	args := []string{
+		"s3api",
+		"head-object",
+		"--bucket",
+		bucketName,
+		"--key",
+		filename,
+		"--checksum-mode",
+		"ENABLED",
+		"--output",
+		"json",
+	}
+	if region != "" {
+		args = append(args, "--region", region)
+	}
+	output, err := executeCommand(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	info := &objectInfo{}
+	if errJSON := json.Unmarshal([]byte(output), info); errJSON != nil {
+		return nil, errJSON
+	}
+	return info, nil
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// funcRunner runs commands with a function
+type funcRunner func(command *Command) (string, error)
+
+func (r funcRunner) Run(ctx context.Context, command *Command) (string, error) {
+	return r(command)
+}
+
+func TestCopyBackup(t *testing.T) {
+	sum := sha256.Sum256([]byte("backup"))
+	computed := base64.StdEncoding.EncodeToString(sum[:])
+	tests := []struct {
+		name               string
+		copyErr            error
+		source             string
+		destination        string
+		sourceParts        string
+		destinationParts   string
+		sourceContent      string
+		destinationContent string
+		expectedKind       ErrorKind
+		expectedChecksum   string
+		expectedErrorText  string
+	}{
+		{
+			name:             "full object checksums match",
+			source:           `{"ContentLength": 100, "ChecksumSHA256": "abc="}`,
+			destination:      `{"ContentLength": 100, "ChecksumSHA256": "abc="}`,
+			expectedChecksum: "abc=",
+		},
+		{
+			name:              "full object checksums differ",
+			source:            `{"ContentLength": 100, "ChecksumSHA256": "abc="}`,
+			destination:       `{"ContentLength": 100, "ChecksumSHA256": "def="}`,
+			expectedKind:      ErrorKindTransfer,
+			expectedErrorText: "Checksum of s3://destination/db.bak",
+		},
+		{
+			name:             "composite checksums of parts of same sizes match",
+			source:           `{"ContentLength": 100, "ChecksumSHA256": "abc=-2"}`,
+			destination:      `{"ContentLength": 100, "ChecksumSHA256": "abc=-2"}`,
+			sourceParts:      `{"ObjectParts": {"Parts": [{"Size": 60}, {"Size": 40}]}}`,
+			destinationParts: `{"ObjectParts": {"Parts": [{"Size": 60}, {"Size": 40}]}}`,
+			expectedChecksum: "abc=-2",
+		},
+		{
+			name:              "composite checksums of parts of same sizes differ",
+			source:            `{"ContentLength": 100, "ChecksumSHA256": "abc=-2"}`,
+			destination:       `{"ContentLength": 100, "ChecksumSHA256": "def=-2"}`,
+			sourceParts:       `{"ObjectParts": {"Parts": [{"Size": 60}, {"Size": 40}]}}`,
+			destinationParts:  `{"ObjectParts": {"Parts": [{"Size": 60}, {"Size": 40}]}}`,
+			expectedKind:      ErrorKindTransfer,
+			expectedErrorText: "Checksum of s3://destination/db.bak (def=-2)",
+		},
+		{
+			name:               "checksums are computed for parts of different sizes",
+			source:             `{"ContentLength": 100, "ChecksumSHA256": "abc=-3"}`,
+			destination:        `{"ContentLength": 100, "ChecksumSHA256": "def=-2"}`,
+			sourceParts:        `{"ObjectParts": {"Parts": [{"Size": 40}, {"Size": 40}, {"Size": 20}]}}`,
+			destinationParts:   `{"ObjectParts": {"Parts": [{"Size": 60}, {"Size": 40}]}}`,
+			sourceContent:      "backup",
+			destinationContent: "backup",
+			expectedChecksum:   computed,
+		},
+		{
+			name:               "checksums are computed for source without checksum",
+			source:             `{"ContentLength": 100}`,
+			destination:        `{"ContentLength": 100, "ChecksumSHA256": "def="}`,
+			sourceContent:      "backup",
+			destinationContent: "backup",
+			expectedChecksum:   computed,
+		},
+		{
+			name:               "computed checksums differ",
+			source:             `{"ContentLength": 100}`,
+			destination:        `{"ContentLength": 100, "ChecksumSHA256": "def="}`,
+			sourceContent:      "backup",
+			destinationContent: "corrupted",
+			expectedKind:       ErrorKindTransfer,
+			expectedErrorText:  "Checksum of s3://destination/db.bak",
+		},
+		{
+			name:              "sizes differ",
+			source:            `{"ContentLength": 100, "ChecksumSHA256": "abc="}`,
+			destination:       `{"ContentLength": 99, "ChecksumSHA256": "abc="}`,
+			expectedKind:      ErrorKindTransfer,
+			expectedErrorText: "Size of s3://destination/db.bak (99 bytes)",
+		},
+		{
+			name:              "copy fails",
+			copyErr:           errors.New("access denied"),
+			expectedKind:      ErrorKindTransfer,
+			expectedErrorText: "access denied",
+		},
+	}
+	defer UseCommandRunner(nil)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			UseCommandRunner(funcRunner(func(command *Command) (string, error) {
+				args := strings.Join(command.Args, " ")
+				switch {
+				case args == "s3 cp s3://source/db.bak -":
+					_, err := command.Stdout.Write([]byte(test.sourceContent))
+					return "", err
+				case args == "s3 cp s3://destination/db.bak -":
+					_, err := command.Stdout.Write([]byte(test.destinationContent))
+					return "", err
+				case strings.HasPrefix(args, "s3 cp"):
+					return "", test.copyErr
+				case strings.HasPrefix(args, "s3api get-object-attributes --bucket source"):
+					return test.sourceParts, nil
+				case strings.HasPrefix(args, "s3api get-object-attributes --bucket destination"):
+					return test.destinationParts, nil
+				case strings.Contains(args, "--bucket source"):
+					return test.source, nil
+				case strings.Contains(args, "--bucket destination"):
+					return test.destination, nil
+				}
+				t.Fatalf("unexpected command %s", args)
+				return "", nil
+			}))
+			params := &CopyParameters{
+				SourceBucketName:      "source",
+				SourceFilename:        "db.bak",
+				DestinationBucketName: "destination",
+				DestinationFilename:   "db.bak",
+			}
+
+			result, err := CopyBackup(context.Background(), params)
+
+			if test.expectedKind != ErrorKindUnknown {
+				if GetErrorKind(err) != test.expectedKind || !strings.Contains(err.Error(), test.expectedErrorText) {
+					t.Fatalf("expected %s error containing %q but got %v", test.expectedKind, test.expectedErrorText, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if result.Checksum != test.expectedChecksum {
+				t.Errorf("expected checksum %q but got %q", test.expectedChecksum, result.Checksum)
+			}
+			if result.Size != 100 {
+				t.Errorf("expected size 100 but got %d", result.Size)
+			}
+		})
+	}
+}
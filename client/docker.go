@@ -1,15 +1,15 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/spf13/viper"
 )
 
 // DatabaseParameters contains the database information
@@ -25,15 +25,37 @@ type BackupParameters struct {
 	DatabaseParameters
 	BucketName string
 	Filename   string
+	Type       string
 }
 
+// BackupTypeFull is a backup of the whole database
+const BackupTypeFull = "FULL"
+
+// BackupTypeDifferential is a backup of changes since the last full backup
+const BackupTypeDifferential = "DIFFERENTIAL"
+
 // BaseRestoreParameters contains basic restore information
 type BaseRestoreParameters struct {
+	// BucketName is the bucket the backup is downloaded from, which selects
+	// the backup in the cache
+	BucketName        string
 	Filename          string
 	DatabaseName      string
 	DataName          string
 	LogName           string
 	DownloadDirectory string
+	CacheDirectory    string
+	// Source is the S3 object restored from directly (RESTORE FROM URL)
+	// instead of a downloaded file if it is not nil
+	Source *S3Source
+	// MaskProfile masks the restored database if it is not nil
+	MaskProfile *MaskProfile
+	// PostRestoreDirectory contains the scripts run once restored, if it is specified
+	PostRestoreDirectory string
+	// UserLogins maps orphaned users of the restored database to the logins they are remapped to
+	UserLogins map[string]string
+	// LoginPasswords maps orphaned users to the passwords of the logins created for them
+	LoginPasswords map[string]string
 }
 
 // RestoreParameters contains restore information
@@ -44,9 +66,24 @@ type RestoreParameters struct {
 	Port          int
 }
 
+// SQLServerImage is the Docker image of SQL server used in restores
+const SQLServerImage = "microsoft/mssql-server-linux"
+
+// SQLServer2022Image is the Docker image of SQL server used in restores from S3 URLs
+const SQLServer2022Image = "mcr.microsoft.com/mssql/server:2022-latest"
+
+// SQLToolsImage is the Docker image of sqlcmd used by DockerSQLClient
+const SQLToolsImage = "mcr.microsoft.com/mssql-tools"
+
 // DefaultServerPort stores the default port of MSSQL server
 const DefaultServerPort = 1433
 
+// serverStartupTimeout limits the time waiting for SQL server in a new container to accept queries
+var serverStartupTimeout = 90 * time.Second
+
+// serverStartupPollInterval is the time between checks of SQL server in a new container
+var serverStartupPollInterval = 5 * time.Second
+
 const statusTableDeclaration = `DECLARE @s TABLE (
 	task_id INT,
 	task_type VARCHAR(20),
@@ -76,151 +113,99 @@ const createTableDeclaration = `DECLARE @s TABLE (
 	task_info VARCHAR(MAX)
 )`
 
-// DockerSQLClient a SQL client in a Docker container
-type DockerSQLClient struct {
-	clientContainerName string
-}
+// DockerSQLClient a SQL client running sqlcmd in a new container of
+// SQLToolsImage for each query
+type DockerSQLClient struct{}
 
-// IsEnvironmentSatisfied returns if this client can be ran on this machine
-func (c *DockerSQLClient) IsEnvironmentSatisfied() bool {
-	if !isDockerInstalled() {
+// IsEnvironmentSatisfied returns if the Docker daemon can be reached and
+// Docker Content Trust is disabled; no container is created or removed
+func (c *DockerSQLClient) IsEnvironmentSatisfied(ctx context.Context) bool {
+	if _, err := GetDockerServerVersion(ctx); err != nil {
 		return false
 	}
-	if !isDockerContentTrustDisabled() {
-		fmt.Println("Docker Content Trust is not disabled yet. Please run 'export DOCKER_CONTENT_TRUST=0'")
+	if !IsDockerContentTrustDisabled() {
+		logger.Warn("Docker Content Trust is not disabled yet. Please run 'export DOCKER_CONTENT_TRUST=0'")
 		return false
 	}
-
-	serverContainerName := getSQLServerContainerName()
-	if serverContainerName == "" {
-		if isSQLCommandContainerExist() {
-			removeSQLCommandContainer()
-		}
-		containerName, errCreate := createSQLCommandContainer()
-		if errCreate != nil {
-			return false
-		}
-
-		// TODO: there must be a better way of doing this
-		c.clientContainerName = containerName
-
-		return true
-	}
-
-	// TODO: there must be a better way of doing this
-	c.clientContainerName = serverContainerName
-
 	return true
 }
 
 // GetStatus returns the status of the latest backup
-func (c *DockerSQLClient) GetStatus(params *DatabaseParameters, taskID string) (string, error) {
-	query := "SELECT TOP 1 lifecycle FROM @s"
-	if taskID != "" {
-		query = fmt.Sprintf("SELECT lifecycle FROM @s WHERE task_id = %s", taskID)
+func (c *DockerSQLClient) GetStatus(ctx context.Context, params *DatabaseParameters, taskID string) (string, error) {
+	query, errQuery := getStatusQuery(params.DatabaseName, taskID)
+	if errQuery != nil {
+		return "", errQuery
 	}
+	output, err := c.runQuery(ctx, params, query)
+	if err != nil {
+		return "", err
+	}
+	return getSQLValue(output)
+}
 
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-	%s
-
-	INSERT INTO @s
-	exec msdb.dbo.rds_task_status @db_name='%s'
-
-	%s
-
-	SET NOCOUNT OFF`, statusTableDeclaration, params.DatabaseName, query)
-
-	args := getCommandArgs(c.clientContainerName, params, statement)
-	output, err := execute(args)
+// GetServerVersion returns the version of the SQL server, which fails if the login fails
+func (c *DockerSQLClient) GetServerVersion(ctx context.Context, params *DatabaseParameters) (string, error) {
+	output, err := c.runQuery(ctx, params, getServerVersionQuery())
 	if err != nil {
 		return "", err
 	}
-	return getSQLOutput(output), nil
+	return getSQLValue(output)
 }
 
 // GetCompletionPercentage returns the percentage of completion of the latest backup
-func (c *DockerSQLClient) GetCompletionPercentage(params *DatabaseParameters) (string, error) {
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-	%s
-
-	INSERT INTO @s
-	exec msdb.dbo.rds_task_status @db_name='%s'
-
-	SELECT TOP 1 complete FROM @s
-
-	SET NOCOUNT OFF`, statusTableDeclaration, params.DatabaseName)
-
-	args := getCommandArgs(c.clientContainerName, params, statement)
-	output, err := execute(args)
+func (c *DockerSQLClient) GetCompletionPercentage(ctx context.Context, params *DatabaseParameters) (string, error) {
+	output, err := c.runQuery(ctx, params, getCompletionPercentageQuery(params.DatabaseName))
 	if err != nil {
 		return "", err
 	}
-	return getSQLOutput(output), nil
+	return getSQLValue(output)
 }
 
 // GetTaskMessage returns the message of the latest backup task
-func (c *DockerSQLClient) GetTaskMessage(params *DatabaseParameters) (string, error) {
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-	%s
-
-	INSERT INTO @s
-	exec msdb.dbo.rds_task_status @db_name='%s'
-
-	SELECT TOP 1 task_info FROM @s
-
-	SET NOCOUNT OFF`, statusTableDeclaration, params.DatabaseName)
-
-	args := getCommandArgs(c.clientContainerName, params, statement)
-	output, err := execute(args)
+func (c *DockerSQLClient) GetTaskMessage(ctx context.Context, params *DatabaseParameters) (string, error) {
+	output, err := c.runQuery(ctx, params, getTaskMessageQuery(params.DatabaseName))
 	if err != nil {
 		return "", err
 	}
-	return getSQLOutput(output), nil
+	return getSQLValue(output)
 }
 
 // StartBackup creates a new backup
-func (c *DockerSQLClient) StartBackup(params *BackupParameters) (string, error) {
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-		%s
-
-		INSERT INTO @s
-		exec msdb.dbo.rds_backup_database
-			@source_db_name='%s',
-			@s3_arn_to_backup_to='arn:aws:s3:::%s/%s',
-			@overwrite_S3_backup_file=1;
-
-		SELECT TOP 1 task_id FROM @s
-
-		SET NOCOUNT OFF`,
-		createTableDeclaration,
-		params.DatabaseName,
-		params.BucketName,
-		params.Filename)
-
-	args := getCommandArgs(c.clientContainerName, &params.DatabaseParameters, statement)
-	output, err := execute(args)
+func (c *DockerSQLClient) StartBackup(ctx context.Context, params *BackupParameters) (string, error) {
+	output, err := c.runQuery(ctx, &params.DatabaseParameters, getStartBackupQuery(params))
 	if err != nil {
-		return "", err
+		return "", NewError(ErrorKindTask, err)
 	}
-	lines := strings.Split(output, "\n")
-	if len(lines) < 4 {
-		return "", errors.New(output)
+	taskID, errTaskID := getSQLValue(output)
+	if errTaskID != nil {
+		return "", NewError(ErrorKindTask, errTaskID)
 	}
-	return strings.TrimSpace(lines[3]), nil
+	return taskID, nil
 }
 
 // Restore creates a Docker container and restores the specified backup onto it
-func Restore(params *RestoreParameters) error {
+func Restore(ctx context.Context, params *RestoreParameters) error {
+	startTime := time.Now()
+	err := restoreInContainer(ctx, params)
+	if err == nil {
+		recordRestore(params.DatabaseName, "docker", time.Since(startTime))
+	}
+	notifyRestore(&params.BaseRestoreParameters, time.Since(startTime), err)
+	return NewError(ErrorKindRestore, err)
+}
+
+func restoreInContainer(ctx context.Context, params *RestoreParameters) error {
+	log := logger.With("database", params.DatabaseName, "container", params.ContainerName, "filename", params.Filename)
+	image := SQLServerImage
 	pathToBak := getPathToBak(&params.BaseRestoreParameters)
-	_, errFile := os.Stat(pathToBak)
-	if errFile != nil {
+	if params.Source != nil {
+		image = SQLServer2022Image
+	} else if _, errFile := os.Stat(pathToBak); errFile != nil {
 		return errFile
 	}
+	sqlcmd := getContainerSQLCmd(image)
 	directoryToMount := filepath.Dir(pathToBak)
+	containerPathToBak := fmt.Sprintf("/var/backups/%s", params.Filename)
 
 	createArgs := []string{
 		"run",
@@ -228,181 +213,314 @@ func Restore(params *RestoreParameters) error {
 		params.ContainerName,
 		"-p",
 		fmt.Sprintf("%d:%d", params.Port, DefaultServerPort),
-		"-v",
-		fmt.Sprintf("%s/:/var/backups/", directoryToMount),
+	}
+	// an encrypted backup is decrypted into the container instead of being mounted
+	if encryptionKey == nil && params.Source == nil {
+		createArgs = append(createArgs, "-v", fmt.Sprintf("%s/:/var/backups/", directoryToMount))
+	}
+	createArgs = append(
+		createArgs,
 		"-e",
-		fmt.Sprintf("SA_PASSWORD=%s", params.Password),
+		"SA_PASSWORD",
 		"-e",
 		"ACCEPT_EULA=Y",
 		"-d",
-		"microsoft/mssql-server-linux",
-	}
+		image,
+	)
 
-	fmt.Printf("Starting to restore from file %s onto a SQL Server in Docker container...\n", pathToBak)
+	log.Info("Starting to restore onto a SQL Server in Docker container", "path", pathToBak)
 
-	_, errCreate := execute(createArgs)
+	_, errCreate := executeDocker(ctx, createArgs, nil, []string{fmt.Sprintf("SA_PASSWORD=%s", params.Password)})
 	if errCreate != nil {
+		removeCancelledContainer(ctx, params.ContainerName)
 		return errCreate
 	}
+	defer removeCancelledContainer(ctx, params.ContainerName)
+
+	log.Info("MSSQL container is created. Waiting for SQL server to complete initialisation")
+
+	if errWait := waitForServer(ctx, params.ContainerName, sqlcmd, params.Password); errWait != nil {
+		return errWait
+	}
 
-	fmt.Printf("MSSQL container %s is created. Waiting for SQL server to complete initialisation...\n", params.ContainerName)
+	mdfPath := fmt.Sprintf("/var/opt/mssql/data/%s.mdf", params.DatabaseName)
+	ldfPath := fmt.Sprintf("/var/opt/mssql/data/%s.ldf", params.DatabaseName)
+
+	runBatch := getContainerBatchRunner(params.ContainerName, sqlcmd, params.Password)
+	server := &restoredServer{
+		Server:    fmt.Sprintf("localhost,%d", params.Port),
+		Host:      "localhost",
+		Port:      strconv.Itoa(params.Port),
+		Container: params.ContainerName,
+		Username:  "sa",
+		Password:  params.Password,
+	}
+	if params.Source != nil {
+		if err := restoreFromURL(ctx, &params.BaseRestoreParameters, mdfPath, ldfPath, runBatch); err != nil {
+			return err
+		}
+		log.Info("Restore has been completed")
+		return completeRestore(ctx, &params.BaseRestoreParameters, server, runBatch)
+	}
 
-	time.Sleep(90 * time.Second)
+	if encryptionKey != nil {
+		errCopy := copyDecryptedBackupToContainer(ctx, pathToBak, params.ContainerName, containerPathToBak)
+		if errCopy != nil {
+			return errCopy
+		}
+		defer execute(ctx, []string{"exec", params.ContainerName, "rm", "-f", containerPathToBak})
+	}
 
-	fmt.Println("Restoring...")
+	log.Info("Restoring")
 
-	restoreArgs := []string{
+	restoreStatement, errStatement := getRestoreQuery(
+		params.DatabaseName,
+		containerPathToBak,
+		params.DataName,
+		mdfPath,
+		params.LogName,
+		ldfPath,
+	).render()
+	if errStatement != nil {
+		return errStatement
+	}
+
+	restoreArgs := append([]string{
 		"exec",
 		"-t",
+		"-e",
+		sqlcmdPasswordVariable,
 		params.ContainerName,
-		"/opt/mssql-tools/bin/sqlcmd",
+	}, sqlcmd...)
+	restoreArgs = append(restoreArgs,
 		"-S",
 		".",
 		"-U",
 		"sa",
-		"-P",
-		params.Password,
+		"-b",
+		"-x",
 		"-Q",
-		fmt.Sprintf("RESTORE DATABASE %s FROM DISK=N'/var/backups/%s' WITH FILE=1, NOUNLOAD, REPLACE, STATS=5, MOVE '%s' TO '/var/opt/mssql/data/%s.mdf', MOVE '%s' TO '/var/opt/mssql/data/%s.ldf'", params.DatabaseName, params.Filename, params.DataName, params.DatabaseName, params.LogName, params.DatabaseName),
-	}
+		restoreStatement,
+	)
 
-	_, err := execute(restoreArgs)
+	output, err := executeWithPassword(ctx, restoreArgs, params.Password)
 	if err != nil {
-		return err
+		return getSQLError(err, output)
 	}
-	fmt.Printf("Restore has been completed (as database %s).\n", params.DatabaseName)
-	return nil
+	log.Info("Restore has been completed")
+	return completeRestore(ctx, &params.BaseRestoreParameters, server, runBatch)
 }
 
-// GetLogicalNames retrieve logical names of MDF and LDF
-func (c *DockerSQLClient) GetLogicalNames(params *DatabaseParameters) (string, string, error) {
-	dataNameQuery := "SELECT name FROM sys.master_files WHERE database_id = db_id() AND type = 0"
-	logNameQuery := "SELECT name FROM sys.master_files WHERE database_id = db_id() AND type = 1"
+// getContainerBatchRunner returns a runner of batches in the SQL server of
+// the container, which are passed via the input of sqlcmd
+func getContainerBatchRunner(containerName string, sqlcmd []string, password string) sqlBatchRunner {
+	return func(ctx context.Context, batch string) (string, error) {
+		args := append([]string{"exec", "-i", "-e", sqlcmdPasswordVariable, containerName}, sqlcmd...)
+		args = append(args, "-S", ".", "-U", "sa")
+		args = append(args, sqlcmdOutputArgs...)
+		args = append(args, "-x")
+		output, err := executeDocker(ctx, args, strings.NewReader(batch), []string{fmt.Sprintf("%s=%s", sqlcmdPasswordVariable, password)})
+		return output, getSQLError(err, output)
+	}
+}
 
-	outputData, errData := execute(getCommandArgs(c.clientContainerName, params, dataNameQuery))
+// GetLogicalNames retrieve logical names of MDF and LDF
+func (c *DockerSQLClient) GetLogicalNames(ctx context.Context, params *DatabaseParameters) (string, string, error) {
+	outputData, errData := c.runQuery(ctx, params, getLogicalNameQuery(dataFileType))
 	if errData != nil {
 		return "", "", errData
 	}
-	dataName := getSQLOutput(outputData)
+	dataName, errDataName := getSQLValue(outputData)
+	if errDataName != nil {
+		return "", "", errDataName
+	}
 
-	outputLog, errLog := execute(getCommandArgs(c.clientContainerName, params, logNameQuery))
+	outputLog, errLog := c.runQuery(ctx, params, getLogicalNameQuery(logFileType))
 	if errLog != nil {
 		return "", "", errLog
 	}
-	logName := getSQLOutput(outputLog)
+	logName, errLogName := getSQLValue(outputLog)
+	if errLogName != nil {
+		return "", "", errLogName
+	}
 
 	return dataName, logName, nil
 }
 
-func execute(args []string) (string, error) {
-	if viper.GetBool("verbose") {
-		fmt.Println("Command executed:", "docker", args)
-	}
-	byteOutput, err := exec.Command("docker", args...).Output()
+func (c *DockerSQLClient) runQuery(ctx context.Context, params *DatabaseParameters, query *sqlQuery) (string, error) {
+	args, err := getCommandArgs(params, query)
 	if err != nil {
-		if strings.Contains(err.Error(), "125") {
-			return string(byteOutput), errors.New("Please disable DOCKER_CONTENT_TRUST")
-		}
+		return "", err
 	}
-	return string(byteOutput), err
+	output, errQuery := executeWithPassword(ctx, args, params.Password)
+	return output, getSQLError(errQuery, output)
 }
 
-func getSQLOutput(rawOutput string) string {
-	lines := strings.Split(rawOutput, "\n")
-	return strings.TrimSpace(lines[2])
-}
-
-func getCommandArgs(clientContainerName string, params *DatabaseParameters, statement string) []string {
-	return []string{
+// waitForServer waits until SQL server in the container accepts queries, and
+// fails if it does not within serverStartupTimeout
+func waitForServer(ctx context.Context, containerName string, sqlcmd []string, password string) error {
+	args := append([]string{
 		"exec",
-		"-t",
-		clientContainerName,
-		"/opt/mssql-tools/bin/sqlcmd",
+		"-e",
+		sqlcmdPasswordVariable,
+		containerName,
+	}, sqlcmd...)
+	args = append(args,
 		"-S",
-		params.Server,
-		"-d",
-		params.DatabaseName,
+		".",
 		"-U",
-		params.Username,
-		"-P",
-		params.Password,
+		"sa",
 		"-Q",
-		statement,
+		"SELECT 1",
+	)
+	deadline := time.After(serverStartupTimeout)
+	for {
+		_, err := executeWithPassword(ctx, args, password)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return NewError(ErrorKindEnvironment, fmt.Errorf("SQL server in container %s did not accept connections within %s: %w", containerName, serverStartupTimeout, err))
+		case <-time.After(serverStartupPollInterval):
+		}
 	}
 }
 
-func isDockerInstalled() bool {
-	_, err := execute([]string{"help"})
-	return err == nil
-}
+// copyDecryptedBackupToContainer streams the decrypted content of an encrypted
+// backup into a file in a container
+func copyDecryptedBackupToContainer(ctx context.Context, pathToBak string, containerName string, containerPath string) error {
+	in, err := os.Open(pathToBak)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 
-func isDockerContentTrustDisabled() bool {
-	return os.Getenv("DOCKER_CONTENT_TRUST") != "1"
-}
+	reader, err := NewDecryptReader(in, encryptionKey)
+	if err != nil {
+		return err
+	}
 
-func getSQLServerContainerName() string {
 	args := []string{
-		"ps",
-		"-f",
-		"ancestor=microsoft/mssql-server-linux",
-		"-f",
-		"status=running",
-		"--format",
-		"{{.Names}}",
-	}
-	output, err := execute(args)
-	if err != nil {
-		return ""
+		"exec",
+		"-i",
+		containerName,
+		"/bin/sh",
+		"-c",
+		`mkdir -p "$(dirname "$0")" && cat > "$0"`,
+		containerPath,
 	}
-	return strings.Split(output, "\n")[0]
+	_, err = executeWithInput(ctx, args, reader)
+	return err
 }
 
-func isSQLCommandContainerExist() bool {
-	args := []string{
-		"ps",
-		"-a",
-		"-f",
-		"name=mssql-sqlcmd",
-		"--format",
-		"{{.Names}}",
-	}
-	output, err := execute(args)
-	if err != nil {
-		return false
+// getContainerSQLCmd returns the command of sqlcmd in a container of the
+// image; the tools of SQL server 2022 verify the certificate of the server
+// unless -C is specified
+func getContainerSQLCmd(image string) []string {
+	if image == SQLServer2022Image {
+		return []string{"/opt/mssql-tools18/bin/sqlcmd", "-C"}
 	}
-	return strings.Split(output, "\n")[0] != ""
+	return []string{"/opt/mssql-tools/bin/sqlcmd"}
 }
 
-func removeSQLCommandContainer() error {
-	args := []string{
-		"rm",
-		"mssql-sqlcmd",
+func execute(ctx context.Context, args []string) (string, error) {
+	return executeDocker(ctx, args, nil, nil)
+}
+
+func executeWithInput(ctx context.Context, args []string, stdin io.Reader) (string, error) {
+	return executeDocker(ctx, args, stdin, nil)
+}
+
+// executeWithPassword passes the password to sqlcmd in a container via an
+// environment variable so that it does not appear in the arguments
+func executeWithPassword(ctx context.Context, args []string, password string) (string, error) {
+	return executeDocker(ctx, args, nil, []string{fmt.Sprintf("%s=%s", sqlcmdPasswordVariable, password)})
+}
+
+func executeDocker(ctx context.Context, args []string, stdin io.Reader, environment []string) (string, error) {
+	output, err := runCommand(ctx, &Command{Name: "docker", Args: args, Environment: environment, Stdin: stdin})
+	if err != nil && ctx.Err() == nil {
+		if strings.Contains(err.Error(), "125") {
+			return output, NewError(ErrorKindEnvironment, errors.New("Please disable DOCKER_CONTENT_TRUST"))
+		}
 	}
-	_, err := execute(args)
-	return err
+	return output, err
 }
 
-func createSQLCommandContainer() (string, error) {
+func getCommandArgs(params *DatabaseParameters, query *sqlQuery) ([]string, error) {
+	statement, err := query.render()
+	if err != nil {
+		return nil, err
+	}
 	args := []string{
 		"run",
-		"--name",
-		"mssql-sqlcmd",
+		"--rm",
 		"-e",
-		"ACCEPT_EULA=Y",
+		sqlcmdPasswordVariable,
+		SQLToolsImage,
+		"/opt/mssql-tools/bin/sqlcmd",
+		"-S",
+		params.Server,
 		"-d",
-		"microsoft/mssql-server-linux",
+		params.DatabaseName,
+		"-U",
+		params.Username,
 	}
-	_, err := execute(args)
+	args = append(args, sqlcmdOutputArgs...)
+	return append(args, "-x", "-Q", statement), nil
+}
+
+// GetDockerServerVersion returns the version of the Docker daemon, which
+// fails if the daemon cannot be reached
+func GetDockerServerVersion(ctx context.Context) (string, error) {
+	output, err := execute(ctx, []string{"version", "--format", "{{.Server.Version}}"})
 	if err != nil {
-		return "", err
+		return "", NewError(ErrorKindEnvironment, err)
 	}
-	return "mssql-sqlcmd", nil
+	return strings.TrimSpace(output), nil
+}
+
+// IsDockerImageAvailable returns if the image has been pulled to this machine
+func IsDockerImageAvailable(ctx context.Context, image string) bool {
+	_, err := execute(ctx, []string{"image", "inspect", "--format", "{{.Id}}", image})
+	return err == nil
+}
+
+// IsDockerContentTrustDisabled returns if Docker Content Trust, which images of SQL server are not signed for, is disabled
+func IsDockerContentTrustDisabled() bool {
+	return os.Getenv("DOCKER_CONTENT_TRUST") != "1"
+}
+
+// removeCancelledContainer removes a container which has been (or is being)
+// created for a restore which is cancelled
+func removeCancelledContainer(ctx context.Context, containerName string) {
+	if ctx.Err() == nil {
+		return
+	}
+	cleanupCtx, cancel := getCleanupContext()
+	defer cancel()
+	if _, err := execute(cleanupCtx, []string{"rm", "-f", containerName}); err != nil {
+		logger.Warn("Unable to remove container of cancelled restore", "container", containerName, "error", err)
+		return
+	}
+	logger.Info("Removed container of cancelled restore", "container", containerName)
 }
 
 func getPathToBak(params *BaseRestoreParameters) string {
+	storedFilename := getStoredFilename(params.Filename)
 	if params.DownloadDirectory != "" {
-		return filepath.Join(params.DownloadDirectory, params.Filename)
+		return filepath.Join(params.DownloadDirectory, storedFilename)
+	}
+	if params.CacheDirectory != "" && params.BucketName != "" {
+		cache := &BackupCache{Directory: params.CacheDirectory}
+		entry, err := cache.Find(params.BucketName, params.Filename)
+		if err == nil && entry != nil {
+			return entry.Path
+		}
 	}
 	currentDirectory, _ := os.Getwd()
-	return filepath.Join(currentDirectory, params.Filename)
+	return filepath.Join(currentDirectory, storedFilename)
 }
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForServer(t *testing.T) {
+	defer func(timeout time.Duration, interval time.Duration) {
+		serverStartupTimeout = timeout
+		serverStartupPollInterval = interval
+		UseCommandRunner(nil)
+	}(serverStartupTimeout, serverStartupPollInterval)
+	serverStartupTimeout = 50 * time.Millisecond
+	serverStartupPollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		failures      int
+		expectedError string
+	}{
+		{name: "server accepts connections at once"},
+		{name: "server accepts connections once started", failures: 2},
+		{name: "server never accepts connections", failures: 1000, expectedError: "SQL server in container restored did not accept connections within 50ms"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			UseCommandRunner(funcRunner(func(command *Command) (string, error) {
+				attempts++
+				if attempts <= test.failures {
+					return "", errors.New("Login timeout expired")
+				}
+				return "1\n", nil
+			}))
+
+			err := waitForServer(context.Background(), "restored", []string{"/opt/mssql-tools18/bin/sqlcmd"}, "Passw0rd")
+
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("expected no error but got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Fatalf("expected error containing %q but got %v", test.expectedError, err)
+			}
+			if GetErrorKind(err) != ErrorKindEnvironment {
+				t.Errorf("expected error of kind %s but got %s", ErrorKindEnvironment, GetErrorKind(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// EncryptedExtension is appended to the file names of encrypted backups
+const EncryptedExtension = ".enc"
+
+// encrypted backups are written as a header followed by AES-GCM sealed
+// chunks, each with a nonce derived from a random prefix, the chunk index
+// and a flag marking the last chunk so that truncation is detected
+const encryptionMagic = "RDSBAKE1"
+const encryptionKeySize = 32
+const encryptionNoncePrefixSize = 7
+const encryptionChunkSize = 64 * 1024
+
+// encryptionKey is used to encrypt downloaded backups and to decrypt them in restore
+var encryptionKey []byte
+
+// UseEncryptionKey makes downloaded backups to be kept encrypted with the specified key
+func UseEncryptionKey(key []byte) error {
+	if len(key) != encryptionKeySize {
+		return fmt.Errorf("Encryption key must be %d bytes long", encryptionKeySize)
+	}
+	encryptionKey = key
+	return nil
+}
+
+// ReadEncryptionKey reads a base64 encoded key from a file or, if the file is
+// not specified, from the specified environment variable
+func ReadEncryptionKey(keyFile string, keyEnvironmentVariable string) ([]byte, error) {
+	encodedKey := ""
+	if keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encodedKey = string(content)
+	} else if keyEnvironmentVariable != "" {
+		encodedKey = os.Getenv(keyEnvironmentVariable)
+		if encodedKey == "" {
+			return nil, NewError(ErrorKindEnvironment, fmt.Errorf("Environment variable %s is not set", keyEnvironmentVariable))
+		}
+	} else {
+		return nil, NewError(ErrorKindValidation, errors.New("Either a key file or an environment variable of the encryption key must be specified"))
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, NewError(ErrorKindValidation, fmt.Errorf("Encryption key must be base64 encoded: %s", err.Error()))
+	}
+	if len(key) != encryptionKeySize {
+		return nil, NewError(ErrorKindValidation, fmt.Errorf("Encryption key must be %d bytes long", encryptionKeySize))
+	}
+	return key, nil
+}
+
+// EncryptFile encrypts the file at src with the specified key into dst
+func EncryptFile(src string, dst string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer, err := NewEncryptWriter(out, key)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, in); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// DecryptFile decrypts the file at src with the specified key into dst
+func DecryptFile(src string, dst string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := NewDecryptReader(in, key)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, reader); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+type encryptWriter struct {
+	writer      io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint32
+	buffer      []byte
+}
+
+// NewEncryptWriter returns a writer encrypting everything written to it into w;
+// it must be closed to write the last chunk
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	noncePrefix := make([]byte, encryptionNoncePrefixSize)
+	if _, err = rand.Read(noncePrefix); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write([]byte(encryptionMagic)); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(noncePrefix); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		writer:      w,
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		buffer:      make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives as the last
+		// chunk has to be sealed differently
+		if len(e.buffer) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		size := encryptionChunkSize - len(e.buffer)
+		if size > len(p) {
+			size = len(p)
+		}
+		e.buffer = append(e.buffer, p[:size]...)
+		p = p[size:]
+		written += size
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(isLast bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("Backup is too large to be encrypted")
+	}
+	sealed := e.aead.Seal(nil, getChunkNonce(e.noncePrefix, e.counter, isLast), e.buffer, nil)
+	if _, err := e.writer.Write(sealed); err != nil {
+		return err
+	}
+	e.counter++
+	e.buffer = e.buffer[:0]
+	return nil
+}
+
+type decryptReader struct {
+	reader      *bufio.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint32
+	record      []byte
+	plaintext   []byte
+	isDone      bool
+}
+
+// NewDecryptReader returns a reader of the decrypted content of r
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptionMagic)+encryptionNoncePrefixSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, errors.New("Backup is not encrypted by rds-backup")
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], []byte(encryptionMagic)) {
+		return nil, errors.New("Backup is not encrypted by rds-backup")
+	}
+	return &decryptReader{
+		reader:      bufio.NewReader(r),
+		aead:        aead,
+		noncePrefix: header[len(encryptionMagic):],
+		record:      make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plaintext) == 0 {
+		if d.isDone {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plaintext)
+	d.plaintext = d.plaintext[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.reader, d.record)
+	isLast := false
+	switch err {
+	case nil:
+		_, errPeek := d.reader.Peek(1)
+		isLast = errPeek == io.EOF
+	case io.ErrUnexpectedEOF:
+		isLast = true
+	case io.EOF:
+		return errors.New("Encrypted backup is truncated")
+	default:
+		return err
+	}
+
+	plaintext, errOpen := d.aead.Open(nil, getChunkNonce(d.noncePrefix, d.counter, isLast), d.record[:n], nil)
+	if errOpen != nil {
+		return errors.New("Unable to decrypt backup. Either the key is wrong or the backup is corrupted")
+	}
+	d.counter++
+	d.plaintext = plaintext
+	d.isDone = isLast
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func getChunkNonce(noncePrefix []byte, counter uint32, isLast bool) []byte {
+	nonce := make([]byte, encryptionNoncePrefixSize+5)
+	copy(nonce, noncePrefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefixSize:], counter)
+	if isLast {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func getStoredFilename(filename string) string {
+	if encryptionKey != nil {
+		return filename + EncryptedExtension
+	}
+	return filename
+}
+
+// getOriginalFilename returns the file name of a backup stored locally and
+// whether the backup is stored as the current encryption setting expects
+func getOriginalFilename(storedFilename string) (string, bool) {
+	isEncrypted := strings.HasSuffix(storedFilename, EncryptedExtension)
+	return strings.TrimSuffix(storedFilename, EncryptedExtension), isEncrypted == (encryptionKey != nil)
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, encryptionKeySize)
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"less than a chunk", 100},
+		{"exactly a chunk", encryptionChunkSize},
+		{"multiple chunks", 2*encryptionChunkSize + 17},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext := getTestPlaintext(test.size)
+			encrypted := encryptTestContent(t, plaintext, key)
+
+			decrypted, err := decryptTestContent(encrypted, key)
+
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("expected %d bytes of plaintext but got %d different bytes", len(plaintext), len(decrypted))
+			}
+		})
+	}
+}
+
+func TestDecryptionFailures(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, encryptionKeySize)
+	headerSize := len(encryptionMagic) + encryptionNoncePrefixSize
+	recordSize := encryptionChunkSize + 16
+	encrypted := encryptTestContent(t, getTestPlaintext(3*encryptionChunkSize+5), key)
+
+	tests := []struct {
+		name          string
+		content       []byte
+		key           []byte
+		expectedError string
+	}{
+		{
+			name:          "truncated at a chunk boundary",
+			content:       encrypted[:headerSize+2*recordSize],
+			key:           key,
+			expectedError: "key is wrong or the backup is corrupted",
+		},
+		{
+			name:          "truncated within a chunk",
+			content:       encrypted[:len(encrypted)-3],
+			key:           key,
+			expectedError: "key is wrong or the backup is corrupted",
+		},
+		{
+			name:          "last chunk removed",
+			content:       encrypted[:headerSize+3*recordSize],
+			key:           key,
+			expectedError: "key is wrong or the backup is corrupted",
+		},
+		{
+			name:          "reordered chunks",
+			content:       swapRecords(encrypted, headerSize, recordSize, 0, 1),
+			key:           key,
+			expectedError: "key is wrong or the backup is corrupted",
+		},
+		{
+			name:          "wrong key",
+			content:       encrypted,
+			key:           bytes.Repeat([]byte{2}, encryptionKeySize),
+			expectedError: "key is wrong or the backup is corrupted",
+		},
+		{
+			name:          "not encrypted",
+			content:       []byte("TAPE backup"),
+			key:           key,
+			expectedError: "not encrypted by rds-backup",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decryptTestContent(test.content, test.key)
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Errorf("expected error containing %q but got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func getTestPlaintext(size int) []byte {
+	plaintext := make([]byte, size)
+	for i := range plaintext {
+		plaintext[i] = byte(i % 251)
+	}
+	return plaintext
+}
+
+func encryptTestContent(t *testing.T, plaintext []byte, key []byte) []byte {
+	var encrypted bytes.Buffer
+	writer, err := NewEncryptWriter(&encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+func decryptTestContent(content []byte, key []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(bytes.NewReader(content), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// swapRecords returns a copy of the encrypted content with two of its sealed chunks swapped
+func swapRecords(content []byte, headerSize int, recordSize int, i int, j int) []byte {
+	swapped := append([]byte(nil), content...)
+	first := swapped[headerSize+i*recordSize : headerSize+(i+1)*recordSize]
+	second := swapped[headerSize+j*recordSize : headerSize+(j+1)*recordSize]
+	temporary := append([]byte(nil), first...)
+	copy(first, second)
+	copy(second, temporary)
+	return swapped
+}
+
+func TestDownloadEncryptedBackup(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, encryptionKeySize)
+	encryptionKey = key
+	defer func() {
+		encryptionKey = nil
+		UseCommandRunner(nil)
+	}()
+	plaintext := getTestPlaintext(encryptionChunkSize + 1)
+	UseCommandRunner(funcRunner(func(command *Command) (string, error) {
+		_, err := command.Stdout.Write(plaintext)
+		return "", err
+	}))
+	path := filepath.Join(t.TempDir(), "db.bak"+EncryptedExtension)
+
+	if err := downloadEncryptedBackup(context.Background(), "bucket", "db.bak", path); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, errDecrypt := decryptTestContent(content, key)
+	if errDecrypt != nil {
+		t.Fatalf("expected no error but got %v", errDecrypt)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected downloaded backup to be decrypted to the original content")
+	}
+}
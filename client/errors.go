@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorKind classifies the errors of operations so that callers can tell apart the causes of failures
+type ErrorKind int
+
+const (
+	// ErrorKindUnknown is an error which is not classified
+	ErrorKindUnknown ErrorKind = iota
+	// ErrorKindValidation is an error in the options or the configuration
+	ErrorKindValidation
+	// ErrorKindEnvironment is an error due to a missing tool or setting on this machine (such as AWS CLI, Docker or sqlcmd)
+	ErrorKindEnvironment
+	// ErrorKindAuth is an error in authenticating with AWS or the SQL server
+	ErrorKindAuth
+	// ErrorKindTask is an error in starting or running a backup task on RDS
+	ErrorKindTask
+	// ErrorKindTransfer is an error in listing, downloading or copying backups on S3
+	ErrorKindTransfer
+	// ErrorKindRestore is an error in restoring a backup
+	ErrorKindRestore
+)
+
+// authFailureMarkers are found in the output of AWS CLI and sqlcmd when credentials are rejected
+var authFailureMarkers = []string{
+	"Login failed for user",
+	"AccessDenied",
+	"ExpiredToken",
+	"InvalidAccessKeyId",
+	"InvalidClientTokenId",
+	"SignatureDoesNotMatch",
+	"UnrecognizedClientException",
+	"Unable to locate credentials",
+}
+
+// Error is an error of a known kind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// NewError classifies an error as the specified kind unless it has already been classified
+func NewError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	if GetErrorKind(err) != ErrorKindUnknown {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// GetErrorKind returns the kind of an error or ErrorKindUnknown if it is not classified
+func GetErrorKind(err error) ErrorKind {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Kind
+	}
+	return ErrorKindUnknown
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindValidation:
+		return "validation"
+	case ErrorKindEnvironment:
+		return "environment"
+	case ErrorKindAuth:
+		return "auth"
+	case ErrorKindTask:
+		return "task"
+	case ErrorKindTransfer:
+		return "transfer"
+	case ErrorKindRestore:
+		return "restore"
+	}
+	return "unknown"
+}
+
+// getCommandError returns the error of the context if an external command
+// is interrupted as the context is done, or classifies the error of the
+// command as an authentication failure when its output says so
+func getCommandError(ctx context.Context, err error, output string) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	text := output
+	var commandError *CommandError
+	if errors.As(err, &commandError) {
+		text += commandError.Stderr
+	}
+	if isAuthFailure(text) {
+		return &Error{Kind: ErrorKindAuth, Err: fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(text))}
+	}
+	return err
+}
+
+// isAuthFailure returns if the text contains a message of rejected credentials
+func isAuthFailure(text string) bool {
+	for _, marker := range authFailureMarkers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewErrorKeepsFirstKind(t *testing.T) {
+	auth := NewError(ErrorKindAuth, errors.New("Login failed for user 'admin'"))
+	transfer := NewError(ErrorKindTransfer, fmt.Errorf("download: %w", auth))
+
+	if kind := GetErrorKind(transfer); kind != ErrorKindAuth {
+		t.Errorf("expected %s but got %s", ErrorKindAuth, kind)
+	}
+	if NewError(ErrorKindRestore, nil) != nil {
+		t.Error("expected nil for nil error")
+	}
+	if kind := GetErrorKind(errors.New("unclassified")); kind != ErrorKindUnknown {
+		t.Errorf("expected %s but got %s", ErrorKindUnknown, kind)
+	}
+}
+
+func TestGetCommandError(t *testing.T) {
+	tests := []struct {
+		output   string
+		stderr   string
+		expected ErrorKind
+	}{
+		{"Sqlcmd: Error: Microsoft ODBC Driver 17 for SQL Server : Login failed for user 'admin'.", "", ErrorKindAuth},
+		{"", "An error occurred (ExpiredToken) when calling the ListObjectsV2 operation", ErrorKindAuth},
+		{"", "An error occurred (AccessDenied) when calling the GetObject operation: Access Denied", ErrorKindAuth},
+		{"", "An error occurred (NoSuchBucket) when calling the ListObjectsV2 operation", ErrorKindUnknown},
+	}
+
+	for _, test := range tests {
+		err := getCommandError(context.Background(), &CommandError{Err: errors.New("exit status 1"), Stderr: test.stderr}, test.output)
+		if kind := GetErrorKind(err); kind != test.expected {
+			t.Errorf("%s%s: expected %s but got %s", test.output, test.stderr, test.expected, kind)
+		}
+	}
+	if getCommandError(context.Background(), nil, "Login failed for user") != nil {
+		t.Error("expected nil for successful command")
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	// registers the sqlserver driver of database/sql
+	_ "github.com/microsoft/go-mssqldb"
+)
+
+// GoClient is a SQL client connecting to SQL server by itself, which needs
+// neither sqlcmd nor Docker on this machine
+type GoClient struct{}
+
+// IsEnvironmentSatisfied returns if this client can be run on this machine,
+// which is always the case
+func (c *GoClient) IsEnvironmentSatisfied(ctx context.Context) bool {
+	return true
+}
+
+// GetServerVersion returns the version of the SQL server, which fails if the login fails
+func (c *GoClient) GetServerVersion(ctx context.Context, params *DatabaseParameters) (string, error) {
+	return c.queryValue(ctx, params, getServerVersionQuery())
+}
+
+// GetStatus returns the status of the latest backup
+func (c *GoClient) GetStatus(ctx context.Context, params *DatabaseParameters, taskID string) (string, error) {
+	query, errQuery := getStatusQuery(params.DatabaseName, taskID)
+	if errQuery != nil {
+		return "", errQuery
+	}
+	return c.queryValue(ctx, params, query)
+}
+
+// GetCompletionPercentage returns the percentage of completion of the latest backup
+func (c *GoClient) GetCompletionPercentage(ctx context.Context, params *DatabaseParameters) (string, error) {
+	return c.queryValue(ctx, params, getCompletionPercentageQuery(params.DatabaseName))
+}
+
+// GetTaskMessage returns the message of the latest backup task
+func (c *GoClient) GetTaskMessage(ctx context.Context, params *DatabaseParameters) (string, error) {
+	return c.queryValue(ctx, params, getTaskMessageQuery(params.DatabaseName))
+}
+
+// StartBackup creates a new backup
+func (c *GoClient) StartBackup(ctx context.Context, params *BackupParameters) (string, error) {
+	taskID, err := c.queryValue(ctx, &params.DatabaseParameters, getStartBackupQuery(params))
+	if err != nil {
+		return "", NewError(ErrorKindTask, err)
+	}
+	return taskID, nil
+}
+
+// GetLogicalNames returns the logical names of MDF and LDF
+func (c *GoClient) GetLogicalNames(ctx context.Context, params *DatabaseParameters) (string, string, error) {
+	dataName, errData := c.queryValue(ctx, params, getLogicalNameQuery(dataFileType))
+	if errData != nil {
+		return "", "", errData
+	}
+	logName, errLog := c.queryValue(ctx, params, getLogicalNameQuery(logFileType))
+	if errLog != nil {
+		return "", "", errLog
+	}
+	return dataName, logName, nil
+}
+
+// queryValue returns the first column of the first row of the first result
+// set having a row; the parameters of the query are bound rather than rendered
+func (c *GoClient) queryValue(ctx context.Context, params *DatabaseParameters, query *sqlQuery) (string, error) {
+	db, err := sql.Open("sqlserver", getConnectionString(params))
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	args := make([]interface{}, 0, len(query.Parameters))
+	for _, p := range query.Parameters {
+		args = append(args, sql.Named(p.Name, p.Value))
+	}
+	logger.Debug("Running query", "server", params.Server, "database", params.DatabaseName)
+	rows, errQuery := db.QueryContext(ctx, query.Text, args...)
+	if errQuery != nil {
+		return "", getDatabaseError(ctx, errQuery)
+	}
+	defer rows.Close()
+
+	for {
+		if rows.Next() {
+			var value sql.NullString
+			if errScan := rows.Scan(&value); errScan != nil {
+				return "", errScan
+			}
+			return value.String, nil
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if errRows := rows.Err(); errRows != nil {
+		return "", getDatabaseError(ctx, errRows)
+	}
+	return "", errNoSQLRecord
+}
+
+// getConnectionString returns the URL connecting to the server specified in
+// the form of sqlcmd -S; the port of a named instance is looked up via SQL
+// Server Browser unless it is specified
+func getConnectionString(params *DatabaseParameters) string {
+	host, port, instance := parseServer(params.Server)
+	connection := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(params.Username, params.Password),
+		Host:     net.JoinHostPort(host, port),
+		RawQuery: url.Values{"database": []string{params.DatabaseName}}.Encode(),
+	}
+	if instance != "" {
+		connection.Path = instance
+		if !strings.Contains(params.Server, ",") {
+			connection.Host = host
+		}
+	}
+	return connection.String()
+}
+
+// getDatabaseError returns the error of a query, which is an error of kind
+// ErrorKindAuth if the login is rejected
+func getDatabaseError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if isAuthFailure(err.Error()) {
+		return &Error{Kind: ErrorKindAuth, Err: err}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return NewError(ErrorKindEnvironment, fmt.Errorf("Unable to connect to the server: %w", err))
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunStatusSuccess is the status of a backup completed successfully
+const RunStatusSuccess = "SUCCESS"
+
+// RunStatusError is the status of a backup failed
+const RunStatusError = "ERROR"
+
+// RunStatusSkipped is the status of a backup not started as another backup of the same database was running
+const RunStatusSkipped = "SKIPPED"
+
+// RunStatusDeleted is the status of a backup removed by retention policy
+const RunStatusDeleted = "DELETED"
+
+// RunRecord is an entry of run history
+type RunRecord struct {
+	Schedule     string    `json:"schedule"`
+	DatabaseName string    `json:"database"`
+	BackupType   string    `json:"type"`
+	TaskID       string    `json:"task_id,omitempty"`
+	BucketName   string    `json:"bucket"`
+	Filename     string    `json:"filename"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// RunHistory is a log of backups kept as JSON lines in a file
+type RunHistory struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// RetentionPolicy specifies which successful backups of a schedule are kept
+type RetentionPolicy struct {
+	Count  int
+	MaxAge time.Duration
+}
+
+// NewRunHistory returns a run history stored in the specified file
+func NewRunHistory(path string) *RunHistory {
+	return &RunHistory{path: path}
+}
+
+// Append adds a record to the history
+func (h *RunHistory) Append(record RunRecord) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(content, '\n')); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// List returns all records in the history in the order they were added
+func (h *RunHistory) List() ([]RunRecord, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	records := []RunRecord{}
+	file, err := os.Open(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record := RunRecord{}
+		if errJSON := json.Unmarshal([]byte(line), &record); errJSON != nil {
+			return nil, errJSON
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ApplyRetention deletes the successful backups of a schedule which are not
+// kept by the policy and returns the file names of the deleted backups; the
+// latest full backup and the differential backups after it are always kept
+func (h *RunHistory) ApplyRetention(ctx context.Context, schedule string, policy RetentionPolicy, now time.Time) ([]string, error) {
+	if policy.Count <= 0 && policy.MaxAge <= 0 {
+		return nil, nil
+	}
+	records, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := map[string]bool{}
+	for _, record := range records {
+		if record.Schedule == schedule && record.Status == RunStatusDeleted {
+			deleted[getObjectKey(record.BucketName, record.Filename)] = true
+		}
+	}
+	backups := []RunRecord{}
+	for _, record := range records {
+		if record.Schedule == schedule && record.Status == RunStatusSuccess && !deleted[getObjectKey(record.BucketName, record.Filename)] {
+			backups = append(backups, record)
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].StartedAt.After(backups[j].StartedAt)
+	})
+
+	isLatestFullFound := false
+	filenames := []string{}
+	for i, backup := range backups {
+		if !isLatestFullFound {
+			isLatestFullFound = backup.BackupType != BackupTypeDifferential
+			continue
+		}
+		isKept := true
+		if policy.Count > 0 && i >= policy.Count {
+			isKept = false
+		}
+		if policy.MaxAge > 0 && now.Sub(backup.StartedAt) > policy.MaxAge {
+			isKept = false
+		}
+		if isKept {
+			continue
+		}
+
+		if errDelete := DeleteBackup(ctx, backup.BucketName, backup.Filename); errDelete != nil {
+			return filenames, errDelete
+		}
+		backup.Status = RunStatusDeleted
+		backup.Error = ""
+		backup.FinishedAt = now
+		if errAppend := h.Append(backup); errAppend != nil {
+			return filenames, errAppend
+		}
+		filenames = append(filenames, backup.Filename)
+	}
+	return filenames, nil
+}
+
+func getObjectKey(bucketName string, filename string) string {
+	return fmt.Sprintf("%s/%s", bucketName, filename)
+}
+
+// lockNameReplacedPattern matches the characters of servers and databases
+// which are not kept in the names of lock files
+var lockNameReplacedPattern = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// GetDatabaseLockName returns the name of the lock of a database on a server
+// as databases of the same name on different servers are locked separately
+func GetDatabaseLockName(server string, databaseName string) string {
+	return fmt.Sprintf("%s_%s", lockNameReplacedPattern.ReplaceAllString(server, "-"), lockNameReplacedPattern.ReplaceAllString(databaseName, "-"))
+}
+
+// AcquireLock creates a lock file in the specified directory so that only one
+// process works on the named resource at a time, and returns a function to
+// release the lock
+func AcquireLock(directory string, name string) (func(), error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(directory, fmt.Sprintf("%s.lock", name))
+
+	// the pid is written to a temporary file which is then linked as the lock
+	// so that other processes never read a lock without the pid of its owner
+	temp, errTemp := os.CreateTemp(directory, fmt.Sprintf("%s.*.tmp", name))
+	if errTemp != nil {
+		return nil, errTemp
+	}
+	defer os.Remove(temp.Name())
+	_, errWrite := fmt.Fprintf(temp, "%d", os.Getpid())
+	if errClose := temp.Close(); errWrite == nil {
+		errWrite = errClose
+	}
+	if errWrite != nil {
+		return nil, errWrite
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(temp.Name(), path)
+		if err == nil {
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if !isStaleLock(path) {
+			return nil, fmt.Errorf("%s is locked by another process", name)
+		}
+		os.Remove(path)
+	}
+	return nil, fmt.Errorf("Unable to lock %s", name)
+}
+
+// isStaleLock returns if the process holding the lock no longer exists
+func isStaleLock(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return true
+	}
+	return !isProcessRunning(pid)
+}
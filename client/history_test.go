@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	// backups of every day at 02:00 (full) and 14:00 (differential), the most recent first
+	records := []RunRecord{
+		{Filename: "db-diff-20240309140000.bak", BackupType: BackupTypeDifferential, StartedAt: now.Add(-22 * time.Hour)},
+		{Filename: "db-20240309020000.bak", BackupType: BackupTypeFull, StartedAt: now.Add(-34 * time.Hour)},
+		{Filename: "db-diff-20240308140000.bak", BackupType: BackupTypeDifferential, StartedAt: now.Add(-46 * time.Hour)},
+		{Filename: "db-20240308020000.bak", BackupType: BackupTypeFull, StartedAt: now.Add(-58 * time.Hour)},
+		{Filename: "db-20240307020000.bak", BackupType: BackupTypeFull, StartedAt: now.Add(-82 * time.Hour), Status: RunStatusError},
+	}
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		expected []string
+	}{
+		{
+			name:     "no policy",
+			policy:   RetentionPolicy{},
+			expected: nil,
+		},
+		{
+			name:     "count",
+			policy:   RetentionPolicy{Count: 3},
+			expected: []string{"db-20240308020000.bak"},
+		},
+		{
+			name:     "age",
+			policy:   RetentionPolicy{MaxAge: 40 * time.Hour},
+			expected: []string{"db-diff-20240308140000.bak", "db-20240308020000.bak"},
+		},
+		{
+			name:     "latest full backup is kept",
+			policy:   RetentionPolicy{Count: 1, MaxAge: time.Hour},
+			expected: []string{"db-diff-20240308140000.bak", "db-20240308020000.bak"},
+		},
+	}
+	defer UseCommandRunner(nil)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := NewRunHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+			for _, record := range records {
+				record.Schedule = "nightly"
+				record.BucketName = "bucket"
+				if record.Status == "" {
+					record.Status = RunStatusSuccess
+				}
+				if err := history.Append(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			runner := &stubRunner{}
+			UseCommandRunner(runner)
+
+			deleted, err := history.ApplyRetention(context.Background(), "nightly", test.policy, now)
+
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if strings.Join(deleted, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v to be deleted but got %v", test.expected, deleted)
+			}
+			if len(runner.commands) != len(test.expected) {
+				t.Errorf("expected %d commands but got %d", len(test.expected), len(runner.commands))
+			}
+
+			// deleted backups are not deleted again
+			runner.commands = nil
+			again, errAgain := history.ApplyRetention(context.Background(), "nightly", test.policy, now)
+			if errAgain != nil || len(again) != 0 || len(runner.commands) != 0 {
+				t.Errorf("expected nothing to be deleted again but got %v (%v)", again, errAgain)
+			}
+		})
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "locks")
+	name := GetDatabaseLockName("db.example.com,1433", "db")
+
+	release, err := AcquireLock(directory, name)
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	if _, errLocked := AcquireLock(directory, name); errLocked == nil || !strings.Contains(errLocked.Error(), "locked by another process") {
+		t.Errorf("expected lock to be held but got %v", errLocked)
+	}
+	releaseOther, errOther := AcquireLock(directory, GetDatabaseLockName("other.example.com", "db"))
+	if errOther != nil {
+		t.Errorf("expected database of another server to be locked separately but got %v", errOther)
+	} else {
+		releaseOther()
+	}
+	release()
+
+	releaseAgain, errAgain := AcquireLock(directory, name)
+	if errAgain != nil {
+		t.Fatalf("expected released lock to be acquired but got %v", errAgain)
+	}
+	releaseAgain()
+
+	// a lock left by a process which no longer exists is taken over
+	if errStale := os.WriteFile(filepath.Join(directory, name+".lock"), []byte("not a pid"), 0600); errStale != nil {
+		t.Fatal(errStale)
+	}
+	releaseStale, errStale := AcquireLock(directory, name)
+	if errStale != nil {
+		t.Fatalf("expected stale lock to be taken over but got %v", errStale)
+	}
+	releaseStale()
+
+	// a lock of a running process is kept
+	if errRunning := os.WriteFile(filepath.Join(directory, name+".lock"), []byte(strconv.Itoa(os.Getpid())), 0600); errRunning != nil {
+		t.Fatal(errRunning)
+	}
+	if _, errLocked := AcquireLock(directory, name); errLocked == nil || !strings.Contains(errLocked.Error(), "locked by another process") {
+		t.Errorf("expected lock of a running process to be kept but got %v", errLocked)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(directory, "*.tmp")); len(temps) > 0 {
+		t.Errorf("expected temporary files to be removed but got %v", temps)
+	}
+}
+
+func TestGetDatabaseLockName(t *testing.T) {
+	tests := []struct {
+		server   string
+		database string
+		expected string
+	}{
+		{"db.abc123.ap-southeast-1.rds.amazonaws.com", "sales", "db.abc123.ap-southeast-1.rds.amazonaws.com_sales"},
+		{"tcp:10.0.0.5,1433", "sales db", "tcp-10.0.0.5-1433_sales-db"},
+		{"localhost\\SQLEXPRESS", "../sales", "localhost-SQLEXPRESS_..-sales"},
+	}
+
+	for _, test := range tests {
+		if actual := GetDatabaseLockName(test.server, test.database); actual != test.expected {
+			t.Errorf("%s %s: expected %s but got %s", test.server, test.database, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package client
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// isProcessRunning returns if the process exists; signal 0 checks the
+// process without signalling it
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	errSignal := process.Signal(syscall.Signal(0))
+	return errSignal == nil || errors.Is(errSignal, syscall.EPERM)
+}
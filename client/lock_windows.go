@@ -0,0 +1,25 @@
+package client
+
+import (
+	"errors"
+	"syscall"
+)
+
+// stillActive is the exit code of processes which have not exited
+const stillActive = 259
+
+// isProcessRunning returns if the process exists; signals cannot be sent to
+// processes on Windows, so the process is opened to query its exit code
+func isProcessRunning(pid int) bool {
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// processes of other users exist but cannot be opened
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(handle)
+	var exitCode uint32
+	if errCode := syscall.GetExitCodeProcess(handle, &exitCode); errCode != nil {
+		return true
+	}
+	return exitCode == stillActive
+}
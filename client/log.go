@@ -0,0 +1,18 @@
+package client
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logger receives the logs of operations
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// UseLogger makes operations to be logged with the specified logger
+func UseLogger(l *slog.Logger) {
+	logger = l
+}
+
+func logCommand(name string, args []string) {
+	logger.Debug("Executing command", "command", name, "args", redact(args))
+}
@@ -0,0 +1,446 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaskProfile lists the columns of restored databases to be masked and how
+type MaskProfile struct {
+	// BatchSize is the number of rows updated in a statement (defaultMaskBatchSize if it is 0)
+	BatchSize int         `yaml:"batch_size"`
+	Tables    []MaskTable `yaml:"tables"`
+}
+
+// MaskTable is a table (such as dbo.Customers) with the columns to be masked
+type MaskTable struct {
+	Name    string       `yaml:"table"`
+	Columns []MaskColumn `yaml:"columns"`
+}
+
+// MaskColumn is a column with the rule masking it
+type MaskColumn struct {
+	Name string `yaml:"column"`
+	Rule string `yaml:"rule"`
+	// Value is the value of MaskRuleFixed
+	Value *string `yaml:"value"`
+}
+
+// MaskRuleFakeName replaces values with names picked by a hash of the values
+const MaskRuleFakeName = "fake_name"
+
+// MaskRuleHashEmail replaces values with addresses of example.invalid named by a hash of the values
+const MaskRuleHashEmail = "hash_email"
+
+// MaskRuleNullify replaces values with NULL
+const MaskRuleNullify = "nullify"
+
+// MaskRuleShuffle swaps values between random rows
+const MaskRuleShuffle = "shuffle"
+
+// MaskRuleFixed replaces values with the value of the column
+const MaskRuleFixed = "fixed"
+
+const defaultMaskBatchSize = 1000
+
+// maskedEmailDomain is the domain of addresses by MaskRuleHashEmail, which is reserved to never resolve
+const maskedEmailDomain = "@example.invalid"
+
+// fakeNamesTable is a temporary table of the names used by MaskRuleFakeName
+const fakeNamesTable = "#rds_backup_fake_names"
+
+var fakeFirstNames = []string{"Alex", "Billie", "Casey", "Dana", "Eden", "Frankie", "Gray", "Harper", "Indigo", "Jamie", "Kai", "Logan", "Morgan", "Noel", "Oakley", "Parker", "Quinn", "Riley", "Sage", "Taylor"}
+var fakeLastNames = []string{"Archer", "Baker", "Carter", "Dawson", "Ellis", "Fletcher", "Garcia", "Hayes", "Ito", "Jensen", "Kim", "Lopez", "Mason", "Nguyen", "Okafor", "Patel", "Reyes", "Silva", "Turner", "Wong"}
+
+// maskExpression is the masking of a column as an expression of the masked
+// value and a predicate of the rows still to be masked; check is a statement
+// failing the masking if the column cannot hold the masked values
+type maskExpression struct {
+	table     string
+	column    string
+	name      string
+	value     string
+	unmasked  string
+	check     string
+	parameter *sqlParameter
+}
+
+// LoadMaskProfile reads a profile in YAML and returns an error of kind
+// ErrorKindValidation if it is invalid
+func LoadMaskProfile(path string) (*MaskProfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, NewError(ErrorKindValidation, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	profile := &MaskProfile{}
+	if errYaml := decoder.Decode(profile); errYaml != nil {
+		return nil, NewError(ErrorKindValidation, fmt.Errorf("Invalid mask profile %s: %w", path, errYaml))
+	}
+	if errProfile := profile.validate(); errProfile != nil {
+		return nil, NewError(ErrorKindValidation, fmt.Errorf("Invalid mask profile %s: %w", path, errProfile))
+	}
+	return profile, nil
+}
+
+func (p *MaskProfile) validate() error {
+	messages := strings.Builder{}
+	if p.BatchSize < 0 {
+		messages.WriteString("batch_size cannot be negative\n")
+	}
+	if len(p.Tables) == 0 {
+		messages.WriteString("no tables are specified\n")
+	}
+	for _, table := range p.Tables {
+		if _, err := quoteTableName(table.Name); err != nil {
+			messages.WriteString(fmt.Sprintf("table %q: %s\n", table.Name, err.Error()))
+		}
+		if len(table.Columns) == 0 {
+			messages.WriteString(fmt.Sprintf("table %q: no columns are specified\n", table.Name))
+		}
+		for _, column := range table.Columns {
+			if column.Name == "" {
+				messages.WriteString(fmt.Sprintf("table %q: a column has no name\n", table.Name))
+			}
+			switch column.Rule {
+			case MaskRuleFakeName, MaskRuleHashEmail, MaskRuleNullify, MaskRuleShuffle:
+				if column.Value != nil {
+					messages.WriteString(fmt.Sprintf("column %s.%s: value can only be used with rule %s\n", table.Name, column.Name, MaskRuleFixed))
+				}
+			case MaskRuleFixed:
+				if column.Value == nil {
+					messages.WriteString(fmt.Sprintf("column %s.%s: value must be specified with rule %s\n", table.Name, column.Name, MaskRuleFixed))
+				}
+			default:
+				messages.WriteString(fmt.Sprintf("column %s.%s: unknown rule %q\n", table.Name, column.Name, column.Rule))
+			}
+		}
+	}
+	if messages.String() != "" {
+		return errors.New(strings.TrimSpace(messages.String()))
+	}
+	return nil
+}
+
+// quoteTableName returns a table name in the form of schema.table (or table
+// in dbo) as delimited identifiers
+func quoteTableName(name string) (string, error) {
+	schema, table, found := strings.Cut(name, ".")
+	if !found {
+		schema, table = "dbo", name
+	}
+	if schema == "" || table == "" {
+		return "", errors.New("the name must be in the form of schema.table")
+	}
+	quotedSchema, errSchema := QuoteIdentifier(schema)
+	if errSchema != nil {
+		return "", errSchema
+	}
+	quotedTable, errTable := QuoteIdentifier(table)
+	if errTable != nil {
+		return "", errTable
+	}
+	return quotedSchema + "." + quotedTable, nil
+}
+
+// maskRestoredDatabase masks the restored database by the profile (if any)
+// and verifies no unmasked rows remain; the database is dropped if masking
+// fails so that unmasked data is not left behind
+func maskRestoredDatabase(ctx context.Context, params *BaseRestoreParameters, run sqlBatchRunner) error {
+	if params.MaskProfile == nil {
+		return nil
+	}
+	log := logger.With("database", params.DatabaseName)
+	log.Info("Masking restored database")
+	err := maskDatabase(ctx, params.MaskProfile, params.DatabaseName, run)
+	if err == nil {
+		log.Info("Masking has been completed")
+		return nil
+	}
+	if errDrop := dropDatabase(params.DatabaseName, run); errDrop != nil {
+		log.Error("Unable to drop the database which failed to be masked", "error", errDrop)
+		return fmt.Errorf("Unable to mask database %s, which may contain unmasked data: %w", params.DatabaseName, err)
+	}
+	log.Warn("Dropped the database which failed to be masked")
+	return fmt.Errorf("Unable to mask database %s, which has been dropped: %w", params.DatabaseName, err)
+}
+
+func maskDatabase(ctx context.Context, profile *MaskProfile, databaseName string, run sqlBatchRunner) error {
+	expressions, err := getMaskExpressions(profile)
+	if err != nil {
+		return err
+	}
+	for i, table := range profile.Tables {
+		for j, column := range table.Columns {
+			expression := expressions[i][j]
+			batch, errBatch := getMaskColumnQuery(databaseName, expression, column.Rule, profile.getBatchSize()).render()
+			if errBatch != nil {
+				return errBatch
+			}
+			output, errMask := run(ctx, batch)
+			if errMask != nil {
+				return fmt.Errorf("Unable to mask %s.%s: %w", table.Name, column.Name, errMask)
+			}
+			changed, _ := getSQLValue(output)
+			logger.Info("Masked column", "database", databaseName, "table", table.Name, "column", column.Name, "rule", column.Rule, "rows", changed)
+		}
+	}
+	return verifyMasking(ctx, profile, databaseName, expressions, run)
+}
+
+// verifyMasking returns an error if any rows of the columns (other than
+// shuffled ones, which keep the original values) remain unmasked
+func verifyMasking(ctx context.Context, profile *MaskProfile, databaseName string, expressions [][]*maskExpression, run sqlBatchRunner) error {
+	var checks []*maskExpression
+	for i, table := range profile.Tables {
+		for j, column := range table.Columns {
+			if column.Rule != MaskRuleShuffle {
+				checks = append(checks, expressions[i][j])
+			}
+		}
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	batch, errBatch := getVerifyMaskingQuery(databaseName, checks, profile.usesFakeNames()).render()
+	if errBatch != nil {
+		return errBatch
+	}
+	output, err := run(ctx, batch)
+	if err != nil {
+		return fmt.Errorf("Unable to verify masking: %w", err)
+	}
+	var remaining []string
+	for _, record := range parseSQLRecords(output, 3) {
+		if len(record) < 3 {
+			continue
+		}
+		if count := strings.TrimSpace(record[2]); count != "0" {
+			remaining = append(remaining, fmt.Sprintf("%s rows of %s.%s", count, record[0], record[1]))
+		}
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("Unmasked rows remain: %s", strings.Join(remaining, ", "))
+	}
+	return nil
+}
+
+func (p *MaskProfile) getBatchSize() int {
+	if p.BatchSize == 0 {
+		return defaultMaskBatchSize
+	}
+	return p.BatchSize
+}
+
+func (p *MaskProfile) usesFakeNames() bool {
+	for _, table := range p.Tables {
+		for _, column := range table.Columns {
+			if column.Rule == MaskRuleFakeName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getMaskExpressions returns the expressions of the columns of the profile
+// indexed by table and column
+func getMaskExpressions(profile *MaskProfile) ([][]*maskExpression, error) {
+	var expressions [][]*maskExpression
+	parameterCount := 0
+	for _, table := range profile.Tables {
+		quotedTable, err := quoteTableName(table.Name)
+		if err != nil {
+			return nil, err
+		}
+		var columns []*maskExpression
+		for _, column := range table.Columns {
+			quotedColumn, errColumn := QuoteIdentifier(column.Name)
+			if errColumn != nil {
+				return nil, errColumn
+			}
+			expression := &maskExpression{table: quotedTable, column: quotedColumn, name: column.Name}
+			// masked values are cut to the length of the column (in characters,
+			// or 4000 for columns of MAX or non-character types)
+			length := fmt.Sprintf("COALESCE(NULLIF(COLUMNPROPERTY(OBJECT_ID(%s), %s, 'CharMaxLen'), -1), 4000)", QuoteString(quotedTable), QuoteString(column.Name))
+			switch column.Rule {
+			case MaskRuleFakeName:
+				expression.value = fmt.Sprintf("(SELECT LEFT(name, %s) FROM %s WHERE id = ABS(CHECKSUM(%s) %% %d))", length, fakeNamesTable, quotedColumn, len(fakeFirstNames)*len(fakeLastNames))
+				expression.unmasked = fmt.Sprintf("%[1]s IS NOT NULL AND %[1]s NOT IN (SELECT LEFT(name, %[2]s) FROM %[3]s)", quotedColumn, length, fakeNamesTable)
+			case MaskRuleHashEmail:
+				// the hash is shortened rather than the domain so that addresses never resolve
+				expression.value = fmt.Sprintf("LOWER(LEFT(CONVERT(VARCHAR(64), HASHBYTES('SHA2_256', LOWER(CAST(%[1]s AS NVARCHAR(4000)))), 2), CASE WHEN %[2]s - %[3]d < 16 THEN %[2]s - %[3]d ELSE 16 END)) + '%[4]s'", quotedColumn, length, len(maskedEmailDomain), maskedEmailDomain)
+				expression.unmasked = fmt.Sprintf("%[1]s IS NOT NULL AND %[1]s NOT LIKE '%%%[2]s'", quotedColumn, maskedEmailDomain)
+				message := fmt.Sprintf("%s.%s is too narrow for masked addresses, which need at least %d characters", quotedTable, quotedColumn, len(maskedEmailDomain)+1)
+				expression.check = fmt.Sprintf("IF %s <= %d\n\tTHROW 50000, %s, 1;\n", length, len(maskedEmailDomain), QuoteString(message))
+			case MaskRuleNullify:
+				expression.value = "NULL"
+				expression.unmasked = fmt.Sprintf("%s IS NOT NULL", quotedColumn)
+			case MaskRuleFixed:
+				parameter := param("value_"+strconv.Itoa(parameterCount), *column.Value)
+				parameterCount++
+				expression.parameter = &parameter
+				expression.value = fmt.Sprintf("LEFT(@%s, %s)", parameter.Name, length)
+				expression.unmasked = fmt.Sprintf("(%[1]s IS NULL OR %[1]s <> %[2]s)", quotedColumn, expression.value)
+			case MaskRuleShuffle:
+			default:
+				return nil, fmt.Errorf("Unknown rule %s", column.Rule)
+			}
+			columns = append(columns, expression)
+		}
+		expressions = append(expressions, columns)
+	}
+	return expressions, nil
+}
+
+// getMaskColumnQuery returns a batch masking a column in batches of rows and
+// selecting the number of rows changed
+func getMaskColumnQuery(databaseName string, expression *maskExpression, rule string, batchSize int) *sqlQuery {
+	builder := strings.Builder{}
+	builder.WriteString(getUseDatabaseStatement(databaseName))
+	builder.WriteString("DECLARE @changed INT = 0;\n")
+	builder.WriteString(expression.check)
+	if rule == MaskRuleShuffle {
+		builder.WriteString(getShuffleStatement(expression))
+	} else {
+		if rule == MaskRuleFakeName {
+			builder.WriteString(getFakeNamesStatement())
+		}
+		builder.WriteString(fmt.Sprintf(`DECLARE @rows INT = 1;
+WHILE @rows > 0
+BEGIN
+	UPDATE TOP (@batch_size) %s SET %s = %s WHERE %s;
+	SET @rows = @@ROWCOUNT;
+	SET @changed = @changed + @rows;
+END
+`, expression.table, expression.column, expression.value, expression.unmasked))
+	}
+	builder.WriteString("SELECT @changed;")
+
+	parameters := []sqlParameter{param("batch_size", batchSize)}
+	if expression.parameter != nil {
+		parameters = append(parameters, *expression.parameter)
+	}
+	return newQuery(builder.String(), parameters...)
+}
+
+// getShuffleStatement returns statements swapping the values of a column
+// between random rows; the rows are numbered by the first unique index (the
+// primary key if any) without the column and updated in batches by a dynamic
+// statement built from the columns of the index, and the rows of tables
+// without such an index are updated at once
+func getShuffleStatement(expression *maskExpression) string {
+	batched := fmt.Sprintf(`SELECT {keys}, ROW_NUMBER() OVER (ORDER BY {keys}) AS n INTO #rds_backup_keys FROM %[1]s;
+DECLARE @total BIGINT = @@ROWCOUNT;
+CREATE UNIQUE CLUSTERED INDEX n ON #rds_backup_keys (n);
+SELECT %[2]s AS value, ROW_NUMBER() OVER (ORDER BY NEWID()) AS n INTO #rds_backup_values FROM %[1]s;
+CREATE UNIQUE CLUSTERED INDEX n ON #rds_backup_values (n);
+DECLARE @from BIGINT = 1;
+WHILE @from <= @total
+BEGIN
+	UPDATE t SET %[2]s = v.value
+	FROM %[1]s AS t
+	JOIN #rds_backup_keys AS k ON {join}
+	JOIN #rds_backup_values AS v ON v.n = k.n
+	WHERE k.n >= @from AND k.n < @from + @batch_size;
+	SET @changed = @changed + @@ROWCOUNT;
+	SET @from = @from + @batch_size;
+END`, expression.table, expression.column)
+	return fmt.Sprintf(`DECLARE @keys NVARCHAR(MAX), @join NVARCHAR(MAX);
+SELECT @keys = COALESCE(@keys + N', ', N'') + QUOTENAME(c.name),
+	@join = COALESCE(@join + N' AND ', N'') + N't.' + QUOTENAME(c.name) + N' = k.' + QUOTENAME(c.name)
+FROM sys.index_columns AS ic
+JOIN sys.columns AS c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE ic.object_id = OBJECT_ID(%[1]s) AND ic.key_ordinal > 0 AND ic.index_id = (
+	SELECT TOP 1 i.index_id FROM sys.indexes AS i
+	WHERE i.object_id = ic.object_id AND i.is_unique = 1 AND i.has_filter = 0 AND NOT EXISTS (
+		SELECT 1 FROM sys.index_columns AS x
+		JOIN sys.columns AS y ON y.object_id = x.object_id AND y.column_id = x.column_id
+		WHERE x.object_id = i.object_id AND x.index_id = i.index_id AND y.name = %[2]s)
+	ORDER BY i.is_primary_key DESC, i.index_id)
+ORDER BY ic.key_ordinal;
+IF @keys IS NULL
+BEGIN
+	WITH s AS (SELECT %[4]s AS value, ROW_NUMBER() OVER (ORDER BY NEWID()) AS n FROM %[3]s),
+		d AS (SELECT %[4]s, ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) AS n FROM %[3]s)
+	UPDATE d SET %[4]s = s.value FROM d JOIN s ON s.n = d.n;
+	SET @changed = @@ROWCOUNT;
+END
+ELSE
+BEGIN
+	DECLARE @statement NVARCHAR(MAX) = REPLACE(REPLACE(%[5]s, N'{keys}', @keys), N'{join}', @join);
+	EXEC sp_executesql @statement, N'@batch_size INT, @changed INT OUTPUT', @batch_size = @batch_size, @changed = @changed OUTPUT;
+END
+`, QuoteString(expression.table), QuoteString(expression.name), expression.table, expression.column, QuoteString(batched))
+}
+
+// getVerifyMaskingQuery returns a batch selecting the table, the column and
+// the number of unmasked rows of each of the columns
+func getVerifyMaskingQuery(databaseName string, expressions []*maskExpression, usesFakeNames bool) *sqlQuery {
+	builder := strings.Builder{}
+	builder.WriteString(getUseDatabaseStatement(databaseName))
+	if usesFakeNames {
+		builder.WriteString(getFakeNamesStatement())
+	}
+	var parameters []sqlParameter
+	var selections []string
+	for _, expression := range expressions {
+		if expression.parameter != nil {
+			parameters = append(parameters, *expression.parameter)
+		}
+		selections = append(selections, fmt.Sprintf("SELECT %s, %s, COUNT(*) FROM %s WHERE %s",
+			QuoteString(expression.table), QuoteString(expression.column), expression.table, expression.unmasked))
+	}
+	builder.WriteString(strings.Join(selections, "\nUNION ALL\n"))
+	builder.WriteString(";")
+	return newQuery(builder.String(), parameters...)
+}
+
+func getUseDatabaseStatement(databaseName string) string {
+	quoted, err := QuoteIdentifier(databaseName)
+	if err != nil {
+		quoted = databaseName
+	}
+	return fmt.Sprintf("SET NOCOUNT ON;\nUSE %s;\n", quoted)
+}
+
+// getFakeNamesStatement returns statements filling fakeNamesTable with
+// combinations of fakeFirstNames and fakeLastNames numbered from 0; names are
+// of the collation of the database rather than tempdb so that they can be
+// compared with columns
+func getFakeNamesStatement() string {
+	first := make([]string, len(fakeFirstNames))
+	for i, name := range fakeFirstNames {
+		first[i] = fmt.Sprintf("(%d, %s)", i, QuoteString(name))
+	}
+	last := make([]string, len(fakeLastNames))
+	for i, name := range fakeLastNames {
+		last[i] = fmt.Sprintf("(%d, %s)", i, QuoteString(name))
+	}
+	return fmt.Sprintf(`CREATE TABLE %[1]s (id INT PRIMARY KEY, name NVARCHAR(100) COLLATE DATABASE_DEFAULT NOT NULL);
+INSERT INTO %[1]s (id, name)
+SELECT f.id * %[2]d + l.id, f.name + N' ' + l.name
+FROM (VALUES %[3]s) AS f(id, name)
+CROSS JOIN (VALUES %[4]s) AS l(id, name);
+`, fakeNamesTable, len(fakeLastNames), strings.Join(first, ", "), strings.Join(last, ", "))
+}
+
+// dropDatabase drops the database even if the restore has been cancelled
+func dropDatabase(databaseName string, run sqlBatchRunner) error {
+	quoted, err := QuoteIdentifier(databaseName)
+	if err != nil {
+		return err
+	}
+	cleanupCtx, cancel := getCleanupContext()
+	defer cancel()
+	_, err = run(cleanupCtx, fmt.Sprintf("USE master;\nALTER DATABASE %[1]s SET SINGLE_USER WITH ROLLBACK IMMEDIATE;\nDROP DATABASE %[1]s;", quoted))
+	return err
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMaskProfile(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedError string
+	}{
+		{
+			name: "valid profile",
+			content: `batch_size: 500
+tables:
+  - table: dbo.Customers
+    columns:
+      - column: Name
+        rule: fake_name
+      - column: Email
+        rule: hash_email
+  - table: Orders
+    columns:
+      - column: Notes
+        rule: fixed
+        value: redacted
+`,
+		},
+		{
+			name:          "unknown rule",
+			content:       "tables:\n  - table: dbo.Customers\n    columns:\n      - column: Name\n        rule: scramble\n",
+			expectedError: `unknown rule "scramble"`,
+		},
+		{
+			name:          "fixed rule without value",
+			content:       "tables:\n  - table: dbo.Customers\n    columns:\n      - column: Name\n        rule: fixed\n",
+			expectedError: "value must be specified",
+		},
+		{
+			name:          "invalid table name",
+			content:       "tables:\n  - table: dbo.\n    columns:\n      - column: Name\n        rule: nullify\n",
+			expectedError: "schema.table",
+		},
+		{
+			name:          "unknown field",
+			content:       "tables:\n  - table: dbo.Customers\n    column: Name\n",
+			expectedError: "field column not found",
+		},
+		{
+			name:          "no tables",
+			content:       "batch_size: 10\n",
+			expectedError: "no tables",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "masking.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadMaskProfile(path)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("expected no error but got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Fatalf("expected error containing %q but got %v", test.expectedError, err)
+			}
+			if GetErrorKind(err) != ErrorKindValidation {
+				t.Errorf("expected error of kind %s but got %s", ErrorKindValidation, GetErrorKind(err))
+			}
+		})
+	}
+}
+
+func TestMaskRestoredDatabase(t *testing.T) {
+	profile := &MaskProfile{
+		Tables: []MaskTable{
+			{
+				Name: "dbo.Customers",
+				Columns: []MaskColumn{
+					{Name: "Name", Rule: MaskRuleFakeName},
+					{Name: "Email", Rule: MaskRuleHashEmail},
+					{Name: "Phone", Rule: MaskRuleShuffle},
+				},
+			},
+			{
+				Name:    "Orders",
+				Columns: []MaskColumn{{Name: "Notes", Rule: MaskRuleFixed, Value: stringPointer("redacted")}},
+			},
+		},
+	}
+	tests := []struct {
+		name            string
+		verification    string
+		expectedError   string
+		expectedBatches []string
+	}{
+		{
+			name:         "all rows are masked",
+			verification: "[dbo].[Customers]|[Name]|0\n[dbo].[Customers]|[Email]|0\n[dbo].[Orders]|[Notes]|0\n",
+			expectedBatches: []string{
+				"name NVARCHAR(100) COLLATE DATABASE_DEFAULT NOT NULL",
+				"UPDATE TOP (@batch_size) [dbo].[Customers] SET [Name] = (SELECT LEFT(name, COALESCE(NULLIF(COLUMNPROPERTY(OBJECT_ID(N'[dbo].[Customers]'), N'Name', 'CharMaxLen'), -1), 4000)) FROM #rds_backup_fake_names",
+				"LOWER(LEFT(CONVERT(VARCHAR(64), HASHBYTES('SHA2_256'",
+				"N'[dbo].[Customers].[Email] is too narrow for masked addresses, which need at least 17 characters'",
+				"WHERE x.object_id = i.object_id AND x.index_id = i.index_id AND y.name = N'Phone'",
+				"UPDATE t SET [Phone] = v.value",
+				"EXEC sp_executesql @statement",
+				"UPDATE d SET [Phone] = s.value",
+				"DECLARE @value_0 NVARCHAR(4000) = N'redacted'",
+				"UPDATE TOP (@batch_size) [dbo].[Orders] SET [Notes] = LEFT(@value_0, COALESCE(NULLIF(COLUMNPROPERTY(OBJECT_ID(N'[dbo].[Orders]'), N'Notes', 'CharMaxLen'), -1), 4000))",
+			},
+		},
+		{
+			name:            "unmasked rows remain",
+			verification:    "[dbo].[Customers]|[Name]|0\n[dbo].[Customers]|[Email]|3\n[dbo].[Orders]|[Notes]|0\n",
+			expectedError:   "3 rows of [dbo].[Customers].[Email]",
+			expectedBatches: []string{"DROP DATABASE [db]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var batches []string
+			run := func(ctx context.Context, batch string) (string, error) {
+				batches = append(batches, batch)
+				if strings.Contains(batch, "UNION ALL") {
+					return test.verification, nil
+				}
+				return "1\n", nil
+			}
+			params := &BaseRestoreParameters{DatabaseName: "db", MaskProfile: profile}
+
+			err := maskRestoredDatabase(context.Background(), params, run)
+
+			if test.expectedError == "" && err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if test.expectedError != "" && (err == nil || !strings.Contains(err.Error(), test.expectedError)) {
+				t.Fatalf("expected error containing %q but got %v", test.expectedError, err)
+			}
+			all := strings.Join(batches, "\n")
+			for _, expected := range test.expectedBatches {
+				if !strings.Contains(all, expected) {
+					t.Errorf("expected %s in batches:\n%s", expected, all)
+				}
+			}
+			if test.expectedError == "" && strings.Contains(all, "DROP DATABASE") {
+				t.Errorf("expected database not to be dropped:\n%s", all)
+			}
+		})
+	}
+}
+
+func stringPointer(value string) *string {
+	return &value
+}
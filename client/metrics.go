@@ -0,0 +1,360 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricTypeCounter = "counter"
+const metricTypeGauge = "gauge"
+const metricTypeHistogram = "histogram"
+
+// durationBuckets are the upper bounds (in seconds) of histograms of durations of backups and restores
+var durationBuckets = []float64{30, 60, 300, 900, 1800, 3600, 7200, 14400, 28800}
+
+// metricFamily is a metric with all its series, keyed by their rendered labels
+type metricFamily struct {
+	name       string
+	help       string
+	metricType string
+	series     map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels       string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+// metricRegistry keeps the metrics of the operations run by this process
+type metricRegistry struct {
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+}
+
+var metrics = &metricRegistry{families: map[string]*metricFamily{}}
+
+// RecordBackupCompleted records the duration of a successful backup and the
+// size of it on S3 (when it can be looked up)
+func RecordBackupCompleted(ctx context.Context, params *BackupParameters, duration time.Duration) {
+	labels := []string{"database", params.DatabaseName}
+	backupType := params.Type
+	if backupType == "" {
+		backupType = BackupTypeFull
+	}
+	metrics.observe("rds_backup_backup_duration_seconds", "Duration of backups from the start of the task to its completion", duration.Seconds(), "database", params.DatabaseName, "type", backupType)
+	metrics.set("rds_backup_last_success_timestamp_seconds", "Time of the last successful backup", metricTypeGauge, float64(time.Now().Unix()), labels...)
+	if info, err := getObjectInfo(ctx, params.BucketName, params.Filename, ""); err == nil {
+		metrics.set("rds_backup_backup_size_bytes", "Size of the last successful backup", metricTypeGauge, float64(info.ContentLength), labels...)
+	}
+}
+
+// BackupLifecycleNotStarted is the lifecycle of a failed backup whose task could not be started
+const BackupLifecycleNotStarted = "NOT_STARTED"
+
+// RecordBackupFailure records a failed backup with the lifecycle of the task
+// (such as ERROR, CANCELLED or NOT_STARTED) or UNKNOWN if the status could
+// not be retrieved
+func RecordBackupFailure(databaseName string, lifecycle string) {
+	if lifecycle == "" {
+		lifecycle = "UNKNOWN"
+	}
+	metrics.add("rds_backup_failures_total", "Number of failed backups", 1, "database", databaseName, "lifecycle", lifecycle)
+}
+
+func recordDownload(bucketName string, size int64, duration time.Duration) {
+	metrics.add("rds_backup_download_bytes_total", "Number of bytes of backups downloaded from S3", float64(size), "bucket", bucketName)
+	metrics.observe("rds_backup_download_duration_seconds", "Duration of downloads of backups from S3", duration.Seconds(), "bucket", bucketName)
+}
+
+func recordRestore(databaseName string, target string, duration time.Duration) {
+	metrics.observe("rds_backup_restore_duration_seconds", "Duration of restores", duration.Seconds(), "database", databaseName, "target", target)
+}
+
+// WriteMetrics writes the metrics recorded so far in Prometheus text exposition format
+func WriteMetrics(w io.Writer) error {
+	_, err := io.WriteString(w, metrics.render())
+	return err
+}
+
+// WriteMetricsFile merges the metrics recorded so far into a file for the
+// textfile collector of node_exporter so that the metrics of earlier runs
+// are kept; counters and histograms are added up and gauges are replaced,
+// so it is called once at the end of a run. The file is replaced atomically
+func WriteMetricsFile(path string) error {
+	previous, errRead := readMetricsFile(path)
+	if errRead != nil {
+		return errRead
+	}
+	previous.merge(metrics)
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".rds-backup-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = io.WriteString(file, previous.render()); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// readMetricsFile returns the metrics of a file written by WriteMetricsFile;
+// lines which are not in the form written are skipped
+func readMetricsFile(path string) (*metricRegistry, error) {
+	registry := &metricRegistry{families: map[string]*metricFamily{}}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	help := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.SplitN(line, " ", 4); len(fields) == 4 && fields[0] == "#" {
+			switch fields[1] {
+			case "HELP":
+				help[fields[2]] = fields[3]
+			case "TYPE":
+				registry.families[fields[2]] = &metricFamily{
+					name:       fields[2],
+					help:       help[fields[2]],
+					metricType: fields[3],
+					series:     map[string]*metricSeries{},
+				}
+			}
+			continue
+		}
+		registry.parseSample(line)
+	}
+	return registry, scanner.Err()
+}
+
+// parseSample adds a sample line such as name{labels} value to the series of
+// its family
+func (r *metricRegistry) parseSample(line string) {
+	separator := strings.LastIndex(line, " ")
+	if separator < 0 {
+		return
+	}
+	value, errValue := strconv.ParseFloat(line[separator+1:], 64)
+	if errValue != nil {
+		return
+	}
+	name, labels := line[:separator], ""
+	if start := strings.Index(name, "{"); start >= 0 && strings.HasSuffix(name, "}") {
+		name, labels = name[:start], name[start+1:len(name)-1]
+	}
+
+	if family, ok := r.families[name]; ok && family.metricType != metricTypeHistogram {
+		r.getSeriesOf(family, labels).value = value
+		return
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		family, ok := r.families[strings.TrimSuffix(name, suffix)]
+		if !ok || !strings.HasSuffix(name, suffix) || family.metricType != metricTypeHistogram {
+			continue
+		}
+		switch suffix {
+		case "_sum":
+			r.getSeriesOf(family, labels).value = value
+		case "_count":
+			r.getSeriesOf(family, labels).count = uint64(value)
+		case "_bucket":
+			// le is the last label of buckets
+			bound := strings.LastIndex(labels, `le="`)
+			if bound < 0 {
+				return
+			}
+			le := strings.TrimSuffix(labels[bound+len(`le="`):], `"`)
+			series := r.getSeriesOf(family, strings.TrimSuffix(labels[:bound], ","))
+			for i, b := range durationBuckets {
+				if formatMetricValue(b) == le {
+					series.bucketCounts[i] = uint64(value)
+				}
+			}
+		}
+		return
+	}
+}
+
+// merge adds the counters and histograms of the other registry to this one
+// and replaces the gauges of this one by the ones of the other registry
+func (r *metricRegistry) merge(other *metricRegistry) {
+	other.mutex.Lock()
+	defer other.mutex.Unlock()
+	for name, otherFamily := range other.families {
+		family, ok := r.families[name]
+		if !ok || family.metricType != otherFamily.metricType {
+			family = &metricFamily{
+				name:       name,
+				help:       otherFamily.help,
+				metricType: otherFamily.metricType,
+				series:     map[string]*metricSeries{},
+			}
+			r.families[name] = family
+		}
+		for labels, otherSeries := range otherFamily.series {
+			series := r.getSeriesOf(family, labels)
+			switch family.metricType {
+			case metricTypeGauge:
+				series.value = otherSeries.value
+			case metricTypeHistogram:
+				for i := range series.bucketCounts {
+					series.bucketCounts[i] += otherSeries.bucketCounts[i]
+				}
+				series.count += otherSeries.count
+				series.value += otherSeries.value
+			default:
+				series.value += otherSeries.value
+			}
+		}
+	}
+}
+
+func (r *metricRegistry) getSeries(name string, help string, metricType string, labels []string) *metricSeries {
+	family, ok := r.families[name]
+	if !ok {
+		family = &metricFamily{
+			name:       name,
+			help:       help,
+			metricType: metricType,
+			series:     map[string]*metricSeries{},
+		}
+		r.families[name] = family
+	}
+	return r.getSeriesOf(family, renderLabels(labels))
+}
+
+// getSeriesOf returns the series of the family with the rendered labels
+func (r *metricRegistry) getSeriesOf(family *metricFamily, renderedLabels string) *metricSeries {
+	series, ok := family.series[renderedLabels]
+	if !ok {
+		series = &metricSeries{labels: renderedLabels}
+		if family.metricType == metricTypeHistogram {
+			series.bucketCounts = make([]uint64, len(durationBuckets))
+		}
+		family.series[renderedLabels] = series
+	}
+	return series
+}
+
+func (r *metricRegistry) add(name string, help string, value float64, labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.getSeries(name, help, metricTypeCounter, labels).value += value
+}
+
+func (r *metricRegistry) set(name string, help string, metricType string, value float64, labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.getSeries(name, help, metricType, labels).value = value
+}
+
+func (r *metricRegistry) observe(name string, help string, value float64, labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	series := r.getSeries(name, help, metricTypeHistogram, labels)
+	for i, bound := range durationBuckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+		}
+	}
+	series.count++
+	series.value += value
+}
+
+func (r *metricRegistry) render() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := []string{}
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder := strings.Builder{}
+	for _, name := range names {
+		family := r.families[name]
+		builder.WriteString(fmt.Sprintf("# HELP %s %s\n", name, family.help))
+		builder.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, family.metricType))
+
+		keys := []string{}
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			if family.metricType != metricTypeHistogram {
+				builder.WriteString(fmt.Sprintf("%s%s %s\n", name, withLabel(series.labels, "", ""), formatMetricValue(series.value)))
+				continue
+			}
+			for i, bound := range durationBuckets {
+				builder.WriteString(fmt.Sprintf("%s_bucket%s %d\n", name, withLabel(series.labels, "le", formatMetricValue(bound)), series.bucketCounts[i]))
+			}
+			builder.WriteString(fmt.Sprintf("%s_bucket%s %d\n", name, withLabel(series.labels, "le", "+Inf"), series.count))
+			builder.WriteString(fmt.Sprintf("%s_sum%s %s\n", name, withLabel(series.labels, "", ""), formatMetricValue(series.value)))
+			builder.WriteString(fmt.Sprintf("%s_count%s %d\n", name, withLabel(series.labels, "", ""), series.count))
+		}
+	}
+	return builder.String()
+}
+
+// renderLabels renders pairs of label names and values as name="value",...
+func renderLabels(labels []string) string {
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func withLabel(labels string, name string, value string) string {
+	if name != "" {
+		label := fmt.Sprintf("%s=\"%s\"", name, value)
+		if labels == "" {
+			labels = label
+		} else {
+			labels = fmt.Sprintf("%s,%s", labels, label)
+		}
+	}
+	if labels == "" {
+		return ""
+	}
+	return fmt.Sprintf("{%s}", labels)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
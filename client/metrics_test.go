@@ -0,0 +1,99 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsFileKeepsEarlierRuns(t *testing.T) {
+	defer func() {
+		metrics = &metricRegistry{families: map[string]*metricFamily{}}
+	}()
+	path := filepath.Join(t.TempDir(), "rds_backup.prom")
+	runs := []func(){
+		func() {
+			RecordBackupFailure("sales", "ERROR")
+			metrics.set("rds_backup_last_success_timestamp_seconds", "Time of the last successful backup", metricTypeGauge, 100, "database", "orders")
+			recordRestore("orders", "docker", 45*time.Second)
+		},
+		func() {
+			RecordBackupFailure("sales", "ERROR")
+			RecordBackupFailure("sales", BackupLifecycleNotStarted)
+			recordRestore("orders", "docker", 10*time.Minute)
+		},
+		func() {
+			metrics.set("rds_backup_last_success_timestamp_seconds", "Time of the last successful backup", metricTypeGauge, 200, "database", "orders")
+		},
+	}
+
+	for _, run := range runs {
+		metrics = &metricRegistry{families: map[string]*metricFamily{}}
+		run()
+		if err := WriteMetricsFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLines := []string{
+		"# TYPE rds_backup_failures_total counter",
+		`rds_backup_failures_total{database="sales",lifecycle="ERROR"} 2`,
+		`rds_backup_failures_total{database="sales",lifecycle="NOT_STARTED"} 1`,
+		"# TYPE rds_backup_last_success_timestamp_seconds gauge",
+		`rds_backup_last_success_timestamp_seconds{database="orders"} 200`,
+		"# TYPE rds_backup_restore_duration_seconds histogram",
+		`rds_backup_restore_duration_seconds_bucket{database="orders",target="docker",le="60"} 1`,
+		`rds_backup_restore_duration_seconds_bucket{database="orders",target="docker",le="900"} 2`,
+		`rds_backup_restore_duration_seconds_bucket{database="orders",target="docker",le="+Inf"} 2`,
+		`rds_backup_restore_duration_seconds_sum{database="orders",target="docker"} 645`,
+		`rds_backup_restore_duration_seconds_count{database="orders",target="docker"} 2`,
+	}
+	lines := strings.Split(string(content), "\n")
+	for _, expected := range expectedLines {
+		if !containsLine(lines, expected) {
+			t.Errorf("expected line %s in:\n%s", expected, content)
+		}
+	}
+	if count := strings.Count(string(content), "# TYPE rds_backup_failures_total"); count != 1 {
+		t.Errorf("expected metric to be described once but got %d times", count)
+	}
+}
+
+func TestReadMetricsFileSkipsUnknownLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rds_backup.prom")
+	content := "# HELP rds_backup_failures_total Number of failed backups\n" +
+		"# TYPE rds_backup_failures_total counter\n" +
+		`rds_backup_failures_total{database="db",lifecycle="ERROR"} 3` + "\n" +
+		"other_metric 1\n" +
+		"not a sample\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := readMetricsFile(path)
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+
+	expected := "# HELP rds_backup_failures_total Number of failed backups\n" +
+		"# TYPE rds_backup_failures_total counter\n" +
+		`rds_backup_failures_total{database="db",lifecycle="ERROR"} 3` + "\n"
+	if actual := registry.render(); actual != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, actual)
+	}
+}
+
+func containsLine(lines []string, expected string) bool {
+	for _, line := range lines {
+		if line == expected {
+			return true
+		}
+	}
+	return false
+}
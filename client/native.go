@@ -1,238 +1,411 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-
-	"github.com/spf13/viper"
+	"time"
 )
 
 // NativeRestoreParameters contains restore information
 type NativeRestoreParameters struct {
 	BaseRestoreParameters
+	// Server is the SQL server to restore onto in the form of sqlcmd -S (the
+	// default instance on this machine if it is empty)
+	Server string
+	// Username is the login of the server (Windows authentication if it is empty)
+	Username string
+	Password string
+	// CustomDataPath is the directory on the server of MDF and LDF files
+	// (the default data and log directories of the server if it is empty)
 	CustomDataPath string
-	ServerPath     string
+	// ServerPath is the installation directory of the server containing
+	// Backup, DATA and LOG (discovered from the server if it is empty)
+	ServerPath string
+	// SharePath is a directory on this machine which the server can read, to
+	// copy backups to before restore (the backup directory of the server if
+	// it is empty)
+	SharePath string
+	// ShareServerPath is SharePath as seen by the server (SharePath if it is empty)
+	ShareServerPath string
 }
 
-// DefaultServerInstallationPath path to the native SQL server installation
-const DefaultServerInstallationPath = "C:\\Program Files\\Microsoft SQL Server\\MSSQL13.MSSQLSERVER\\MSSQL\\"
+// serverPaths are the directories of a SQL server as seen by the server
+type serverPaths struct {
+	Data   string
+	Log    string
+	Backup string
+}
 
 // NativeClient is a SQL client runs sqlcmd on a machine
 type NativeClient struct{}
 
 // IsEnvironmentSatisfied returns if this client can be run on this machine
-func (c *NativeClient) IsEnvironmentSatisfied() bool {
+func (c *NativeClient) IsEnvironmentSatisfied(ctx context.Context) bool {
 	args := []string{"-?"}
-	_, err := executeSQLCmd(args)
+	_, err := executeSQLCmd(ctx, args, "")
 	if err != nil {
 		return false
 	}
 	return true
 }
 
-// GetStatus returns the status of the latest backup
-func (c *NativeClient) GetStatus(params *DatabaseParameters, taskID string) (string, error) {
-	query := "SELECT TOP 1 lifecycle FROM @s"
-	if taskID != "" {
-		query = fmt.Sprintf("SELECT lifecycle FROM @s WHERE task_id = %s", taskID)
+// GetSQLCmdVersion returns the version of sqlcmd on this machine (such as 15.0.2000.5 Linux)
+func GetSQLCmdVersion(ctx context.Context) (string, error) {
+	output, err := executeSQLCmd(ctx, []string{"-?"}, "")
+	if err != nil {
+		return "", NewError(ErrorKindEnvironment, err)
 	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Version ") {
+			return strings.TrimPrefix(line, "Version "), nil
+		}
+	}
+	return "", NewError(ErrorKindEnvironment, errors.New("Unable to find the version in the usage of sqlcmd"))
+}
 
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-	%s
-
-	INSERT INTO @s
-	exec msdb.dbo.rds_task_status @db_name='%s'
-
-	%s
-
-	SET NOCOUNT OFF`, statusTableDeclaration, params.DatabaseName, query)
+// GetServerVersion returns the version of the SQL server, which fails if the login fails
+func (c *NativeClient) GetServerVersion(ctx context.Context, params *DatabaseParameters) (string, error) {
+	output, err := c.runQuery(ctx, params, getServerVersionQuery())
+	if err != nil {
+		return "", err
+	}
+	return getSQLValue(output)
+}
 
-	args := getSQLCommandArgs(params, statement)
-	output, err := executeSQLCmd(args)
+// GetStatus returns the status of the latest backup
+func (c *NativeClient) GetStatus(ctx context.Context, params *DatabaseParameters, taskID string) (string, error) {
+	query, errQuery := getStatusQuery(params.DatabaseName, taskID)
+	if errQuery != nil {
+		return "", errQuery
+	}
+	output, err := c.runQuery(ctx, params, query)
 	if err != nil {
 		return "", err
 	}
-	return getSQLOutput(output), nil
+	return getSQLValue(output)
 }
 
 // GetCompletionPercentage returns the percentage of completion of the latest backup
-func (c *NativeClient) GetCompletionPercentage(params *DatabaseParameters) (string, error) {
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-	%s
-
-	INSERT INTO @s
-	exec msdb.dbo.rds_task_status @db_name='%s'
-
-	SELECT TOP 1 complete FROM @s
-
-	SET NOCOUNT OFF`, statusTableDeclaration, params.DatabaseName)
-
-	args := getSQLCommandArgs(params, statement)
-	output, err := executeSQLCmd(args)
+func (c *NativeClient) GetCompletionPercentage(ctx context.Context, params *DatabaseParameters) (string, error) {
+	output, err := c.runQuery(ctx, params, getCompletionPercentageQuery(params.DatabaseName))
 	if err != nil {
 		return "", err
 	}
-	return getSQLOutput(output), nil
+	return getSQLValue(output)
 }
 
 // GetTaskMessage returns the message of the latest backup task
-func (c *NativeClient) GetTaskMessage(params *DatabaseParameters) (string, error) {
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-	%s
-
-	INSERT INTO @s
-	exec msdb.dbo.rds_task_status @db_name='%s'
-
-	SELECT TOP 1 task_info FROM @s
-
-	SET NOCOUNT OFF`, statusTableDeclaration, params.DatabaseName)
-
-	args := getSQLCommandArgs(params, statement)
-	output, err := executeSQLCmd(args)
+func (c *NativeClient) GetTaskMessage(ctx context.Context, params *DatabaseParameters) (string, error) {
+	output, err := c.runQuery(ctx, params, getTaskMessageQuery(params.DatabaseName))
 	if err != nil {
 		return "", err
 	}
-	return getSQLOutput(output), nil
+	return getSQLValue(output)
 }
 
 // StartBackup creates a new backup
-func (c *NativeClient) StartBackup(params *BackupParameters) (string, error) {
-	statement := fmt.Sprintf(`SET NOCOUNT ON
-
-		%s
-
-		INSERT INTO @s
-		exec msdb.dbo.rds_backup_database
-			@source_db_name='%s',
-			@s3_arn_to_backup_to='arn:aws:s3:::%s/%s',
-			@overwrite_S3_backup_file=1;
-
-		SELECT TOP 1 task_id FROM @s
-
-		SET NOCOUNT OFF`,
-		createTableDeclaration,
-		params.DatabaseName,
-		params.BucketName,
-		params.Filename)
-
-	args := getSQLCommandArgs(&params.DatabaseParameters, statement)
-	output, err := executeSQLCmd(args)
+func (c *NativeClient) StartBackup(ctx context.Context, params *BackupParameters) (string, error) {
+	output, err := c.runQuery(ctx, &params.DatabaseParameters, getStartBackupQuery(params))
 	if err != nil {
-		return "", err
+		return "", NewError(ErrorKindTask, err)
 	}
-	lines := strings.Split(output, "\n")
-	if len(lines) < 4 {
-		return "", errors.New(output)
+	taskID, errTaskID := getSQLValue(output)
+	if errTaskID != nil {
+		return "", NewError(ErrorKindTask, errTaskID)
 	}
-	return strings.TrimSpace(lines[3]), nil
+	return taskID, nil
 }
 
 // GetLogicalNames returns the logical names of MDF and LDF
-func (c *NativeClient) GetLogicalNames(params *DatabaseParameters) (string, string, error) {
-	dataNameQuery := "SELECT name FROM sys.master_files WHERE database_id = db_id() AND type = 0"
-	logNameQuery := "SELECT name FROM sys.master_files WHERE database_id = db_id() AND type = 1"
-
-	outputData, errData := executeSQLCmd(getSQLCommandArgs(params, dataNameQuery))
+func (c *NativeClient) GetLogicalNames(ctx context.Context, params *DatabaseParameters) (string, string, error) {
+	outputData, errData := c.runQuery(ctx, params, getLogicalNameQuery(dataFileType))
 	if errData != nil {
 		return "", "", errData
 	}
-	dataName := getSQLOutput(outputData)
+	dataName, errDataName := getSQLValue(outputData)
+	if errDataName != nil {
+		return "", "", errDataName
+	}
 
-	outputLog, errLog := executeSQLCmd(getSQLCommandArgs(params, logNameQuery))
+	outputLog, errLog := c.runQuery(ctx, params, getLogicalNameQuery(logFileType))
 	if errLog != nil {
 		return "", "", errLog
 	}
-	logName := getSQLOutput(outputLog)
+	logName, errLogName := getSQLValue(outputLog)
+	if errLogName != nil {
+		return "", "", errLogName
+	}
 
 	return dataName, logName, nil
 }
 
+func (c *NativeClient) runQuery(ctx context.Context, params *DatabaseParameters, query *sqlQuery) (string, error) {
+	args, err := getSQLCommandArgs(params, query)
+	if err != nil {
+		return "", err
+	}
+	output, errQuery := executeSQLCmd(ctx, args, params.Password)
+	return output, getSQLError(errQuery, output)
+}
+
 // RestoreNative restores a backup onto a local instance of SQL server
-func RestoreNative(params *NativeRestoreParameters) error {
+func RestoreNative(ctx context.Context, params *NativeRestoreParameters) error {
+	startTime := time.Now()
+	err := restoreNative(ctx, params)
+	if err == nil {
+		recordRestore(params.DatabaseName, "native", time.Since(startTime))
+	}
+	notifyRestore(&params.BaseRestoreParameters, time.Since(startTime), err)
+	return NewError(ErrorKindRestore, err)
+}
+
+func restoreNative(ctx context.Context, params *NativeRestoreParameters) error {
+	log := logger.With("database", params.DatabaseName, "filename", params.Filename)
 	pathToBak := getPathToBak(&params.BaseRestoreParameters)
-	_, errFile := os.Stat(pathToBak)
-	if errFile != nil {
-		return errFile
+	if params.Source == nil {
+		if _, errFile := os.Stat(pathToBak); errFile != nil {
+			return errFile
+		}
 	}
 
-	serverDirectory := params.ServerPath
-	serverBackupDirectory := filepath.Join(serverDirectory, "Backup\\")
-	serverMdfDirectory := filepath.Join(serverDirectory, "DATA\\")
-	serverLdfDirectory := filepath.Join(serverDirectory, "LOG\\")
+	paths, errPaths := getNativeServerPaths(ctx, params)
+	if errPaths != nil {
+		return errPaths
+	}
+	log.Info("Using directories of the server", "data", paths.Data, "log", paths.Log, "backup", paths.Backup)
 
-	pathToBackup := filepath.Join(serverBackupDirectory, params.Filename)
+	mdfDirectory := paths.Data
+	ldfDirectory := paths.Log
+	if params.CustomDataPath != "" {
+		mdfDirectory = params.CustomDataPath
+		ldfDirectory = params.CustomDataPath
+	}
 
-	fmt.Println("Starting to restore onto local SQL Server...")
+	mdfPath := joinServerPath(mdfDirectory, fmt.Sprintf("%s.mdf", params.DatabaseName))
+	ldfPath := joinServerPath(ldfDirectory, fmt.Sprintf("%s.ldf", params.DatabaseName))
 
-	copyFile(pathToBak, pathToBackup)
+	runBatch := getNativeBatchRunner(params)
+	if params.Source != nil {
+		if err := restoreFromURL(ctx, &params.BaseRestoreParameters, mdfPath, ldfPath, runBatch); err != nil {
+			return err
+		}
+		log.Info("Restore has been completed")
+		return completeRestore(ctx, &params.BaseRestoreParameters, getNativeRestoredServer(params), runBatch)
+	}
 
-	fmt.Printf("Copied from %s to %s to prepare restoration.\n", pathToBak, pathToBackup)
+	localBackupDirectory := paths.Backup
+	serverBackupDirectory := paths.Backup
+	if params.SharePath != "" {
+		localBackupDirectory = params.SharePath
+		serverBackupDirectory = params.SharePath
+		if params.ShareServerPath != "" {
+			serverBackupDirectory = params.ShareServerPath
+		}
+	}
+	localPathToBackup := filepath.Join(localBackupDirectory, params.Filename)
+	pathToBackup := joinServerPath(serverBackupDirectory, params.Filename)
 
-	mdfDirectory := serverMdfDirectory
-	ldfDirectory := serverLdfDirectory
+	log.Info("Starting to restore onto SQL Server")
 
-	if params.CustomDataPath != "" {
-		if _, errCustomPath := os.Stat(params.CustomDataPath); os.IsNotExist(errCustomPath) {
-			return errCustomPath
-		}
-		mdfDirectory = params.CustomDataPath
-		ldfDirectory = params.CustomDataPath
+	var errCopy error
+	if encryptionKey != nil {
+		errCopy = DecryptFile(pathToBak, localPathToBackup, encryptionKey)
+	} else {
+		errCopy = copyFile(pathToBak, localPathToBackup)
+	}
+	if errCopy != nil {
+		os.Remove(localPathToBackup)
+		return errCopy
 	}
 
-	mdfPath := filepath.Join(mdfDirectory, fmt.Sprintf("%s.mdf", params.DatabaseName))
-	ldfPath := filepath.Join(ldfDirectory, fmt.Sprintf("%s.ldf", params.DatabaseName))
+	log.Info("Copied backup to prepare restoration", "source", pathToBak, "destination", localPathToBackup)
 
-	fmt.Println("Restoring...")
+	log.Info("Restoring", "backup", pathToBackup, "mdf", mdfPath, "ldf", ldfPath)
 
-	restoreArgs := []string{
-		"-Q",
-		fmt.Sprintf("RESTORE DATABASE %s FROM DISK=N'%s' WITH FILE=1, NOUNLOAD, REPLACE, STATS=5, MOVE '%s' TO '%s', MOVE '%s' TO '%s'", params.DatabaseName, pathToBackup, params.DataName, mdfPath, params.LogName, ldfPath),
+	restoreStatement, errStatement := getRestoreQuery(params.DatabaseName, pathToBackup, params.DataName, mdfPath, params.LogName, ldfPath).render()
+	if errStatement != nil {
+		return errStatement
 	}
 
-	_, err := executeSQLCmd(restoreArgs)
+	restoreArgs := append(getNativeConnectionArgs(params),
+		"-b",
+		"-x",
+		"-Q",
+		restoreStatement,
+	)
+
+	output, err := executeSQLCmd(ctx, restoreArgs, params.Password)
 	if err != nil {
-		return err
+		os.Remove(localPathToBackup)
+		return getSQLError(err, output)
 	}
-	fmt.Printf("Restore has been completed (as database '%s').\n", params.DatabaseName)
+	log.Info("Restore has been completed")
 
-	errRemove := os.Remove(pathToBackup)
+	errRemove := os.Remove(localPathToBackup)
 	if errRemove != nil {
 		return errRemove
 	}
-	fmt.Printf("Removed file %s. Clean up done.\n", pathToBackup)
+	log.Info("Removed copy of backup. Clean up done", "path", localPathToBackup)
 
-	return nil
+	return completeRestore(ctx, &params.BaseRestoreParameters, getNativeRestoredServer(params), runBatch)
 }
 
-func executeSQLCmd(args []string) (string, error) {
-	if viper.GetBool("verbose") {
-		fmt.Println("Command executed:", "sqlcmd", args)
+// IsLocalServer returns if the server (in the form of sqlcmd -S) is on this
+// machine, which is the case if it is not specified
+func IsLocalServer(server string) bool {
+	host, _, _ := parseServer(server)
+	switch strings.ToLower(host) {
+	case "", ".", "(local)", "localhost":
+		return true
 	}
-	byteOutput, err := exec.Command("sqlcmd", args...).Output()
-	return string(byteOutput), err
+	if hostname, err := os.Hostname(); err == nil {
+		shortName, _, _ := strings.Cut(hostname, ".")
+		if strings.EqualFold(host, hostname) || strings.EqualFold(host, shortName) {
+			return true
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, address := range addresses {
+		if network, ok := address.(*net.IPNet); ok && network.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-func getSQLCommandArgs(params *DatabaseParameters, statement string) []string {
-	return []string{
+// getNativeRestoredServer returns the server restored onto, which is the
+// local one if Server is not specified
+func getNativeRestoredServer(params *NativeRestoreParameters) *restoredServer {
+	server := params.Server
+	if server == "" {
+		server = "localhost"
+	}
+	host, port, _ := parseServer(server)
+	return &restoredServer{
+		Server:   server,
+		Host:     host,
+		Port:     port,
+		Username: params.Username,
+		Password: params.Password,
+	}
+}
+
+// getNativeBatchRunner returns a runner of batches in the server, which are
+// passed via the input of sqlcmd
+func getNativeBatchRunner(params *NativeRestoreParameters) sqlBatchRunner {
+	return func(ctx context.Context, batch string) (string, error) {
+		args := append(getNativeConnectionArgs(params), sqlcmdOutputArgs...)
+		output, err := executeSQLCmdWithInput(ctx, append(args, "-x"), params.Password, strings.NewReader(batch))
+		return output, getSQLError(err, output)
+	}
+}
+
+// getNativeServerPaths returns the directories of Backup, DATA and LOG under
+// ServerPath if it is specified, or the default directories of the server
+func getNativeServerPaths(ctx context.Context, params *NativeRestoreParameters) (*serverPaths, error) {
+	if params.ServerPath != "" {
+		return &serverPaths{
+			Data:   joinServerPath(params.ServerPath, "DATA"),
+			Log:    joinServerPath(params.ServerPath, "LOG"),
+			Backup: joinServerPath(params.ServerPath, "Backup"),
+		}, nil
+	}
+
+	statement, errStatement := getServerPathsQuery().render()
+	if errStatement != nil {
+		return nil, errStatement
+	}
+	args := append(getNativeConnectionArgs(params), sqlcmdOutputArgs...)
+	output, err := executeSQLCmd(ctx, append(args, "-x", "-Q", statement), params.Password)
+	if err != nil {
+		return nil, getSQLError(err, output)
+	}
+	records := parseSQLRecords(output, 3)
+	if len(records) == 0 || len(records[0]) < 3 {
+		return nil, errNoSQLRecord
+	}
+	paths := &serverPaths{
+		Data:   strings.TrimSpace(records[0][0]),
+		Log:    strings.TrimSpace(records[0][1]),
+		Backup: strings.TrimSpace(records[0][2]),
+	}
+	if paths.Data == "NULL" || paths.Log == "NULL" || paths.Backup == "NULL" {
+		return nil, errors.New("Unable to find the default directories of the server; please specify --restore-server-directory")
+	}
+	return paths, nil
+}
+
+// getNativeConnectionArgs returns the arguments of sqlcmd connecting to the
+// server of a native restore
+func getNativeConnectionArgs(params *NativeRestoreParameters) []string {
+	var args []string
+	if params.Server != "" {
+		args = append(args, "-S", params.Server)
+	}
+	if params.Username != "" {
+		args = append(args, "-U", params.Username)
+	}
+	return args
+}
+
+// joinServerPath joins a name to a directory on the server with the separator
+// used in the directory, as the server may run on an OS other than this one
+func joinServerPath(directory string, name string) string {
+	separator := "/"
+	if strings.Contains(directory, "\\") || (len(directory) >= 2 && directory[1] == ':') {
+		separator = "\\"
+	}
+	return strings.TrimRight(directory, "/\\") + separator + name
+}
+
+// executeSQLCmd runs sqlcmd with the password (if any) passed via an
+// environment variable so that it does not appear in the arguments
+func executeSQLCmd(ctx context.Context, args []string, password string) (string, error) {
+	return executeSQLCmdWithInput(ctx, args, password, nil)
+}
+
+// executeSQLCmdWithInput runs sqlcmd with the batches read from the input,
+// which keeps values such as secrets out of the arguments
+func executeSQLCmdWithInput(ctx context.Context, args []string, password string, stdin io.Reader) (string, error) {
+	command := &Command{Name: "sqlcmd", Args: args, Stdin: stdin}
+	if password != "" {
+		command.Environment = []string{fmt.Sprintf("%s=%s", sqlcmdPasswordVariable, password)}
+	}
+	return runCommand(ctx, command)
+}
+
+func getSQLCommandArgs(params *DatabaseParameters, query *sqlQuery) ([]string, error) {
+	statement, err := query.render()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
 		"-S",
 		params.Server,
 		"-d",
 		params.DatabaseName,
 		"-U",
 		params.Username,
-		"-P",
-		params.Password,
-		"-Q",
-		statement,
 	}
+	args = append(args, sqlcmdOutputArgs...)
+	return append(args, "-x", "-Q", statement), nil
 }
 
 func copyFile(src, dst string) error {
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"os"
+	"testing"
+)
+
+func TestJoinServerPath(t *testing.T) {
+	tests := []struct {
+		directory string
+		expected  string
+	}{
+		{"C:\\Program Files\\Microsoft SQL Server\\MSSQL15.MSSQLSERVER\\MSSQL\\DATA\\", "C:\\Program Files\\Microsoft SQL Server\\MSSQL15.MSSQLSERVER\\MSSQL\\DATA\\db.mdf"},
+		{"D:", "D:\\db.mdf"},
+		{"\\\\fileserver\\backups", "\\\\fileserver\\backups\\db.mdf"},
+		{"/var/opt/mssql/data/", "/var/opt/mssql/data/db.mdf"},
+		{"/var/opt/mssql/data", "/var/opt/mssql/data/db.mdf"},
+	}
+
+	for _, test := range tests {
+		if actual := joinServerPath(test.directory, "db.mdf"); actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.directory, test.expected, actual)
+		}
+	}
+}
+
+func TestIsLocalServer(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		server   string
+		expected bool
+	}{
+		{"", true},
+		{".", true},
+		{"(local)\\SQLEXPRESS", true},
+		{"localhost,1433", true},
+		{"tcp:127.0.0.1,1500", true},
+		{hostname, true},
+		{"sql01.example.com", false},
+		{"tcp:10.255.255.1,1433", false},
+	}
+
+	for _, test := range tests {
+		if actual := IsLocalServer(test.server); actual != test.expected {
+			t.Errorf("%s: expected %t but got %t", test.server, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotificationEventSuccess is sent when an operation completes successfully
+const NotificationEventSuccess = "success"
+
+// NotificationEventFailure is sent when an operation fails
+const NotificationEventFailure = "failure"
+
+// NotificationEventLongRunning is sent once when an operation has run longer than the threshold of a notifier
+const NotificationEventLongRunning = "long-running"
+
+// NotifierTypeWebhook posts notifications as JSON documents
+const NotifierTypeWebhook = "webhook"
+
+// NotifierTypeSlack posts notifications to a Slack incoming webhook
+const NotifierTypeSlack = "slack"
+
+// NotifierTypeTeams posts notifications to a Microsoft Teams incoming webhook
+const NotifierTypeTeams = "teams"
+
+// NotifierTypeEmail sends notifications by email via SMTP
+const NotifierTypeEmail = "email"
+
+const notificationTimeout = 10 * time.Second
+
+// Notification describes the outcome (or progress) of a backup or restore
+type Notification struct {
+	Event        string        `json:"event"`
+	Operation    string        `json:"operation"`
+	DatabaseName string        `json:"database"`
+	TaskID       string        `json:"task_id,omitempty"`
+	S3URI        string        `json:"s3_uri,omitempty"`
+	Filename     string        `json:"filename,omitempty"`
+	Duration     time.Duration `json:"-"`
+	Error        string        `json:"error,omitempty"`
+	Time         time.Time     `json:"time"`
+}
+
+// NotifierConfig specifies where notifications are sent and on which events
+type NotifierConfig struct {
+	Type             string        `mapstructure:"type"`
+	URL              string        `mapstructure:"url"`
+	Events           []string      `mapstructure:"events"`
+	LongRunningAfter time.Duration `mapstructure:"long-running-after"`
+	SMTPServer       string        `mapstructure:"smtp-server"`
+	SMTPUsername     string        `mapstructure:"smtp-username"`
+	SMTPPassword     string        `mapstructure:"smtp-password"`
+	From             string        `mapstructure:"from"`
+	To               []string      `mapstructure:"to"`
+}
+
+// Notifier sends notifications to a destination
+type Notifier interface {
+	Send(n *Notification) error
+}
+
+// WebhookNotifier posts notifications to a URL in the payload format of the specified notifier type
+type WebhookNotifier struct {
+	URL    string
+	Type   string
+	Client *http.Client
+}
+
+// EmailNotifier sends notifications by email via a SMTP server (host:port)
+type EmailNotifier struct {
+	Server   string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+type configuredNotifier struct {
+	config   NotifierConfig
+	notifier Notifier
+}
+
+// notifiers receive notifications of backups and restores
+var notifiers = []configuredNotifier{}
+
+// UseNotifiers makes notifications to be sent as configured
+func UseNotifiers(configs []NotifierConfig) error {
+	configured := []configuredNotifier{}
+	for i, config := range configs {
+		notifier, err := NewNotifier(&config)
+		if err != nil {
+			return fmt.Errorf("Notifier %d: %s", i+1, err.Error())
+		}
+		if len(config.Events) == 0 {
+			config.Events = []string{NotificationEventSuccess, NotificationEventFailure}
+		}
+		for _, event := range config.Events {
+			if event != NotificationEventSuccess && event != NotificationEventFailure && event != NotificationEventLongRunning {
+				return fmt.Errorf("Notifier %d: unknown event %s (expected %s, %s or %s)", i+1, event, NotificationEventSuccess, NotificationEventFailure, NotificationEventLongRunning)
+			}
+		}
+		if config.isSubscribed(NotificationEventLongRunning) && config.LongRunningAfter <= 0 {
+			return fmt.Errorf("Notifier %d: long-running-after must be specified for %s event", i+1, NotificationEventLongRunning)
+		}
+		configured = append(configured, configuredNotifier{config: config, notifier: notifier})
+	}
+	notifiers = configured
+	return nil
+}
+
+// NewNotifier returns a notifier of the type specified in the configuration
+func NewNotifier(config *NotifierConfig) (Notifier, error) {
+	switch config.Type {
+	case NotifierTypeWebhook, NotifierTypeSlack, NotifierTypeTeams:
+		if config.URL == "" {
+			return nil, fmt.Errorf("url of %s notifier must be specified", config.Type)
+		}
+		return &WebhookNotifier{
+			URL:    config.URL,
+			Type:   config.Type,
+			Client: &http.Client{Timeout: notificationTimeout},
+		}, nil
+	case NotifierTypeEmail:
+		if config.SMTPServer == "" || config.From == "" || len(config.To) == 0 {
+			return nil, fmt.Errorf("smtp-server, from and to of %s notifier must be specified", config.Type)
+		}
+		if _, _, err := net.SplitHostPort(config.SMTPServer); err != nil {
+			return nil, fmt.Errorf("smtp-server must be in form of host:port")
+		}
+		return &EmailNotifier{
+			Server:   config.SMTPServer,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.From,
+			To:       config.To,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %s (expected %s, %s, %s or %s)", config.Type, NotifierTypeWebhook, NotifierTypeSlack, NotifierTypeTeams, NotifierTypeEmail)
+}
+
+// Notify sends a notification to the notifiers subscribed to its event; a
+// notification which cannot be sent does not fail the operation
+func Notify(n *Notification) {
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	for _, c := range notifiers {
+		if c.config.isSubscribed(n.Event) {
+			send(c.notifier, n)
+		}
+	}
+}
+
+// NewLongRunningCheck returns a function to be called as an operation
+// progresses, which notifies each notifier subscribed to long-running events
+// once the operation has run longer than the threshold of the notifier
+func NewLongRunningCheck(n Notification) func(duration time.Duration) {
+	notified := map[int]bool{}
+	mutex := sync.Mutex{}
+	n.Event = NotificationEventLongRunning
+
+	return func(duration time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for i, c := range notifiers {
+			if notified[i] || !c.config.isSubscribed(NotificationEventLongRunning) || duration < c.config.LongRunningAfter {
+				continue
+			}
+			notified[i] = true
+			notification := n
+			notification.Duration = duration
+			notification.Time = time.Now().UTC()
+			send(c.notifier, &notification)
+		}
+	}
+}
+
+func send(notifier Notifier, n *Notification) {
+	if err := notifier.Send(n); err != nil {
+		logger.Warn("Unable to send notification", "event", n.Event, "database", n.DatabaseName, "error", err)
+	}
+}
+
+func notifyRestore(params *BaseRestoreParameters, duration time.Duration, err error) {
+	n := &Notification{
+		Event:        NotificationEventSuccess,
+		Operation:    "restore",
+		DatabaseName: params.DatabaseName,
+		Filename:     params.Filename,
+		Duration:     duration,
+	}
+	if err != nil {
+		n.Event = NotificationEventFailure
+		n.Error = err.Error()
+	}
+	Notify(n)
+}
+
+func (c *NotifierConfig) isSubscribed(event string) bool {
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON adds the duration in seconds to the document
+func (n Notification) MarshalJSON() ([]byte, error) {
+	type notification Notification
+	return json.Marshal(struct {
+		notification
+		DurationSeconds float64 `json:"duration_seconds"`
+	}{
+		notification:    notification(n),
+		DurationSeconds: n.Duration.Seconds(),
+	})
+}
+
+// Subject returns a one-line summary of the notification
+func (n *Notification) Subject() string {
+	operation := "Backup"
+	if n.Operation == "restore" {
+		operation = "Restore"
+	}
+	switch n.Event {
+	case NotificationEventSuccess:
+		return fmt.Sprintf("%s of %s succeeded", operation, n.DatabaseName)
+	case NotificationEventFailure:
+		return fmt.Sprintf("%s of %s failed", operation, n.DatabaseName)
+	}
+	return fmt.Sprintf("%s of %s has been running for %s", operation, n.DatabaseName, n.Duration.Round(time.Second))
+}
+
+// Text returns the details of the notification as lines of text
+func (n *Notification) Text() string {
+	lines := []string{
+		n.Subject(),
+		fmt.Sprintf("Database: %s", n.DatabaseName),
+	}
+	if n.TaskID != "" {
+		lines = append(lines, fmt.Sprintf("Task ID: %s", n.TaskID))
+	}
+	if n.S3URI != "" {
+		lines = append(lines, fmt.Sprintf("S3 URI: %s", n.S3URI))
+	}
+	if n.Filename != "" && n.S3URI == "" {
+		lines = append(lines, fmt.Sprintf("File: %s", n.Filename))
+	}
+	lines = append(lines, fmt.Sprintf("Duration: %s", n.Duration.Round(time.Second)))
+	if n.Error != "" {
+		lines = append(lines, fmt.Sprintf("Error: %s", n.Error))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Send posts the notification to the webhook
+func (w *WebhookNotifier) Send(n *Notification) error {
+	payload, err := w.getPayload(n)
+	if err != nil {
+		return err
+	}
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: notificationTimeout}
+	}
+	response, err := httpClient.Post(w.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with status %s", response.Status)
+	}
+	return nil
+}
+
+func (w *WebhookNotifier) getPayload(n *Notification) ([]byte, error) {
+	switch w.Type {
+	case NotifierTypeSlack:
+		return json.Marshal(map[string]string{"text": n.Text()})
+	case NotifierTypeTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  n.Subject(),
+			"title":    n.Subject(),
+			"text":     strings.ReplaceAll(n.Text(), "\n", "\n\n"),
+		})
+	}
+	return json.Marshal(n)
+}
+
+// Send emails the notification
+func (e *EmailNotifier) Send(n *Notification) error {
+	host, _, err := net.SplitHostPort(e.Server)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	message := strings.Builder{}
+	message.WriteString(fmt.Sprintf("From: %s\r\n", e.From))
+	message.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(e.To, ", ")))
+	message.WriteString(fmt.Sprintf("Subject: [rds-backup] %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Subject())))
+	message.WriteString(fmt.Sprintf("Date: %s\r\n", n.Time.Format(time.RFC1123Z)))
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+	message.WriteString("\r\n")
+
+	return smtp.SendMail(e.Server, auth, e.From, e.To, []byte(message.String()))
+}
@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestNotification(event string) *Notification {
+	return &Notification{
+		Event:        event,
+		Operation:    "backup",
+		DatabaseName: "Orders",
+		TaskID:       "42",
+		S3URI:        "s3://backups/Orders-20240101020000.bak",
+		Duration:     90 * time.Second,
+		Error:        "Aborted the task because of a task failure or a concurrent RESTORE_DB request.",
+	}
+}
+
+// newWebhookStub returns a HTTP server which sends the body of each request to the returned channel
+func newWebhookStub(t *testing.T) (*httptest.Server, chan []byte) {
+	requests := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unable to read request body: %v", err)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, expected application/json", r.Header.Get("Content-Type"))
+		}
+		requests <- body
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookNotifierPayloads(t *testing.T) {
+	server, requests := newWebhookStub(t)
+
+	tests := []struct {
+		notifierType string
+		field        string
+		contains     []string
+	}{
+		{NotifierTypeWebhook, "error", []string{"concurrent RESTORE_DB"}},
+		{NotifierTypeSlack, "text", []string{"Backup of Orders failed", "Task ID: 42", "s3://backups/Orders-20240101020000.bak", "Duration: 1m30s", "concurrent RESTORE_DB"}},
+		{NotifierTypeTeams, "text", []string{"Task ID: 42", "concurrent RESTORE_DB"}},
+	}
+
+	for _, test := range tests {
+		notifier, err := NewNotifier(&NotifierConfig{Type: test.notifierType, URL: server.URL})
+		if err != nil {
+			t.Fatalf("NewNotifier(%s) returned error %v", test.notifierType, err)
+		}
+		if err = notifier.Send(newTestNotification(NotificationEventFailure)); err != nil {
+			t.Fatalf("Send() of %s returned error %v", test.notifierType, err)
+		}
+
+		payload := map[string]interface{}{}
+		if err = json.Unmarshal(<-requests, &payload); err != nil {
+			t.Fatalf("payload of %s is not JSON: %v", test.notifierType, err)
+		}
+		value, _ := payload[test.field].(string)
+		for _, expected := range test.contains {
+			if !strings.Contains(value, expected) {
+				t.Errorf("%s of %s payload %q does not contain %q", test.field, test.notifierType, value, expected)
+			}
+		}
+	}
+}
+
+func TestWebhookNotifierDocument(t *testing.T) {
+	server, requests := newWebhookStub(t)
+	notifier := &WebhookNotifier{URL: server.URL, Type: NotifierTypeWebhook}
+	if err := notifier.Send(newTestNotification(NotificationEventSuccess)); err != nil {
+		t.Fatalf("Send() returned error %v", err)
+	}
+
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(<-requests, &document); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	expected := map[string]interface{}{
+		"event":            NotificationEventSuccess,
+		"operation":        "backup",
+		"database":         "Orders",
+		"task_id":          "42",
+		"s3_uri":           "s3://backups/Orders-20240101020000.bak",
+		"duration_seconds": float64(90),
+	}
+	for key, value := range expected {
+		if document[key] != value {
+			t.Errorf("%s = %v, expected %v", key, document[key], value)
+		}
+	}
+}
+
+func TestWebhookNotifierFailedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL, Type: NotifierTypeSlack}
+	if err := notifier.Send(newTestNotification(NotificationEventSuccess)); err == nil {
+		t.Error("Send() to a failing webhook did not return error")
+	}
+}
+
+func TestNotifyEvents(t *testing.T) {
+	server, requests := newWebhookStub(t)
+	defer UseNotifiers(nil)
+
+	err := UseNotifiers([]NotifierConfig{
+		{Type: NotifierTypeWebhook, URL: server.URL, Events: []string{NotificationEventFailure}},
+		{Type: NotifierTypeWebhook, URL: server.URL, Events: []string{NotificationEventLongRunning}, LongRunningAfter: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("UseNotifiers() returned error %v", err)
+	}
+
+	Notify(newTestNotification(NotificationEventSuccess))
+	Notify(newTestNotification(NotificationEventFailure))
+	check := NewLongRunningCheck(*newTestNotification(""))
+	check(30 * time.Minute)
+	check(61 * time.Minute)
+	check(90 * time.Minute)
+
+	events := []string{}
+	for len(requests) > 0 {
+		document := map[string]interface{}{}
+		if err := json.Unmarshal(<-requests, &document); err != nil {
+			t.Fatalf("payload is not JSON: %v", err)
+		}
+		events = append(events, document["event"].(string))
+	}
+	expected := []string{NotificationEventFailure, NotificationEventLongRunning}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("notified events %v, expected %v", events, expected)
+	}
+}
+
+func TestUseNotifiersRejectsInvalidConfigs(t *testing.T) {
+	tests := []NotifierConfig{
+		{Type: "pager", URL: "http://localhost"},
+		{Type: NotifierTypeWebhook},
+		{Type: NotifierTypeSlack, URL: "http://localhost", Events: []string{"started"}},
+		{Type: NotifierTypeTeams, URL: "http://localhost", Events: []string{NotificationEventLongRunning}},
+		{Type: NotifierTypeEmail, SMTPServer: "localhost:25", From: "rds-backup@example.com"},
+		{Type: NotifierTypeEmail, SMTPServer: "localhost", From: "rds-backup@example.com", To: []string{"dba@example.com"}},
+	}
+	defer UseNotifiers(nil)
+
+	for _, test := range tests {
+		if err := UseNotifiers([]NotifierConfig{test}); err == nil {
+			t.Errorf("UseNotifiers(%+v) did not return error", test)
+		}
+	}
+}
+
+// runSMTPStub accepts a single SMTP session and sends the received message to the returned channel
+func runSMTPStub(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan string, 1)
+
+	go func() {
+		conn, errAccept := listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost ESMTP stub")
+		for {
+			line, errRead := reader.ReadString('\n')
+			if errRead != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "DATA"):
+				reply("354 end with <CRLF>.<CRLF>")
+				message := strings.Builder{}
+				for {
+					dataLine, errData := reader.ReadString('\n')
+					if errData != nil || dataLine == ".\r\n" {
+						break
+					}
+					message.WriteString(dataLine)
+				}
+				messages <- message.String()
+				reply("250 OK")
+			case strings.HasPrefix(command, "QUIT"):
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func TestEmailNotifier(t *testing.T) {
+	address, messages := runSMTPStub(t)
+	notifier, err := NewNotifier(&NotifierConfig{
+		Type:       NotifierTypeEmail,
+		SMTPServer: address,
+		From:       "rds-backup@example.com",
+		To:         []string{"dba@example.com", "oncall@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("NewNotifier() returned error %v", err)
+	}
+
+	n := newTestNotification(NotificationEventFailure)
+	n.DatabaseName = "Orders\r\nBcc: attacker@example.com"
+	if err = notifier.Send(n); err != nil {
+		t.Fatalf("Send() returned error %v", err)
+	}
+
+	message := <-messages
+	for _, expected := range []string{
+		"To: dba@example.com, oncall@example.com\r\n",
+		"Subject: [rds-backup] Backup of Orders  Bcc: attacker@example.com failed\r\n",
+		"Task ID: 42\r\n",
+		"concurrent RESTORE_DB",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("message does not contain %q:\n%s", expected, message)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// restoredServer is the server a database is restored onto, as described to
+// post-restore hooks
+type restoredServer struct {
+	// Server is the server in the form of sqlcmd -S
+	Server    string
+	Host      string
+	Port      string
+	Container string
+	Username  string
+	Password  string
+}
+
+// sqlScriptBatch is a batch of a script ended by GO, which is run Count times
+type sqlScriptBatch struct {
+	Text  string
+	Line  int
+	Count int
+}
+
+// batchSeparatorPattern matches GO (with an optional count) on a line of its own as in sqlcmd
+var batchSeparatorPattern = regexp.MustCompile(`(?i)^\s*GO(?:\s+(\d+))?\s*(?:--.*)?$`)
+
+// completeRestore runs the steps after a database is restored, which are
+// masking, fixing orphaned users and post-restore hooks
+func completeRestore(ctx context.Context, params *BaseRestoreParameters, server *restoredServer, run sqlBatchRunner) error {
+	if err := maskRestoredDatabase(ctx, params, run); err != nil {
+		return err
+	}
+	if err := fixOrphanedUsers(ctx, params, run); err != nil {
+		return err
+	}
+	return runPostRestore(ctx, params, server, run)
+}
+
+// runPostRestore runs the .sql and .sh files of PostRestoreDirectory in the
+// order of their names and stops at the first one which fails
+func runPostRestore(ctx context.Context, params *BaseRestoreParameters, server *restoredServer, run sqlBatchRunner) error {
+	if params.PostRestoreDirectory == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(params.PostRestoreDirectory)
+	if err != nil {
+		return err
+	}
+	log := logger.With("database", params.DatabaseName)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(params.PostRestoreDirectory, entry.Name())
+		var errStep error
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".sql":
+			log.Info("Running post-restore script", "path", path)
+			errStep = runSQLScript(ctx, path, params.DatabaseName, run)
+		case ".sh":
+			log.Info("Running post-restore hook", "path", path)
+			errStep = runShellHook(ctx, path, server.getEnvironment(params.DatabaseName))
+		default:
+			log.Debug("Skipped file in post-restore directory", "path", path)
+			continue
+		}
+		if errStep != nil {
+			return fmt.Errorf("Post-restore step %s failed: %w", entry.Name(), errStep)
+		}
+	}
+	log.Info("Post-restore steps have been completed")
+	return nil
+}
+
+// runSQLScript runs the batches of the script in the restored database
+func runSQLScript(ctx context.Context, path string, databaseName string, run sqlBatchRunner) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	quoted, errQuote := QuoteIdentifier(databaseName)
+	if errQuote != nil {
+		return errQuote
+	}
+	for _, batch := range splitSQLBatches(string(content)) {
+		for i := 0; i < batch.Count; i++ {
+			// USE is a batch of its own as statements such as CREATE PROCEDURE
+			// must be the first of their batches; the database stays selected
+			// in the session of sqlcmd for the batch after GO
+			output, errBatch := run(ctx, fmt.Sprintf("USE %s;\nGO\n%s", quoted, batch.Text))
+			if errBatch != nil {
+				return fmt.Errorf("batch at line %d: %w", batch.Line, errBatch)
+			}
+			if messages := strings.TrimSpace(output); messages != "" {
+				logger.Debug("Output of post-restore script", "path", path, "line", batch.Line, "output", messages)
+			}
+		}
+	}
+	return nil
+}
+
+// splitSQLBatches splits a script by lines of GO, which may be followed by
+// the number of times the batch is run
+func splitSQLBatches(script string) []sqlScriptBatch {
+	var batches []sqlScriptBatch
+	var lines []string
+	start := 1
+	addBatch := func(count int) {
+		text := strings.Join(lines, "\n")
+		if strings.TrimSpace(text) != "" {
+			batches = append(batches, sqlScriptBatch{Text: text, Line: start, Count: count})
+		}
+	}
+	for i, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		match := batchSeparatorPattern.FindStringSubmatch(line)
+		if match == nil {
+			lines = append(lines, line)
+			continue
+		}
+		count := 1
+		if match[1] != "" {
+			count, _ = strconv.Atoi(match[1])
+		}
+		addBatch(count)
+		lines = nil
+		start = i + 2
+	}
+	addBatch(1)
+	return batches
+}
+
+// runShellHook runs the script with sh and the variables describing the
+// restored database
+func runShellHook(ctx context.Context, path string, environment []string) error {
+	output, err := runCommand(ctx, &Command{Name: "sh", Args: []string{path}, Environment: environment})
+	if messages := strings.TrimSpace(output); messages != "" {
+		logger.Info("Output of post-restore hook", "path", path, "output", messages)
+	}
+	if err != nil {
+		var commandError *CommandError
+		if errors.As(err, &commandError) && strings.TrimSpace(commandError.Stderr) != "" {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(commandError.Stderr))
+		}
+		return err
+	}
+	return nil
+}
+
+// getEnvironment returns the variables describing the restored database to
+// hooks; the password is passed in SQLCMDPASSWORD so that sqlcmd can log in
+func (s *restoredServer) getEnvironment(databaseName string) []string {
+	return []string{
+		fmt.Sprintf("RDS_BACKUP_DATABASE=%s", databaseName),
+		fmt.Sprintf("RDS_BACKUP_HOST=%s", s.Host),
+		fmt.Sprintf("RDS_BACKUP_PORT=%s", s.Port),
+		fmt.Sprintf("RDS_BACKUP_SERVER=%s", s.Server),
+		fmt.Sprintf("RDS_BACKUP_CONTAINER=%s", s.Container),
+		fmt.Sprintf("RDS_BACKUP_USERNAME=%s", s.Username),
+		fmt.Sprintf("%s=%s", sqlcmdPasswordVariable, s.Password),
+	}
+}
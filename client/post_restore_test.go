@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQLBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []sqlScriptBatch
+	}{
+		{
+			name:     "no separator",
+			script:   "ALTER DATABASE CURRENT SET RECOVERY SIMPLE;\n",
+			expected: []sqlScriptBatch{{Text: "ALTER DATABASE CURRENT SET RECOVERY SIMPLE;\n", Line: 1, Count: 1}},
+		},
+		{
+			name:   "separators with count and comment",
+			script: "CREATE TABLE t (id INT);\r\ngo\r\nINSERT INTO t VALUES (1);\n  GO 3  \n\nGO -- end\n",
+			expected: []sqlScriptBatch{
+				{Text: "CREATE TABLE t (id INT);", Line: 1, Count: 1},
+				{Text: "INSERT INTO t VALUES (1);", Line: 3, Count: 3},
+			},
+		},
+		{
+			name:     "separator within a line",
+			script:   "SELECT 'GO'\nGOTO done\n",
+			expected: []sqlScriptBatch{{Text: "SELECT 'GO'\nGOTO done\n", Line: 1, Count: 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := splitSQLBatches(test.script); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %#v but got %#v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunSQLScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01-procedures.sql")
+	script := "CREATE PROCEDURE dbo.Cleanup AS SELECT 1;\nGO\nINSERT INTO dbo.Runs DEFAULT VALUES;\nGO 2\n"
+	if err := os.WriteFile(path, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var batches []string
+	run := func(ctx context.Context, batch string) (string, error) {
+		batches = append(batches, batch)
+		return "", nil
+	}
+
+	if err := runSQLScript(context.Background(), path, "sales db", run); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+
+	// CREATE PROCEDURE must be the first statement of its batch
+	expected := []string{
+		"USE [sales db];\nGO\nCREATE PROCEDURE dbo.Cleanup AS SELECT 1;",
+		"USE [sales db];\nGO\nINSERT INTO dbo.Runs DEFAULT VALUES;",
+		"USE [sales db];\nGO\nINSERT INTO dbo.Runs DEFAULT VALUES;",
+	}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("expected %q but got %q", expected, batches)
+	}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupRestoreOption is the option of RDS option groups enabling native backup and restore
+const backupRestoreOption = "SQLSERVER_BACKUP_RESTORE"
+
+// serverConnectionTimeout limits the time of connecting to a SQL server in checking its connectivity
+const serverConnectionTimeout = 10 * time.Second
+
+// GetServerAddress returns the host and port of a server specified in the
+// form of sqlcmd -S (such as tcp:host,port or host\instance)
+func GetServerAddress(server string) string {
+	host, port, _ := parseServer(server)
+	return net.JoinHostPort(host, port)
+}
+
+// parseServer returns the host, the port and the instance name (if any) of a
+// server specified in the form of sqlcmd -S
+func parseServer(server string) (string, string, string) {
+	host := strings.TrimPrefix(server, "tcp:")
+	port := strconv.Itoa(DefaultServerPort)
+	instance := ""
+	if index := strings.LastIndex(host, ","); index >= 0 {
+		port = strings.TrimSpace(host[index+1:])
+		host = host[:index]
+	}
+	if index := strings.Index(host, "\\"); index >= 0 {
+		instance = host[index+1:]
+		host = host[:index]
+	}
+	return strings.TrimSpace(host), port, instance
+}
+
+// CheckServerConnection returns an error if a TCP connection to the server cannot be made
+func CheckServerConnection(ctx context.Context, server string) error {
+	dialer := net.Dialer{Timeout: serverConnectionTimeout}
+	connection, err := dialer.DialContext(ctx, "tcp", GetServerAddress(server))
+	if err != nil {
+		return NewError(ErrorKindEnvironment, err)
+	}
+	return connection.Close()
+}
+
+// IsBackupRestoreOptionEnabled returns if an option group of the RDS instance
+// of the server endpoint contains SQLSERVER_BACKUP_RESTORE
+func IsBackupRestoreOptionEnabled(ctx context.Context, server string) (bool, error) {
+	host, _, err := net.SplitHostPort(GetServerAddress(server))
+	if err != nil {
+		return false, NewError(ErrorKindValidation, err)
+	}
+	regionArgs := []string{}
+	if region := getRDSRegion(host); region != "" {
+		regionArgs = append(regionArgs, "--region", region)
+	}
+
+	args := []string{
+		"rds",
+		"describe-db-instances",
+		"--query",
+		fmt.Sprintf("DBInstances[?Endpoint.Address=='%s'].OptionGroupMemberships[].OptionGroupName", host),
+		"--output",
+		"text",
+	}
+	output, err := executeCommand(ctx, append(args, regionArgs...))
+	if err != nil {
+		return false, err
+	}
+	groups := strings.Fields(output)
+	if len(groups) == 0 {
+		return false, fmt.Errorf("Unable to find an RDS instance with endpoint %s", host)
+	}
+
+	for _, group := range groups {
+		args := []string{
+			"rds",
+			"describe-option-groups",
+			"--option-group-name",
+			group,
+			"--query",
+			"OptionGroupsList[].Options[].OptionName",
+			"--output",
+			"text",
+		}
+		options, errOptions := executeCommand(ctx, append(args, regionArgs...))
+		if errOptions != nil {
+			return false, errOptions
+		}
+		for _, option := range strings.Fields(options) {
+			if option == backupRestoreOption {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// getRDSRegion returns the region in an RDS endpoint (such as
+// db.abc123.ap-southeast-1.rds.amazonaws.com) or an empty string if it is not one
+func getRDSRegion(host string) string {
+	parts := strings.Split(strings.ToLower(host), ".")
+	if len(parts) < 6 || strings.Join(parts[len(parts)-3:], ".") != "rds.amazonaws.com" {
+		return ""
+	}
+	return parts[len(parts)-4]
+}
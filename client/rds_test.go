@@ -0,0 +1,39 @@
+package client
+
+import "testing"
+
+func TestGetServerAddress(t *testing.T) {
+	tests := []struct {
+		server   string
+		expected string
+	}{
+		{"db.abc123.ap-southeast-1.rds.amazonaws.com", "db.abc123.ap-southeast-1.rds.amazonaws.com:1433"},
+		{"db.abc123.ap-southeast-1.rds.amazonaws.com,1435", "db.abc123.ap-southeast-1.rds.amazonaws.com:1435"},
+		{"tcp:10.0.0.5,1433", "10.0.0.5:1433"},
+		{"localhost\\SQLEXPRESS", "localhost:1433"},
+	}
+
+	for _, test := range tests {
+		if actual := GetServerAddress(test.server); actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.server, test.expected, actual)
+		}
+	}
+}
+
+func TestGetRDSRegion(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"db.abc123.ap-southeast-1.rds.amazonaws.com", "ap-southeast-1"},
+		{"DB.ABC123.US-EAST-1.RDS.AMAZONAWS.COM", "us-east-1"},
+		{"sql.example.com", ""},
+		{"localhost", ""},
+	}
+
+	for _, test := range tests {
+		if actual := getRDSRegion(test.host); actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.host, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleItem is a type of backup to be taken either at a time every day or at a regular interval
+type ScheduleItem struct {
+	BackupType string
+	TimeOfDay  time.Duration
+	Interval   time.Duration
+}
+
+// ParseSchedulePlan parses a plan such as "full daily 02:00, diff hourly" into
+// schedule items; each item is a backup type (full or diff) followed by
+// "daily HH:MM", "hourly" or "every <duration>"
+func ParseSchedulePlan(plan string) ([]ScheduleItem, error) {
+	items := []ScheduleItem{}
+	for _, itemPlan := range strings.Split(plan, ",") {
+		fields := strings.Fields(strings.ToLower(itemPlan))
+		if len(fields) == 0 {
+			continue
+		}
+		item, err := parseScheduleItem(fields)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schedule [%s]: %s", strings.TrimSpace(itemPlan), err.Error())
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("Schedule [%s] is empty", plan)
+	}
+	return items, nil
+}
+
+func parseScheduleItem(fields []string) (ScheduleItem, error) {
+	item := ScheduleItem{}
+
+	switch fields[0] {
+	case "full":
+		item.BackupType = BackupTypeFull
+	case "diff", "differential":
+		item.BackupType = BackupTypeDifferential
+	default:
+		return item, fmt.Errorf("unknown backup type %s (expected full or diff)", fields[0])
+	}
+
+	if len(fields) < 2 {
+		return item, fmt.Errorf("frequency is not specified")
+	}
+	switch fields[1] {
+	case "hourly":
+		if len(fields) != 2 {
+			return item, fmt.Errorf("unexpected %s", strings.Join(fields[2:], " "))
+		}
+		item.Interval = time.Hour
+	case "daily":
+		if len(fields) != 3 {
+			return item, fmt.Errorf("time of day (HH:MM) must be specified after daily")
+		}
+		timeOfDay, err := parseTimeOfDay(fields[2])
+		if err != nil {
+			return item, err
+		}
+		item.TimeOfDay = timeOfDay
+	case "every":
+		if len(fields) != 3 {
+			return item, fmt.Errorf("interval (such as 30m or 6h) must be specified after every")
+		}
+		interval, err := time.ParseDuration(fields[2])
+		if err != nil {
+			return item, err
+		}
+		if interval < time.Minute {
+			return item, fmt.Errorf("interval must be at least a minute")
+		}
+		item.Interval = interval
+	default:
+		return item, fmt.Errorf("unknown frequency %s (expected daily, hourly or every)", fields[1])
+	}
+
+	return item, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("time of day %s is not in form of HH:MM", value)
+	}
+	hour, errHour := strconv.Atoi(parts[0])
+	minute, errMinute := strconv.Atoi(parts[1])
+	if errHour != nil || errMinute != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("time of day %s is not in form of HH:MM", value)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
+
+// NextRun returns the first time this item is due after the specified time
+func (i ScheduleItem) NextRun(after time.Time) time.Time {
+	if i.Interval > 0 {
+		return after.Truncate(i.Interval).Add(i.Interval)
+	}
+	year, month, day := after.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, after.Location())
+	next := midnight.Add(i.TimeOfDay)
+	if !next.After(after) {
+		next = time.Date(year, month, day+1, 0, 0, 0, 0, after.Location()).Add(i.TimeOfDay)
+	}
+	return next
+}
+
+// String returns the item in the same form as it is parsed
+func (i ScheduleItem) String() string {
+	backupType := "full"
+	if i.BackupType == BackupTypeDifferential {
+		backupType = "diff"
+	}
+	if i.Interval == time.Hour {
+		return fmt.Sprintf("%s hourly", backupType)
+	}
+	if i.Interval > 0 {
+		return fmt.Sprintf("%s every %s", backupType, i.Interval)
+	}
+	return fmt.Sprintf("%s daily %02d:%02d", backupType, int(i.TimeOfDay.Hours()), int(i.TimeOfDay.Minutes())%60)
+}
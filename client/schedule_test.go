@@ -0,0 +1,74 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedulePlan(t *testing.T) {
+	tests := []struct {
+		plan          string
+		expected      []ScheduleItem
+		expectedError string
+	}{
+		{
+			plan: "full daily 02:00, diff hourly",
+			expected: []ScheduleItem{
+				{BackupType: BackupTypeFull, TimeOfDay: 2 * time.Hour},
+				{BackupType: BackupTypeDifferential, Interval: time.Hour},
+			},
+		},
+		{
+			plan:     "Differential every 30m,",
+			expected: []ScheduleItem{{BackupType: BackupTypeDifferential, Interval: 30 * time.Minute}},
+		},
+		{plan: "", expectedError: "is empty"},
+		{plan: "log hourly", expectedError: "unknown backup type log"},
+		{plan: "full", expectedError: "frequency is not specified"},
+		{plan: "full daily", expectedError: "time of day (HH:MM) must be specified"},
+		{plan: "full daily 24:00", expectedError: "not in form of HH:MM"},
+		{plan: "full hourly now", expectedError: "unexpected now"},
+		{plan: "diff every 30s", expectedError: "at least a minute"},
+		{plan: "diff weekly", expectedError: "unknown frequency weekly"},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseSchedulePlan(test.plan)
+		if test.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Errorf("%q: expected error containing %q but got %v", test.plan, test.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected no error but got %v", test.plan, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected %v but got %v", test.plan, test.expected, actual)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		item     ScheduleItem
+		after    time.Time
+		expected time.Time
+	}{
+		{ScheduleItem{TimeOfDay: 2 * time.Hour}, now, time.Date(2024, 3, 2, 2, 0, 0, 0, time.UTC)},
+		{ScheduleItem{TimeOfDay: 11 * time.Hour}, now, time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{ScheduleItem{TimeOfDay: 2 * time.Hour}, time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 2, 0, 0, 0, time.UTC)},
+		{ScheduleItem{Interval: time.Hour}, now, time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{ScheduleItem{Interval: 30 * time.Minute}, now, time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		if actual := test.item.NextRun(test.after); !actual.Equal(test.expected) {
+			t.Errorf("%s after %s: expected %s but got %s", test.item, test.after, test.expected, actual)
+		}
+	}
+}
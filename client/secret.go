@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const secretsManagerArnPrefix = "arn:aws:secretsmanager:"
+const fileSecretPrefix = "file://"
+const environmentSecretPrefix = "env://"
+const keyringSecretPrefix = "keyring://"
+
+// sqlcmdPasswordVariable is read by sqlcmd so that passwords are not passed as arguments
+const sqlcmdPasswordVariable = "SQLCMDPASSWORD"
+
+const redactedValue = "********"
+
+// secrets are redacted from the commands logged at debug level
+var secrets = []string{}
+
+// ResolvePassword returns the password referenced by the specified value, which can be
+// an ARN of AWS Secrets Manager secret, file://path, env://VARIABLE, keyring://service/account
+// or the password itself
+func ResolvePassword(ctx context.Context, value string) (string, error) {
+	password := value
+	var err error
+
+	switch {
+	case strings.HasPrefix(value, secretsManagerArnPrefix):
+		password, err = getSecretsManagerPassword(ctx, value)
+	case strings.HasPrefix(value, fileSecretPrefix):
+		password, err = getFilePassword(strings.TrimPrefix(value, fileSecretPrefix))
+	case strings.HasPrefix(value, environmentSecretPrefix):
+		password, err = getEnvironmentPassword(strings.TrimPrefix(value, environmentSecretPrefix))
+	case strings.HasPrefix(value, keyringSecretPrefix):
+		password, err = getKeyringPassword(ctx, strings.TrimPrefix(value, keyringSecretPrefix))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	RegisterSecret(password)
+	return password, nil
+}
+
+// RegisterSecret makes the specified value redacted in debug logging
+func RegisterSecret(value string) {
+	if value != "" {
+		secrets = append(secrets, value)
+	}
+}
+
+func redact(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		for _, secret := range secrets {
+			arg = strings.ReplaceAll(arg, secret, redactedValue)
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+func getSecretsManagerPassword(ctx context.Context, arn string) (string, error) {
+	args := []string{
+		"secretsmanager",
+		"get-secret-value",
+		"--secret-id",
+		arn,
+		"--query",
+		"SecretString",
+		"--output",
+		"text",
+	}
+	output, err := executeCommand(ctx, args)
+	if err != nil {
+		return "", NewError(ErrorKindAuth, fmt.Errorf("Unable to retrieve secret %s: %s", arn, err.Error()))
+	}
+	secret := strings.TrimRight(output, "\r\n")
+
+	// secrets created for RDS are JSON documents with the password as a field
+	document := map[string]interface{}{}
+	if errJSON := json.Unmarshal([]byte(secret), &document); errJSON == nil {
+		password, ok := document["password"].(string)
+		if !ok {
+			return "", NewError(ErrorKindValidation, fmt.Errorf("Secret %s does not contain a password", arn))
+		}
+		return password, nil
+	}
+	return secret, nil
+}
+
+func getFilePassword(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func getEnvironmentPassword(name string) (string, error) {
+	password, ok := os.LookupEnv(name)
+	if !ok {
+		return "", NewError(ErrorKindEnvironment, fmt.Errorf("Environment variable %s is not set", name))
+	}
+	return password, nil
+}
+
+func getKeyringPassword(ctx context.Context, reference string) (string, error) {
+	parts := strings.SplitN(reference, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", NewError(ErrorKindValidation, fmt.Errorf("keyring://%s must be in form of keyring://service/account", reference))
+	}
+	service := parts[0]
+	account := parts[1]
+
+	var command *Command
+	switch runtime.GOOS {
+	case "darwin":
+		command = &Command{Name: "security", Args: []string{"find-generic-password", "-s", service, "-a", account, "-w"}}
+	case "linux":
+		command = &Command{Name: "secret-tool", Args: []string{"lookup", "service", service, "username", account}}
+	default:
+		return "", NewError(ErrorKindEnvironment, fmt.Errorf("OS keyring is not supported on %s", runtime.GOOS))
+	}
+
+	output, err := runCommand(ctx, command)
+	if err != nil {
+		return "", NewError(ErrorKindAuth, fmt.Errorf("Unable to find password of %s in %s of OS keyring: %s", account, service, err.Error()))
+	}
+	return strings.TrimRight(output, "\r\n"), nil
+}
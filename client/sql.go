@@ -0,0 +1,185 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxIdentifierLength is the length limit of sysname, beyond which QUOTENAME returns NULL
+const maxIdentifierLength = 128
+
+// sqlParameter is a value bound to a variable referenced in a statement
+type sqlParameter struct {
+	Name  string
+	Value interface{}
+}
+
+// sqlQuery is a statement with its values kept apart from the text so that
+// values never have to be spliced into the statement
+type sqlQuery struct {
+	Text       string
+	Parameters []sqlParameter
+}
+
+func newQuery(text string, parameters ...sqlParameter) *sqlQuery {
+	return &sqlQuery{
+		Text:       text,
+		Parameters: parameters,
+	}
+}
+
+func param(name string, value interface{}) sqlParameter {
+	return sqlParameter{Name: name, Value: value}
+}
+
+// QuoteIdentifier returns the name as a delimited identifier in the same way as QUOTENAME
+func QuoteIdentifier(name string) (string, error) {
+	if len([]rune(name)) > maxIdentifierLength {
+		return "", fmt.Errorf("Identifier %s is longer than %d characters", name, maxIdentifierLength)
+	}
+	return fmt.Sprintf("[%s]", strings.ReplaceAll(name, "]", "]]")), nil
+}
+
+// QuoteString returns the value as a Unicode string literal
+func QuoteString(value string) string {
+	return fmt.Sprintf("N'%s'", strings.ReplaceAll(value, "'", "''"))
+}
+
+// render returns a batch declaring every parameter as a variable ahead of the
+// statement, for transports such as sqlcmd which have no parameter binding
+func (q *sqlQuery) render() (string, error) {
+	builder := strings.Builder{}
+	for _, p := range q.Parameters {
+		if !isValidParameterName(p.Name) {
+			return "", fmt.Errorf("Invalid parameter name %s", p.Name)
+		}
+		switch value := p.Value.(type) {
+		case string:
+			builder.WriteString(fmt.Sprintf("DECLARE @%s NVARCHAR(4000) = %s;\n", p.Name, QuoteString(value)))
+		case int:
+			builder.WriteString(fmt.Sprintf("DECLARE @%s INT = %d;\n", p.Name, value))
+		default:
+			return "", fmt.Errorf("Unsupported type of parameter %s", p.Name)
+		}
+	}
+	builder.WriteString(q.Text)
+	return builder.String(), nil
+}
+
+func isValidParameterName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTaskID(taskID string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(taskID))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid task ID %s", taskID)
+	}
+	return id, nil
+}
+
+func getTaskStatusQuery(databaseName string, selection string) *sqlQuery {
+	return newQuery(
+		fmt.Sprintf(`SET NOCOUNT ON
+
+	%s
+
+	INSERT INTO @s
+	exec msdb.dbo.rds_task_status @db_name=@database_name
+
+	%s
+
+	SET NOCOUNT OFF`, statusTableDeclaration, selection),
+		param("database_name", databaseName),
+	)
+}
+
+func getStatusQuery(databaseName string, taskID string) (*sqlQuery, error) {
+	if taskID == "" {
+		return getTaskStatusQuery(databaseName, "SELECT TOP 1 lifecycle FROM @s"), nil
+	}
+	id, err := parseTaskID(taskID)
+	if err != nil {
+		return nil, err
+	}
+	query := getTaskStatusQuery(databaseName, "SELECT lifecycle FROM @s WHERE task_id = @task_id")
+	query.Parameters = append(query.Parameters, param("task_id", id))
+	return query, nil
+}
+
+func getCompletionPercentageQuery(databaseName string) *sqlQuery {
+	return getTaskStatusQuery(databaseName, "SELECT TOP 1 complete FROM @s")
+}
+
+func getTaskMessageQuery(databaseName string) *sqlQuery {
+	return getTaskStatusQuery(databaseName, "SELECT TOP 1 task_info FROM @s")
+}
+
+func getStartBackupQuery(params *BackupParameters) *sqlQuery {
+	backupType := params.Type
+	if backupType == "" {
+		backupType = BackupTypeFull
+	}
+	return newQuery(
+		fmt.Sprintf(`SET NOCOUNT ON
+
+		%s
+
+		INSERT INTO @s
+		exec msdb.dbo.rds_backup_database
+			@source_db_name=@database_name,
+			@s3_arn_to_backup_to=@s3_arn,
+			@overwrite_S3_backup_file=1,
+			@type=@backup_type;
+
+		SELECT TOP 1 task_id FROM @s
+
+		SET NOCOUNT OFF`, createTableDeclaration),
+		param("database_name", params.DatabaseName),
+		param("s3_arn", fmt.Sprintf("arn:aws:s3:::%s/%s", params.BucketName, params.Filename)),
+		param("backup_type", backupType),
+	)
+}
+
+func getRestoreQuery(databaseName string, pathToBackup string, dataName string, mdfPath string, logName string, ldfPath string) *sqlQuery {
+	return newQuery(
+		"RESTORE DATABASE @database_name FROM DISK=@backup_path WITH FILE=1, NOUNLOAD, REPLACE, STATS=5, MOVE @data_name TO @mdf_path, MOVE @log_name TO @ldf_path",
+		param("database_name", databaseName),
+		param("backup_path", pathToBackup),
+		param("data_name", dataName),
+		param("mdf_path", mdfPath),
+		param("log_name", logName),
+		param("ldf_path", ldfPath),
+	)
+}
+
+func getServerVersionQuery() *sqlQuery {
+	return newQuery("SET NOCOUNT ON; SELECT @@VERSION")
+}
+
+// getServerPathsQuery returns the default directories of data, log and
+// backups of the server; the backup directory is read from the registry of
+// the instance on versions before InstanceDefaultBackupPath (SQL Server 2019)
+func getServerPathsQuery() *sqlQuery {
+	return newQuery(`SET NOCOUNT ON;
+DECLARE @backup_path NVARCHAR(4000) = CAST(SERVERPROPERTY('InstanceDefaultBackupPath') AS NVARCHAR(4000));
+IF @backup_path IS NULL
+	EXEC master.dbo.xp_instance_regread N'HKEY_LOCAL_MACHINE', N'Software\Microsoft\MSSQLServer\MSSQLServer', N'BackupDirectory', @backup_path OUTPUT;
+SELECT CAST(SERVERPROPERTY('InstanceDefaultDataPath') AS NVARCHAR(4000)), CAST(SERVERPROPERTY('InstanceDefaultLogPath') AS NVARCHAR(4000)), @backup_path`)
+}
+
+const dataFileType = 0
+const logFileType = 1
+
+func getLogicalNameQuery(fileType int) *sqlQuery {
+	return newQuery(fmt.Sprintf("SELECT name FROM sys.master_files WHERE database_id = db_id() AND type = %d", fileType))
+}
@@ -1,24 +1,77 @@
 package client
 
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
 // SQLClient performs SQL operations
 type SQLClient interface {
-	IsEnvironmentSatisfied() bool
-	GetStatus(*DatabaseParameters, string) (string, error)
-	GetCompletionPercentage(*DatabaseParameters) (string, error)
-	GetTaskMessage(*DatabaseParameters) (string, error)
-	StartBackup(*BackupParameters) (string, error)
-	GetLogicalNames(*DatabaseParameters) (string, string, error)
+	IsEnvironmentSatisfied(context.Context) bool
+	GetStatus(context.Context, *DatabaseParameters, string) (string, error)
+	GetCompletionPercentage(context.Context, *DatabaseParameters) (string, error)
+	GetTaskMessage(context.Context, *DatabaseParameters) (string, error)
+	StartBackup(context.Context, *BackupParameters) (string, error)
+	GetLogicalNames(context.Context, *DatabaseParameters) (string, string, error)
+	GetServerVersion(context.Context, *DatabaseParameters) (string, error)
+}
+
+// sqlClient is returned by GetClient instead of a detected client if it is set
+var sqlClient SQLClient
+
+// UseSQLClient makes GetClient return the specified client; a client is
+// detected again if nil is specified
+func UseSQLClient(c SQLClient) {
+	sqlClient = c
 }
 
-// GetClient returns a SQL client which can be run on this machine
-func GetClient() SQLClient {
-	nativeCli := &NativeClient{}
-	if nativeCli.IsEnvironmentSatisfied() {
-		return nativeCli
+// SQLClientNative runs sqlcmd installed on this machine
+const SQLClientNative = "native"
+
+// SQLClientDocker runs sqlcmd in a container of SQLToolsImage
+const SQLClientDocker = "docker"
+
+// SQLClientGo connects to SQL server without any external tool
+const SQLClientGo = "go"
+
+// SQLClientNames are the names of the SQL clients which can be selected
+var SQLClientNames = []string{SQLClientNative, SQLClientDocker, SQLClientGo}
+
+// GetClient returns the SQL client of the specified name or, if no name is
+// specified, the first one of native, Docker and Go which can be run on this
+// machine; it returns an error of kind ErrorKindEnvironment if the selected
+// client cannot be run
+func GetClient(ctx context.Context, name string) (SQLClient, error) {
+	if sqlClient != nil {
+		return sqlClient, nil
+	}
+	if name == "" {
+		for _, c := range []SQLClient{&NativeClient{}, &DockerSQLClient{}} {
+			if c.IsEnvironmentSatisfied(ctx) {
+				return c, nil
+			}
+		}
+		return &GoClient{}, nil
+	}
+	c, err := newSQLClient(name)
+	if err != nil {
+		return nil, NewError(ErrorKindValidation, err)
 	}
-	dockerCli := &DockerSQLClient{}
-	if dockerCli.IsEnvironmentSatisfied() {
-		return dockerCli
+	if !c.IsEnvironmentSatisfied(ctx) {
+		return nil, NewError(ErrorKindEnvironment, fmt.Errorf("SQL client %s cannot be run on this machine", name))
+	}
+	return c, nil
+}
+
+func newSQLClient(name string) (SQLClient, error) {
+	switch name {
+	case SQLClientNative:
+		return &NativeClient{}, nil
+	case SQLClientDocker:
+		return &DockerSQLClient{}, nil
+	case SQLClientGo:
+		return &GoClient{}, nil
 	}
-	return nil
+	return nil, errors.New("Unknown SQL client " + name)
 }
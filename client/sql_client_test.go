@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGetClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected SQLClient
+		kind     ErrorKind
+	}{
+		{name: "", err: nil, expected: &NativeClient{}},
+		{name: "", err: errors.New("executable file not found"), expected: &GoClient{}},
+		{name: SQLClientGo, err: nil, expected: &GoClient{}},
+		{name: SQLClientDocker, err: nil, expected: &DockerSQLClient{}},
+		{name: SQLClientNative, err: errors.New("executable file not found"), kind: ErrorKindEnvironment},
+		{name: "odbc", err: nil, kind: ErrorKindValidation},
+	}
+
+	defer UseCommandRunner(nil)
+	for _, test := range tests {
+		UseCommandRunner(&stubRunner{output: "24.0.7\n", err: test.err})
+		c, err := GetClient(context.Background(), test.name)
+		if GetErrorKind(err) != test.kind {
+			t.Errorf("%q: expected %s error but got %v", test.name, test.kind, err)
+		}
+		if fmt.Sprintf("%T", c) != fmt.Sprintf("%T", test.expected) {
+			t.Errorf("%q: expected %T but got %T", test.name, test.expected, c)
+		}
+	}
+}
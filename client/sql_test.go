@@ -0,0 +1,198 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+var hostileNames = []string{
+	"Orders",
+	"Orders]",
+	"Or]ders",
+	"Orders'",
+	"O'Brien",
+	"Orders]; DROP DATABASE master; --",
+	"Orders'; DROP DATABASE master; --",
+	"[Orders]",
+	"$(SQLCMDPASSWORD)",
+	"Orders\nGO\nSELECT 1",
+	"訂單",
+	"",
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Orders", "[Orders]"},
+		{"Orders]", "[Orders]]]"},
+		{"Or]ders", "[Or]]ders]"},
+		{"[Orders]", "[[Orders]]]"},
+		{"O'Brien", "[O'Brien]"},
+		{"Orders]; DROP DATABASE master; --", "[Orders]]; DROP DATABASE master; --]"},
+		{"訂單", "[訂單]"},
+		{"", "[]"},
+	}
+
+	for _, test := range tests {
+		actual, err := QuoteIdentifier(test.name)
+		if err != nil {
+			t.Errorf("QuoteIdentifier(%q) returned error %v", test.name, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("QuoteIdentifier(%q) = %q, expected %q", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestQuoteIdentifierTooLong(t *testing.T) {
+	if _, err := QuoteIdentifier(strings.Repeat("a", maxIdentifierLength)); err != nil {
+		t.Errorf("QuoteIdentifier of %d characters returned error %v", maxIdentifierLength, err)
+	}
+	if _, err := QuoteIdentifier(strings.Repeat("a", maxIdentifierLength+1)); err == nil {
+		t.Errorf("QuoteIdentifier of %d characters did not return error", maxIdentifierLength+1)
+	}
+}
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"Orders", "N'Orders'"},
+		{"O'Brien", "N'O''Brien'"},
+		{"''", "N''''''"},
+		{"Orders'; DROP DATABASE master; --", "N'Orders''; DROP DATABASE master; --'"},
+		{"Orders]", "N'Orders]'"},
+		{"訂單", "N'訂單'"},
+		{"", "N''"},
+	}
+
+	for _, test := range tests {
+		actual := QuoteString(test.value)
+		if actual != test.expected {
+			t.Errorf("QuoteString(%q) = %q, expected %q", test.value, actual, test.expected)
+		}
+	}
+}
+
+func TestRenderKeepsValuesOutOfStatement(t *testing.T) {
+	for _, name := range hostileNames {
+		query := getStartBackupQuery(&BackupParameters{
+			DatabaseParameters: DatabaseParameters{DatabaseName: name},
+			BucketName:         name,
+			Filename:           name,
+		})
+		if strings.Contains(query.Text, name) && name != "" {
+			t.Errorf("statement contains value %q", name)
+		}
+
+		rendered, err := query.render()
+		if err != nil {
+			t.Errorf("render() with value %q returned error %v", name, err)
+			continue
+		}
+		expectedDeclaration := "DECLARE @database_name NVARCHAR(4000) = " + QuoteString(name) + ";\n"
+		if !strings.HasPrefix(rendered, expectedDeclaration) {
+			t.Errorf("render() with value %q does not start with %q", name, expectedDeclaration)
+		}
+		if !strings.HasSuffix(rendered, query.Text) {
+			t.Errorf("render() with value %q does not end with the statement", name)
+		}
+	}
+}
+
+func TestRenderRestoreQuery(t *testing.T) {
+	for _, name := range hostileNames {
+		query := getRestoreQuery(name, "/var/backups/"+name, name, name+".mdf", name, name+".ldf")
+		rendered, err := query.render()
+		if err != nil {
+			t.Errorf("render() with value %q returned error %v", name, err)
+			continue
+		}
+		statement := rendered[strings.LastIndex(rendered, ";\n")+2:]
+		if statement != query.Text {
+			t.Errorf("render() with value %q changed the statement to %q", name, statement)
+		}
+		if strings.Count(rendered, "DECLARE @") != len(query.Parameters) {
+			t.Errorf("render() with value %q declared unexpected number of variables", name)
+		}
+	}
+}
+
+func TestRenderRejectsInvalidParameters(t *testing.T) {
+	tests := []sqlParameter{
+		param("", "value"),
+		param("name; DROP DATABASE master", "value"),
+		param("name", 1.5),
+		param("name", nil),
+	}
+
+	for _, test := range tests {
+		if _, err := newQuery("SELECT 1", test).render(); err == nil {
+			t.Errorf("render() with parameter %q (%v) did not return error", test.Name, test.Value)
+		}
+	}
+}
+
+func TestGetStatusQuery(t *testing.T) {
+	tests := []struct {
+		taskID        string
+		isError       bool
+		parameterSize int
+	}{
+		{"", false, 1},
+		{"42", false, 2},
+		{" 42\r", false, 2},
+		{"42; DROP DATABASE master", true, 0},
+		{"0x2A", true, 0},
+		{"$(taskID)", true, 0},
+	}
+
+	for _, test := range tests {
+		query, err := getStatusQuery("Orders", test.taskID)
+		if test.isError {
+			if err == nil {
+				t.Errorf("getStatusQuery(%q) did not return error", test.taskID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("getStatusQuery(%q) returned error %v", test.taskID, err)
+			continue
+		}
+		if len(query.Parameters) != test.parameterSize {
+			t.Errorf("getStatusQuery(%q) has %d parameters, expected %d", test.taskID, len(query.Parameters), test.parameterSize)
+		}
+	}
+}
+
+func TestGetSQLCommandArgsDisablesVariableSubstitution(t *testing.T) {
+	params := &DatabaseParameters{
+		Server:       "server",
+		Username:     "user",
+		Password:     "password",
+		DatabaseName: "$(SQLCMDPASSWORD)",
+	}
+	args, err := getSQLCommandArgs(params, getTaskMessageQuery(params.DatabaseName))
+	if err != nil {
+		t.Fatalf("getSQLCommandArgs() returned error %v", err)
+	}
+	if !containsArg(args, "-x") {
+		t.Errorf("getSQLCommandArgs() does not disable variable substitution: %v", args)
+	}
+	if containsArg(args, params.Password) {
+		t.Errorf("getSQLCommandArgs() contains password: %v", args)
+	}
+}
+
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
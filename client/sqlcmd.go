@@ -0,0 +1,68 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sqlcmdColumnSeparator separates the columns of a row in the output of sqlcmd
+const sqlcmdColumnSeparator = "|"
+
+// sqlcmdOutputArgs makes sqlcmd exit with a failure on SQL errors (-b),
+// write messages (such as warnings) to standard error (-r 1) and write rows
+// without headers (-h -1) or padding (-W) with columns separated by
+// sqlcmdColumnSeparator (-s) so that the output can be parsed into records
+var sqlcmdOutputArgs = []string{"-b", "-r", "1", "-h", "-1", "-W", "-s", sqlcmdColumnSeparator}
+
+// errNoSQLRecord is returned when a query expected to return a value returns no rows
+var errNoSQLRecord = errors.New("The query returned no rows")
+
+// sqlRecord is a row in the result of a query with its columns in order
+type sqlRecord []string
+
+// parseSQLRecords parses the output of sqlcmd run with sqlcmdOutputArgs into
+// records of the specified number of columns; a separator in the last column
+// is kept as a part of its value
+func parseSQLRecords(output string, columns int) []sqlRecord {
+	var records []sqlRecord
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || isRowCountMessage(line) {
+			continue
+		}
+		records = append(records, strings.SplitN(line, sqlcmdColumnSeparator, columns))
+	}
+	return records
+}
+
+// getSQLValue returns the value of the first column in the first row of the output of sqlcmd
+func getSQLValue(output string) (string, error) {
+	records := parseSQLRecords(output, 1)
+	if len(records) == 0 {
+		return "", errNoSQLRecord
+	}
+	return strings.TrimSpace(records[0][0]), nil
+}
+
+// isRowCountMessage returns if the line is a message such as "(1 rows affected)"
+// which sqlcmd writes unless NOCOUNT is set
+func isRowCountMessage(line string) bool {
+	return strings.HasPrefix(line, "(") && (strings.HasSuffix(line, " rows affected)") || strings.HasSuffix(line, " row affected)"))
+}
+
+// getSQLError adds the messages sqlcmd wrote about a failed query to its error
+func getSQLError(err error, output string) error {
+	if err == nil {
+		return nil
+	}
+	messages := strings.TrimSpace(output)
+	var commandError *CommandError
+	if errors.As(err, &commandError) && strings.TrimSpace(commandError.Stderr) != "" {
+		messages = strings.TrimSpace(commandError.Stderr)
+	}
+	if messages == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, messages)
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubRunner returns the same output and error for every command it runs
+type stubRunner struct {
+	output   string
+	err      error
+	commands []*Command
+}
+
+func (r *stubRunner) Run(ctx context.Context, command *Command) (string, error) {
+	r.commands = append(r.commands, command)
+	return r.output, r.err
+}
+
+func TestParseSQLRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		columns  int
+		expected []sqlRecord
+	}{
+		{"single value", "42\n", 1, []sqlRecord{{"42"}}},
+		{"no rows", "", 1, nil},
+		{"rows affected", "Data\n\n(1 rows affected)\n", 1, []sqlRecord{{"Data"}}},
+		{"carriage returns", "IN_PROGRESS\r\n", 1, []sqlRecord{{"IN_PROGRESS"}}},
+		{"multiple rows and columns", "1|SUCCESS\n2|ERROR\n", 2, []sqlRecord{{"1", "SUCCESS"}, {"2", "ERROR"}}},
+		{"separator in last column", "3|Aborted|see log\n", 2, []sqlRecord{{"3", "Aborted|see log"}}},
+	}
+
+	for _, test := range tests {
+		actual := parseSQLRecords(test.output, test.columns)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v but got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestStartBackupParsesTaskID(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		expected string
+		kind     ErrorKind
+	}{
+		{"task created", "7\n", nil, "7", ErrorKindUnknown},
+		{"no task", "", nil, "", ErrorKindTask},
+		{"SQL error", "", &CommandError{Err: errors.New("exit status 1"), Stderr: "Msg 50000, Level 16, State 1, Server rds, Line 1\nDatabase does not exist"}, "", ErrorKindTask},
+	}
+
+	for _, test := range tests {
+		runner := &stubRunner{output: test.output, err: test.err}
+		UseCommandRunner(runner)
+		taskID, err := (&NativeClient{}).StartBackup(context.Background(), &BackupParameters{DatabaseParameters: DatabaseParameters{DatabaseName: "db"}})
+		if taskID != test.expected {
+			t.Errorf("%s: expected task %q but got %q", test.name, test.expected, taskID)
+		}
+		if test.kind == ErrorKindUnknown && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.kind != ErrorKindUnknown && GetErrorKind(err) != test.kind {
+			t.Errorf("%s: expected %s error but got %v", test.name, test.kind, err)
+		}
+		if test.err != nil && !strings.Contains(err.Error(), "Database does not exist") {
+			t.Errorf("%s: expected messages of sqlcmd in %v", test.name, err)
+		}
+		if args := strings.Join(runner.commands[0].Args, " "); !strings.Contains(args, "-b -r 1 -h -1 -W -s |") {
+			t.Errorf("%s: expected output options in %s", test.name, args)
+		}
+	}
+	UseCommandRunner(nil)
+}
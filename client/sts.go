@@ -0,0 +1,233 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssumeRoleParameters contains the role to be assumed for AWS operations
+type AssumeRoleParameters struct {
+	RoleArn    string
+	ExternalID string
+	MfaSerial  string
+}
+
+// AwsCredentials contains temporary credentials issued by AWS STS
+type AwsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"SessionToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+const roleSessionName = "rds-backup"
+
+// credentials expiring within this period are renewed before use
+const credentialsExpiryMargin = 5 * time.Minute
+
+// awsCredentials are used by all AWS CLI commands once a role is assumed
+var awsCredentials *AwsCredentials
+
+// assumedRole is the role of awsCredentials, which is assumed again once
+// the credentials expire
+var assumedRole *AssumeRoleParameters
+
+// credentialsMutex guards awsCredentials and assumedRole, which are read by
+// AWS commands of concurrent backups; it is not held in assuming a role so
+// that commands are not blocked behind requests to STS (or MFA prompts)
+var credentialsMutex sync.Mutex
+
+// AssumeRole obtains temporary credentials of the specified role (or reuses
+// cached credentials which have not expired) and uses them in all subsequent
+// AWS operations; the role is assumed again once the credentials expire
+func AssumeRole(ctx context.Context, params *AssumeRoleParameters) error {
+	credentials, err := getRoleCredentials(ctx, params)
+	if err != nil {
+		return err
+	}
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	awsCredentials = credentials
+	assumedRole = params
+	return nil
+}
+
+// getAssumedCredentials returns the credentials of the assumed role, which
+// are renewed if they expire soon, or nil if no role is assumed
+func getAssumedCredentials(ctx context.Context) (*AwsCredentials, error) {
+	credentialsMutex.Lock()
+	role, credentials := assumedRole, awsCredentials
+	credentialsMutex.Unlock()
+	if role == nil {
+		return nil, nil
+	}
+	if credentials.isValid() {
+		return credentials, nil
+	}
+
+	logger.Info("Credentials of the assumed role are renewed", "role", role.RoleArn)
+	renewed, err := getRoleCredentials(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	if assumedRole == role {
+		awsCredentials = renewed
+	}
+	return renewed, nil
+}
+
+func getRoleCredentials(ctx context.Context, params *AssumeRoleParameters) (*AwsCredentials, error) {
+	cachePath := getCredentialsCachePath(params)
+	credentials, err := readCachedCredentials(cachePath)
+	if err == nil && credentials.isValid() {
+		return credentials, nil
+	}
+	credentials, err = requestCredentials(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if errCache := writeCachedCredentials(cachePath, credentials); errCache != nil {
+		logger.Warn("Unable to cache credentials", "role", params.RoleArn, "error", errCache)
+	}
+	return credentials, nil
+}
+
+func requestCredentials(ctx context.Context, params *AssumeRoleParameters) (*AwsCredentials, error) {
+	args := []string{
+		"sts",
+		"assume-role",
+		"--role-arn",
+		params.RoleArn,
+		"--role-session-name",
+		roleSessionName,
+		"--output",
+		"json",
+	}
+	if params.ExternalID != "" {
+		args = append(args, "--external-id", params.ExternalID)
+	}
+	if params.MfaSerial != "" {
+		tokenCode, errToken := readMfaTokenCode(params.MfaSerial)
+		if errToken != nil {
+			return nil, errToken
+		}
+		args = append(args, "--serial-number", params.MfaSerial, "--token-code", tokenCode)
+	}
+
+	// the role is assumed with the configured credentials rather than the
+	// ones of the role
+	output, err := runCommand(ctx, &Command{Name: "aws", Args: args})
+	if err != nil {
+		return nil, NewError(ErrorKindAuth, fmt.Errorf("Unable to assume role %s: %s", params.RoleArn, err.Error()))
+	}
+
+	response := struct {
+		Credentials AwsCredentials `json:"Credentials"`
+	}{}
+	if errJSON := json.Unmarshal([]byte(output), &response); errJSON != nil {
+		return nil, errJSON
+	}
+	if response.Credentials.AccessKeyID == "" {
+		return nil, NewError(ErrorKindAuth, fmt.Errorf("No credentials returned in assuming role %s", params.RoleArn))
+	}
+	return &response.Credentials, nil
+}
+
+// getAwsCredentials returns the credentials of the assumed role, or the ones
+// AWS CLI resolves from its configuration (which requires AWS CLI v2)
+func getAwsCredentials(ctx context.Context) (*AwsCredentials, error) {
+	assumed, errAssumed := getAssumedCredentials(ctx)
+	if errAssumed != nil {
+		return nil, errAssumed
+	}
+	if assumed != nil {
+		return assumed, nil
+	}
+	output, err := executeCommand(ctx, []string{"configure", "export-credentials", "--format", "process"})
+	if err != nil {
+		return nil, NewError(ErrorKindAuth, fmt.Errorf("Unable to export AWS credentials: %w", err))
+	}
+	credentials := &AwsCredentials{}
+	if errJSON := json.Unmarshal([]byte(output), credentials); errJSON != nil {
+		return nil, errJSON
+	}
+	if credentials.AccessKeyID == "" {
+		return nil, NewError(ErrorKindAuth, errors.New("No AWS credentials are configured"))
+	}
+	return credentials, nil
+}
+
+func readMfaTokenCode(mfaSerial string) (string, error) {
+	fmt.Printf("MFA code of %s: ", mfaSerial)
+	tokenCode, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	tokenCode = strings.TrimSpace(tokenCode)
+	if tokenCode == "" {
+		return "", NewError(ErrorKindAuth, errors.New("MFA code must be specified"))
+	}
+	return tokenCode, nil
+}
+
+func (c *AwsCredentials) isValid() bool {
+	return c != nil && c.AccessKeyID != "" && time.Now().Add(credentialsExpiryMargin).Before(c.Expiration)
+}
+
+func (c *AwsCredentials) environment() []string {
+	return []string{
+		fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", c.AccessKeyID),
+		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", c.SecretAccessKey),
+		fmt.Sprintf("AWS_SESSION_TOKEN=%s", c.SessionToken),
+	}
+}
+
+func getCredentialsCachePath(params *AssumeRoleParameters) string {
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(strings.Join([]string{params.RoleArn, params.ExternalID, params.MfaSerial}, "|")))
+	return filepath.Join(cacheDirectory, "rds-backup-credentials", fmt.Sprintf("%s.json", hex.EncodeToString(hash[:])))
+}
+
+func readCachedCredentials(path string) (*AwsCredentials, error) {
+	if path == "" {
+		return nil, errors.New("Credentials cache is not available")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	credentials := &AwsCredentials{}
+	if errJSON := json.Unmarshal(content, credentials); errJSON != nil {
+		return nil, errJSON
+	}
+	return credentials, nil
+}
+
+func writeCachedCredentials(path string, credentials *AwsCredentials) error {
+	if path == "" {
+		return errors.New("Credentials cache is not available")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	content, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssumedRoleIsRenewed(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	defer func() {
+		awsCredentials = nil
+		assumedRole = nil
+		UseCommandRunner(nil)
+	}()
+
+	assumed := 0
+	var environments []string
+	UseCommandRunner(funcRunner(func(command *Command) (string, error) {
+		if strings.HasPrefix(strings.Join(command.Args, " "), "sts assume-role") {
+			assumed++
+			if len(command.Environment) > 0 {
+				t.Errorf("expected role to be assumed with the configured credentials but got %v", command.Environment)
+			}
+			// the first credentials expire within credentialsExpiryMargin
+			expiration := time.Now().Add(time.Minute)
+			if assumed > 1 {
+				expiration = time.Now().Add(time.Hour)
+			}
+			return fmt.Sprintf(`{"Credentials": {"AccessKeyId": "key-%d", "SecretAccessKey": "secret", "SessionToken": "token", "Expiration": %q}}`, assumed, expiration.Format(time.RFC3339)), nil
+		}
+		environments = append(environments, strings.Join(command.Environment, " "))
+		return "", nil
+	}))
+
+	if err := AssumeRole(context.Background(), &AssumeRoleParameters{RoleArn: "arn:aws:iam::123456789012:role/backup"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := executeCommand(context.Background(), []string{"s3", "ls"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if assumed != 2 {
+		t.Errorf("expected role to be assumed 2 times but got %d", assumed)
+	}
+	for _, environment := range environments {
+		if !strings.Contains(environment, "AWS_ACCESS_KEY_ID=key-2") {
+			t.Errorf("expected renewed credentials but got %s", environment)
+		}
+	}
+}
+
+func TestAssumedRoleIsRenewedWithoutBlockingCommands(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	defer func() {
+		awsCredentials = nil
+		assumedRole = nil
+		UseCommandRunner(nil)
+	}()
+
+	renewing := make(chan struct{})
+	renewed := make(chan struct{})
+	UseCommandRunner(funcRunner(func(command *Command) (string, error) {
+		if strings.HasPrefix(strings.Join(command.Args, " "), "sts assume-role") {
+			close(renewing)
+			<-renewed
+			return fmt.Sprintf(`{"Credentials": {"AccessKeyId": "key", "SecretAccessKey": "secret", "SessionToken": "token", "Expiration": %q}}`, time.Now().Add(time.Hour).Format(time.RFC3339)), nil
+		}
+		return "", nil
+	}))
+	// the credentials of the role have expired
+	assumedRole = &AssumeRoleParameters{RoleArn: "arn:aws:iam::123456789012:role/backup"}
+	awsCredentials = &AwsCredentials{AccessKeyID: "expired", Expiration: time.Now()}
+
+	errRenewal := make(chan error)
+	go func() {
+		_, err := getAssumedCredentials(context.Background())
+		errRenewal <- err
+	}()
+	<-renewing
+	locked := make(chan struct{})
+	go func() {
+		credentialsMutex.Lock()
+		defer credentialsMutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("expected credentials not to be locked while the role is assumed")
+	}
+	close(renewed)
+
+	if err := <-errRenewal; err != nil {
+		t.Fatal(err)
+	}
+	if awsCredentials.AccessKeyID != "key" {
+		t.Errorf("expected renewed credentials but got %s", awsCredentials.AccessKeyID)
+	}
+}
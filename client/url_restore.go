@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// S3Source is a bucket which SQL server 2022 (or later) restores backups
+// from directly with RESTORE FROM URL
+type S3Source struct {
+	BucketName string
+	// Endpoint is the host (and port) of an S3-compatible service; S3 of
+	// Region is used if it is empty
+	Endpoint string
+	// Region is the region of the bucket; it is looked up if it is empty
+	// and Endpoint is not specified
+	Region string
+}
+
+// sqlBatchRunner runs a batch of statements in SQL server and returns its
+// output, or an error with the messages of SQL server if the batch fails
+type sqlBatchRunner func(ctx context.Context, batch string) (string, error)
+
+var regionPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// getURL returns the URL of an object in the bucket, which is in virtual
+// hosted style on S3 and in path style on other endpoints
+func (s *S3Source) getURL(key string) string {
+	return fmt.Sprintf("%s/%s", s.getCredentialName(), key)
+}
+
+// getCredentialName returns the name of the credential of SQL server, which
+// has to be a prefix of the URLs of the objects it is used for
+func (s *S3Source) getCredentialName() string {
+	if s.Endpoint != "" {
+		return fmt.Sprintf("s3://%s/%s", s.Endpoint, s.BucketName)
+	}
+	return fmt.Sprintf("s3://%s.s3.%s.amazonaws.com", s.BucketName, s.Region)
+}
+
+// resolveRegion looks up the region of the bucket unless it is known or an
+// S3-compatible endpoint is used
+func (s *S3Source) resolveRegion(ctx context.Context) error {
+	if s.Region == "" && s.Endpoint == "" {
+		region, err := getBucketRegion(ctx, s.BucketName)
+		if err != nil {
+			return err
+		}
+		s.Region = region
+	}
+	if s.Region != "" && !regionPattern.MatchString(s.Region) {
+		return fmt.Errorf("Invalid region %s", s.Region)
+	}
+	return nil
+}
+
+// restoreFromURL creates a credential of the AWS credentials in use, restores
+// the backup from S3 and drops the credential afterwards
+func restoreFromURL(ctx context.Context, params *BaseRestoreParameters, mdfPath string, ldfPath string, run sqlBatchRunner) error {
+	source := *params.Source
+	if errRegion := source.resolveRegion(ctx); errRegion != nil {
+		return errRegion
+	}
+	credentials, errCredentials := getAwsCredentials(ctx)
+	if errCredentials != nil {
+		return errCredentials
+	}
+	if credentials.SessionToken != "" {
+		return NewError(ErrorKindAuth, errors.New("RESTORE FROM URL cannot use temporary AWS credentials (with a session token); please use credentials of an access key"))
+	}
+
+	credentialName := source.getCredentialName()
+	if len([]rune(credentialName)) > maxIdentifierLength {
+		return fmt.Errorf("Name of credential %s is longer than %d characters", credentialName, maxIdentifierLength)
+	}
+	url := source.getURL(params.Filename)
+	log := logger.With("database", params.DatabaseName, "url", url)
+
+	createStatement, errCreate := getCreateS3CredentialQuery(credentialName, credentials).render()
+	if errCreate != nil {
+		return errCreate
+	}
+	if _, err := run(ctx, createStatement); err != nil {
+		return fmt.Errorf("Unable to create credential %s: %w", credentialName, err)
+	}
+	log.Info("Created credential of S3", "credential", credentialName)
+	defer dropS3Credential(credentialName, run)
+
+	restoreStatement, errStatement := getRestoreFromURLQuery(params.DatabaseName, url, params.DataName, mdfPath, params.LogName, ldfPath, source.Region).render()
+	if errStatement != nil {
+		return errStatement
+	}
+	log.Info("Restoring from S3")
+	_, err := run(ctx, restoreStatement)
+	return err
+}
+
+// dropS3Credential drops the credential even if the restore has been cancelled
+func dropS3Credential(credentialName string, run sqlBatchRunner) {
+	cleanupCtx, cancel := getCleanupContext()
+	defer cancel()
+	statement, err := getDropS3CredentialQuery(credentialName).render()
+	if err == nil {
+		_, err = run(cleanupCtx, statement)
+	}
+	if err != nil {
+		logger.Warn("Unable to drop credential of S3", "credential", credentialName, "error", err)
+		return
+	}
+	logger.Info("Dropped credential of S3", "credential", credentialName)
+}
+
+// getCreateS3CredentialQuery returns a batch (re)creating the credential,
+// which fails on versions before SQL server 2022; the secret is passed to
+// sqlcmd via its input so that it does not appear in the arguments
+func getCreateS3CredentialQuery(credentialName string, credentials *AwsCredentials) *sqlQuery {
+	return newQuery(
+		`SET NOCOUNT ON;
+IF CAST(SERVERPROPERTY('ProductMajorVersion') AS INT) < 16
+	THROW 50000, 'RESTORE FROM URL requires SQL Server 2022 or later', 1;
+IF EXISTS (SELECT 1 FROM sys.credentials WHERE name = @credential_name)
+	EXEC (N'DROP CREDENTIAL ' + QUOTENAME(@credential_name));
+EXEC (N'CREATE CREDENTIAL ' + QUOTENAME(@credential_name) + N' WITH IDENTITY = ''S3 Access Key'', SECRET = ' + QUOTENAME(@secret, ''''));`,
+		param("credential_name", credentialName),
+		param("secret", fmt.Sprintf("%s:%s", credentials.AccessKeyID, credentials.SecretAccessKey)),
+	)
+}
+
+func getDropS3CredentialQuery(credentialName string) *sqlQuery {
+	return newQuery(
+		`IF EXISTS (SELECT 1 FROM sys.credentials WHERE name = @credential_name)
+	EXEC (N'DROP CREDENTIAL ' + QUOTENAME(@credential_name));`,
+		param("credential_name", credentialName),
+	)
+}
+
+// getRestoreFromURLQuery returns a batch restoring from S3, which is signed
+// for the region if it is specified
+func getRestoreFromURLQuery(databaseName string, url string, dataName string, mdfPath string, logName string, ldfPath string, region string) *sqlQuery {
+	text := "RESTORE DATABASE @database_name FROM URL=@url WITH FILE=1, REPLACE, STATS=5, MOVE @data_name TO @mdf_path, MOVE @log_name TO @ldf_path"
+	if region != "" {
+		text += fmt.Sprintf(`, RESTORE_OPTIONS='{"s3": {"region":"%s"}}'`, region)
+	}
+	return newQuery(
+		text,
+		param("database_name", databaseName),
+		param("url", url),
+		param("data_name", dataName),
+		param("mdf_path", mdfPath),
+		param("log_name", logName),
+		param("ldf_path", ldfPath),
+	)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// loginCreated is returned by the batch of getFixOrphanedUserQuery if it creates the login
+const loginCreated = "created"
+
+// fixOrphanedUsers maps the users of the restored database whose logins do
+// not exist in the server (as the SIDs of logins differ between servers) to
+// the logins of UserLogins, or to logins of the same names created with the
+// passwords of LoginPasswords; other orphaned users are reported only
+func fixOrphanedUsers(ctx context.Context, params *BaseRestoreParameters, run sqlBatchRunner) error {
+	log := logger.With("database", params.DatabaseName)
+	statement, errStatement := getOrphanedUsersQuery(params.DatabaseName).render()
+	if errStatement != nil {
+		return errStatement
+	}
+	output, err := run(ctx, statement)
+	if err != nil {
+		return fmt.Errorf("Unable to find orphaned users: %w", err)
+	}
+	var users []string
+	for _, record := range parseSQLRecords(output, 1) {
+		if user := strings.TrimSpace(record[0]); user != "" {
+			users = append(users, user)
+		}
+	}
+	if len(users) == 0 {
+		log.Info("No orphaned users are found")
+		return nil
+	}
+
+	var unresolved []string
+	for _, user := range users {
+		login, isRemapped := lookupUser(params.UserLogins, user)
+		password, hasPassword := lookupUser(params.LoginPasswords, user)
+		if !isRemapped && !hasPassword {
+			unresolved = append(unresolved, user)
+			continue
+		}
+		if !isRemapped {
+			login = user
+		} else {
+			password = ""
+		}
+		batch, errBatch := getFixOrphanedUserQuery(params.DatabaseName, user, login, password).render()
+		if errBatch != nil {
+			return errBatch
+		}
+		result, errFix := run(ctx, batch)
+		if errFix != nil {
+			return fmt.Errorf("Unable to fix orphaned user %s: %w", user, errFix)
+		}
+		status, _ := getSQLValue(result)
+		switch {
+		case isRemapped:
+			log.Info("Remapped orphaned user to login", "user", user, "login", login)
+		case status == loginCreated:
+			log.Info("Created login for orphaned user", "user", user, "login", login)
+		default:
+			log.Info("Mapped orphaned user to existing login", "user", user, "login", login)
+		}
+	}
+	if len(unresolved) > 0 {
+		log.Warn("Orphaned users are left without logins; specify their logins or passwords to fix them", "users", strings.Join(unresolved, ", "))
+	}
+	return nil
+}
+
+// lookupUser returns the value of the user, whose name is matched case
+// insensitively as in the default collation of SQL server (and as keys of
+// configuration files are lower-cased)
+func lookupUser(values map[string]string, user string) (string, bool) {
+	if value, ok := values[user]; ok {
+		return value, true
+	}
+	for name, value := range values {
+		if strings.EqualFold(name, user) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// getOrphanedUsersQuery returns a batch selecting the SQL users of the
+// database mapped to logins which do not exist in the server; users without
+// logins, contained users and the fixed users (such as dbo) are excluded
+func getOrphanedUsersQuery(databaseName string) *sqlQuery {
+	return newQuery(getUseDatabaseStatement(databaseName) + `SELECT dp.name
+FROM sys.database_principals AS dp
+LEFT JOIN sys.server_principals AS sp ON sp.sid = dp.sid
+WHERE sp.sid IS NULL AND dp.type = 'S' AND dp.authentication_type = 1 AND dp.principal_id > 4
+ORDER BY dp.name;`)
+}
+
+// getFixOrphanedUserQuery returns a batch mapping the user to the login,
+// which is created with the password if it is specified and the login does
+// not exist; the batch selects loginCreated if the login is created
+func getFixOrphanedUserQuery(databaseName string, user string, login string, password string) *sqlQuery {
+	text := getUseDatabaseStatement(databaseName) + fmt.Sprintf(`DECLARE @status NVARCHAR(10) = N'existing';
+IF @password <> N'' AND NOT EXISTS (SELECT 1 FROM sys.server_principals WHERE name = @login_name)
+BEGIN
+	-- QUOTENAME returns NULL for passwords longer than 128 characters, so quotes are escaped instead
+	DECLARE @create_login NVARCHAR(MAX) = N'CREATE LOGIN ' + QUOTENAME(@login_name) + N' WITH PASSWORD = N''' + REPLACE(CAST(@password AS NVARCHAR(MAX)), N'''', N'''''') + N''', CHECK_POLICY = OFF';
+	EXEC (@create_login);
+	SET @status = N'%s';
+END
+EXEC (N'ALTER USER ' + QUOTENAME(@user_name) + N' WITH LOGIN = ' + QUOTENAME(@login_name));
+SELECT @status;`, loginCreated)
+	return newQuery(
+		text,
+		param("user_name", user),
+		param("login_name", login),
+		param("password", password),
+	)
+}
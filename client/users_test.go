@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFixOrphanedUsers(t *testing.T) {
+	tests := []struct {
+		name             string
+		orphanedUsers    string
+		failingBatch     string
+		expectedError    bool
+		expectedBatches  []string
+		unexpectedBatch  string
+		expectedFixCount int
+	}{
+		{
+			name:          "remap and create logins",
+			orphanedUsers: "app\nlegacy\nreport\n",
+			expectedBatches: []string{
+				"DECLARE @user_name NVARCHAR(4000) = N'app';\nDECLARE @login_name NVARCHAR(4000) = N'app_login';\nDECLARE @password NVARCHAR(4000) = N'';",
+				"DECLARE @user_name NVARCHAR(4000) = N'report';\nDECLARE @login_name NVARCHAR(4000) = N'report';\nDECLARE @password NVARCHAR(4000) = N'Passw0rd';",
+				`N' WITH PASSWORD = N''' + REPLACE(CAST(@password AS NVARCHAR(MAX)), N'''', N'''''') + N''', CHECK_POLICY = OFF'`,
+			},
+			unexpectedBatch:  "N'legacy'",
+			expectedFixCount: 2,
+		},
+		{
+			name:             "no orphaned users",
+			expectedFixCount: 0,
+		},
+		{
+			name:          "fix fails",
+			orphanedUsers: "app\n",
+			failingBatch:  "ALTER USER",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var batches []string
+			run := func(ctx context.Context, batch string) (string, error) {
+				if strings.Contains(batch, "sys.database_principals") {
+					return test.orphanedUsers, nil
+				}
+				batches = append(batches, batch)
+				if test.failingBatch != "" && strings.Contains(batch, test.failingBatch) {
+					return "", errors.New("exit status 1")
+				}
+				return "created\n", nil
+			}
+			params := &BaseRestoreParameters{
+				DatabaseName:   "db",
+				UserLogins:     map[string]string{"App": "app_login"},
+				LoginPasswords: map[string]string{"report": "Passw0rd"},
+			}
+
+			err := fixOrphanedUsers(context.Background(), params, run)
+
+			if test.expectedError {
+				if err == nil {
+					t.Fatal("expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if len(batches) != test.expectedFixCount {
+				t.Errorf("expected %d users to be fixed but got %d", test.expectedFixCount, len(batches))
+			}
+			all := strings.Join(batches, "\n")
+			for _, expected := range test.expectedBatches {
+				if !strings.Contains(all, expected) {
+					t.Errorf("expected %s in batches:\n%s", expected, all)
+				}
+			}
+			if test.unexpectedBatch != "" && strings.Contains(all, test.unexpectedBatch) {
+				t.Errorf("expected no %s in batches:\n%s", test.unexpectedBatch, all)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	opts := cacheOptions{}
+
+	var cacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "Manages backups cached on this machine",
+		Long:  "Manages backups cached on this machine",
+	}
+
+	var cacheListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists backups in the cache",
+		Long:  "Lists backups in the cache",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			return runCacheList()
+		},
+	}
+
+	var cacheClearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Removes all backups in the cache",
+		Long:  "Removes all backups in the cache",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			return runCacheClear()
+		},
+	}
+
+	bindCacheOptions(cacheCmd.PersistentFlags(), &opts)
+
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
+
+func runCacheList() error {
+	entries, err := getBackupCache().List()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Printf(
+			"s3://%s/%s\t%s\t%d MB\t%s\n",
+			entry.BucketName,
+			entry.Filename,
+			entry.ETag,
+			entry.Size/1024/1024,
+			entry.LastUsed.Format("2006-01-02 15:04:05"),
+		)
+	}
+	return nil
+}
+
+func runCacheClear() error {
+	cache := getBackupCache()
+	err := cache.Clear()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Cache %s has been cleared.\n", cache.Directory)
+	return nil
+}
+
+func getBackupCache() *client.BackupCache {
+	return client.NewBackupCache(viper.GetString("cache-directory"), viper.GetInt64("cache-size"))
+}
+
+// downloadBackup downloads a backup to --download-directory, or to the cache if
+// it is not specified, and returns the directory containing the backup
+func downloadBackup(ctx context.Context, bucketName string, filename string) (string, error) {
+	downloadDirectory := viper.GetString("download-directory")
+	if downloadDirectory != "" {
+		return downloadDirectory, client.DownloadBackup(ctx, bucketName, filename, downloadDirectory)
+	}
+	return getBackupCache().Download(ctx, bucketName, filename)
+}
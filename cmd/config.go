@@ -0,0 +1,273 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// profileEnvironmentVariable selects a profile if --profile is not specified
+const profileEnvironmentVariable = "RDS_BACKUP_PROFILE"
+
+const profilesKey = "profiles"
+const defaultsKey = "defaults"
+const notificationsKey = "notifications"
+
+var profileName string
+
+func init() {
+	var configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Shows and validates profiles in the configuration file",
+		Long:  "Shows and validates profiles in the configuration file",
+	}
+
+	var configListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists profiles",
+		Long:  "Lists profiles",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			return runConfigList()
+		},
+	}
+
+	var configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Shows the resolved settings of the selected profile",
+		Long:  "Shows the resolved settings of the selected profile with secrets masked",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			return runConfigShow()
+		},
+	}
+
+	var configValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validates all profiles",
+		Long:  "Validates the defaults block and all profiles in the configuration file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			return runConfigValidate()
+		},
+	}
+
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	RootCmd.AddCommand(configCmd)
+}
+
+// applyProfile merges the defaults block and then the selected profile over
+// the settings at the top level of the configuration file
+func applyProfile() error {
+	name := getSelectedProfileName()
+	if name == "" {
+		return nil
+	}
+	if !viper.IsSet(getProfileKey(name)) {
+		return fmt.Errorf("Profile %s cannot be found in the configuration file", name)
+	}
+	if err := viper.MergeConfigMap(copySettings(viper.GetStringMap(defaultsKey))); err != nil {
+		return err
+	}
+	return viper.MergeConfigMap(copySettings(viper.GetStringMap(getProfileKey(name))))
+}
+
+func getSelectedProfileName() string {
+	if profileName != "" {
+		return profileName
+	}
+	return os.Getenv(profileEnvironmentVariable)
+}
+
+func getProfileKey(name string) string {
+	return fmt.Sprintf("%s.%s", profilesKey, name)
+}
+
+func getProfileNames() []string {
+	names := []string{}
+	for name := range viper.GetStringMap(profilesKey) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func runConfigList() error {
+	selected := getSelectedProfileName()
+	for _, name := range getProfileNames() {
+		if name == selected {
+			fmt.Printf("* %s\n", name)
+			continue
+		}
+		fmt.Printf("  %s\n", name)
+	}
+	return nil
+}
+
+func runConfigShow() error {
+	if name := getSelectedProfileName(); name != "" {
+		fmt.Printf("profile: %s\n", name)
+	}
+	for _, line := range getShownSettings() {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// getShownSettings returns the resolved settings outside profiles as lines
+// of keys and values with secrets masked; settings of notifiers are keyed
+// by their indexes
+func getShownSettings() []string {
+	keys := []string{}
+	for _, key := range viper.AllKeys() {
+		if isProfileSetting(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := []string{}
+	for _, key := range keys {
+		if key == notificationsKey {
+			lines = append(lines, getShownNotifierSettings()...)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", key, getMaskedValue(key, viper.GetString(key))))
+	}
+	return lines
+}
+
+func getShownNotifierSettings() []string {
+	var notifiers []map[string]interface{}
+	if err := viper.UnmarshalKey(notificationsKey, &notifiers); err != nil {
+		return []string{fmt.Sprintf("%s: %s", notificationsKey, err.Error())}
+	}
+	lines := []string{}
+	for i, notifier := range notifiers {
+		names := []string{}
+		for name := range notifier {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := fmt.Sprintf("%s.%d.%s", notificationsKey, i, strings.ToLower(name))
+			lines = append(lines, fmt.Sprintf("%s: %s", key, getMaskedValue(key, fmt.Sprint(notifier[name]))))
+		}
+	}
+	return lines
+}
+
+func runConfigValidate() error {
+	knownKeys := getKnownKeys()
+	messages := strings.Builder{}
+
+	for _, key := range getUnknownKeys(viper.GetStringMap(defaultsKey), knownKeys) {
+		messages.WriteString(fmt.Sprintf("%s: unknown setting %s\n", defaultsKey, key))
+	}
+	for _, name := range getProfileNames() {
+		unknownKeys := getUnknownKeys(viper.GetStringMap(getProfileKey(name)), knownKeys)
+		for _, key := range unknownKeys {
+			messages.WriteString(fmt.Sprintf("%s: unknown setting %s\n", getProfileKey(name), key))
+		}
+		if len(unknownKeys) == 0 {
+			fmt.Printf("%s: OK\n", name)
+		}
+	}
+
+	if messages.String() != "" {
+		return client.NewError(client.ErrorKindValidation, errors.New(messages.String()))
+	}
+	return nil
+}
+
+// getKnownKeys returns the names of flags of all commands, which are the
+// settings can be specified in a profile
+func getKnownKeys() map[string]bool {
+	keys := map[string]bool{}
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		addFlag := func(f *pflag.Flag) {
+			keys[f.Name] = true
+		}
+		cmd.Flags().VisitAll(addFlag)
+		cmd.PersistentFlags().VisitAll(addFlag)
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(RootCmd)
+	keys[notificationsKey] = true
+	return keys
+}
+
+func getUnknownKeys(settings map[string]interface{}, knownKeys map[string]bool) []string {
+	keys := []string{}
+	for key := range settings {
+		if !knownKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isProfileSetting(key string) bool {
+	return strings.HasPrefix(key, profilesKey+".") || strings.HasPrefix(key, defaultsKey+".") || strings.HasPrefix(key, schedulesKey+".")
+}
+
+// isSecretKey returns if the setting is a secret; URLs of notifiers (such as
+// Slack webhooks) and SMTP credentials are secrets as well
+func isSecretKey(key string) bool {
+	if strings.Contains(key, "password") || strings.Contains(key, "secret") || strings.Contains(key, "token") {
+		return true
+	}
+	if !strings.HasPrefix(key, notificationsKey+".") {
+		return false
+	}
+	name := key[strings.LastIndex(key, ".")+1:]
+	return name == "url" || name == "smtp-username"
+}
+
+func getMaskedValue(key string, value string) string {
+	if value == "" || !isSecretKey(key) {
+		return value
+	}
+	return "********"
+}
+
+func copySettings(settings map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for key, value := range settings {
+		copied[key] = value
+	}
+	return copied
+}
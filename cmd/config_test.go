@@ -0,0 +1,122 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const testConfiguration = `
+bucket: top-level-bucket
+region: us-east-1
+defaults:
+  region: ap-southeast-1
+  restore-password: env://RESTORE_PASSWORD
+profiles:
+  staging:
+    bucket: staging-bucket
+  production:
+    bucket: production-bucket
+    region: eu-west-1
+notifications:
+  - type: slack
+    url: https://hooks.slack.com/services/T000/B000/secret
+  - type: email
+    smtp-server: smtp.example.com:587
+    smtp-username: rds-backup
+    smtp-password: env://SMTP_PASSWORD
+    to: [dba@example.com]
+`
+
+func TestApplyProfile(t *testing.T) {
+	tests := []struct {
+		profile        string
+		expectedBucket string
+		expectedRegion string
+		expectedError  bool
+	}{
+		{"", "top-level-bucket", "us-east-1", false},
+		{"staging", "staging-bucket", "ap-southeast-1", false},
+		{"production", "production-bucket", "eu-west-1", false},
+		{"unknown", "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			readTestConfiguration(t)
+			profileName = test.profile
+
+			err := applyProfile()
+
+			if test.expectedError {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if bucket := viper.GetString("bucket"); bucket != test.expectedBucket {
+				t.Errorf("expected bucket %s but got %s", test.expectedBucket, bucket)
+			}
+			if region := viper.GetString("region"); region != test.expectedRegion {
+				t.Errorf("expected region %s but got %s", test.expectedRegion, region)
+			}
+		})
+	}
+}
+
+func TestGetShownSettings(t *testing.T) {
+	readTestConfiguration(t)
+	profileName = "staging"
+	if err := applyProfile(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"bucket: staging-bucket",
+		"notifications.0.type: slack",
+		"notifications.0.url: ********",
+		"notifications.1.smtp-password: ********",
+		"notifications.1.smtp-server: smtp.example.com:587",
+		"notifications.1.smtp-username: ********",
+		"notifications.1.to: [dba@example.com]",
+		"notifications.1.type: email",
+		"region: ap-southeast-1",
+		"restore-password: ********",
+	}
+	if actual := getShownSettings(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+// readTestConfiguration resets the configuration to testConfiguration
+func readTestConfiguration(t *testing.T) {
+	t.Setenv(profileEnvironmentVariable, "")
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(testConfiguration)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		profileName = ""
+		viper.Reset()
+	})
+}
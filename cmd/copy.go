@@ -0,0 +1,122 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	opts := copyOptions{}
+
+	var copyCmd = &cobra.Command{
+		Use:   "copy",
+		Short: "Copies a backup on AWS S3 to another bucket",
+		Long:  "Copies a backup on AWS S3 to another bucket (possibly in another region or account) without downloading it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
+			}
+			dumpParameters(cmd)
+			if errOpt := validateCopyOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
+			}
+			ctx, cancel := getOperationContext(cmd.Context())
+			defer cancel()
+			return runCopy(ctx)
+		},
+	}
+
+	flags := copyCmd.Flags()
+	bindCopyOptions(flags, &opts)
+
+	RootCmd.AddCommand(copyCmd)
+}
+
+func runCopy(ctx context.Context) error {
+	if !client.IsAwsCliInstalled(ctx) {
+		return newEnvironmentError("AWS CLI is required")
+	}
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+	if !client.IsAwsCredentialsConfigured(ctx) {
+		return newEnvironmentError("AWS CLI credentials are not configured yet. Please try 'aws configure'")
+	}
+
+	return replicateBackup(ctx, viper.GetString("bucket"), viper.GetString("filename"), viper.GetString("source-region"), viper.GetString("destination"))
+}
+
+// replicateBackup copies a backup to the specified S3 URI and prints the verified copy
+func replicateBackup(ctx context.Context, bucketName string, filename string, sourceRegion string, destination string) error {
+	destinationBucketName, prefix, err := client.ParseS3URI(destination)
+	if err != nil {
+		return err
+	}
+
+	params := &client.CopyParameters{
+		SourceBucketName:         bucketName,
+		SourceFilename:           filename,
+		SourceRegion:             sourceRegion,
+		DestinationBucketName:    destinationBucketName,
+		DestinationFilename:      client.GetDestinationFilename(prefix, filename),
+		DestinationRegion:        viper.GetString("destination-region"),
+		KmsKeyID:                 viper.GetString("kms-key-id"),
+		IsBucketOwnerFullControl: viper.GetBool("bucket-owner-full-control"),
+	}
+
+	result, errCopy := client.CopyBackup(ctx, params)
+	if errCopy != nil {
+		return errCopy
+	}
+
+	fmt.Printf("Source: %s\n", result.SourceURI)
+	fmt.Printf("Destination: %s\n", result.DestinationURI)
+	fmt.Printf("Size: %d bytes\n", result.Size)
+	fmt.Printf("Checksum (SHA256): %s (verified)\n", result.Checksum)
+
+	return nil
+}
+
+func validateCopyOptions() error {
+	messages := strings.Builder{}
+
+	if viper.GetString("bucket") == "" {
+		messages.WriteString("--bucket AWS S3 Bucket must be specified\n")
+	}
+	if viper.GetString("filename") == "" {
+		messages.WriteString("--filename Filename must be specified\n")
+	}
+	validateAwsOptions(&messages)
+	if viper.GetString("destination") == "" {
+		messages.WriteString("--destination S3 URI of the destination must be specified\n")
+	} else if _, _, errURI := client.ParseS3URI(viper.GetString("destination")); errURI != nil {
+		messages.WriteString(fmt.Sprintf("--destination %s\n", errURI.Error()))
+	}
+
+	if messages.String() != "" {
+		return errors.New(messages.String())
+	}
+
+	return nil
+}
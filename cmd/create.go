@@ -15,8 +15,10 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
@@ -34,22 +36,20 @@ func init() {
 		Use:   "create",
 		Short: "Creates a new backup",
 		Long:  "Creates a new backup",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			bindConfiguration(cmd)
-			viper.Set("verbose", opts.verbose)
-			if viper.GetBool("verbose") {
-				dumpParameters(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
 			}
-			errOpt := validateCreateOptions()
-			if errOpt != nil {
-				fmt.Println(errOpt.Error())
-				cmd.HelpFunc()(cmd, args)
-				return
-			}
-			err := runCreate()
-			if err != nil {
-				fmt.Println(err.Error())
+			dumpParameters(cmd)
+			if errOpt := validateCreateOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
 			}
+			ctx, cancel := getOperationContext(cmd.Context())
+			defer cancel()
+			err := runCreate(ctx)
+			writeMetricsTextfile()
+			return err
 		},
 	}
 
@@ -59,16 +59,34 @@ func init() {
 	RootCmd.AddCommand(createCmd)
 }
 
-func runCreate() error {
-	if viper.GetBool("download") || viper.GetBool("restore") {
-		if !client.IsAwsCliInstalled() {
-			return errors.New("AWS CLI is required")
+func runCreate(ctx context.Context) error {
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+	if errPassword := resolvePasswords(ctx); errPassword != nil {
+		return errPassword
+	}
+	if errEncryption := configureEncryption(); errEncryption != nil {
+		return errEncryption
+	}
+	if errNotifications := configureNotifications(ctx); errNotifications != nil {
+		return errNotifications
+	}
+
+	isReplicate := viper.GetString("replicate-to") != ""
+	if viper.GetBool("download") || viper.GetBool("restore") || isReplicate {
+		if !client.IsAwsCliInstalled(ctx) {
+			return newEnvironmentError("AWS CLI is required")
 		}
-		if !client.IsAwsCredentialsConfigured() {
-			return errors.New("AWS CLI credentials are not configured yet. Please try 'aws configure'")
+		if !client.IsAwsCredentialsConfigured(ctx) {
+			return newEnvironmentError("AWS CLI credentials are not configured yet. Please try 'aws configure'")
 		}
 	}
 
+	if viper.GetString("filename") == "" {
+		viper.Set("filename", client.GetBackupFilename(viper.GetString("database"), client.BackupTypeFull, time.Now()))
+	}
+
 	params := &client.BackupParameters{
 		DatabaseParameters: client.DatabaseParameters{
 			Server:       viper.GetString("server"),
@@ -80,15 +98,17 @@ func runCreate() error {
 		Filename:   viper.GetString("filename"),
 	}
 
-	c := client.GetClient()
-	if c == nil {
-		return errors.New("Unable to find a sqlcmd client")
+	log := logger.With("database", params.DatabaseName, "bucket", params.BucketName, "filename", params.Filename)
+
+	c, errClient := getSQLClient(ctx)
+	if errClient != nil {
+		return errClient
 	}
 
 	dataLogicalName := ""
 	logLogicalName := ""
 	if viper.GetBool("restore") {
-		dataName, logName, errLogicalNames := c.GetLogicalNames(&params.DatabaseParameters)
+		dataName, logName, errLogicalNames := c.GetLogicalNames(ctx, &params.DatabaseParameters)
 		if errLogicalNames != nil {
 			return errLogicalNames
 		}
@@ -96,46 +116,63 @@ func runCreate() error {
 		logLogicalName = logName
 	}
 
-	taskID, err := c.StartBackup(params)
+	taskID, err := startBackup(ctx, c, params)
 	if err != nil {
 		return err
 	}
-	if taskID == "" {
-		return errors.New("Unable to create a backup task")
-	}
-	fmt.Printf("Backup task [%s] started...", taskID)
+	log = log.With("task_id", taskID)
+	log.Info("Backup task started")
 
-	if viper.GetBool("download") || viper.GetBool("wait") || viper.GetBool("restore") {
-		errBackup := isBackupCompleted(c, params, taskID)
+	if viper.GetBool("download") || viper.GetBool("wait") || viper.GetBool("restore") || isReplicate {
+		errBackup := isBackupCompleted(ctx, c, params, taskID, log)
 		if errBackup != nil {
 			return errBackup
 		}
-		fmt.Printf("Backup completed (on AWS S3 at s3://%s/%s).\n", params.BucketName, params.Filename)
+		log.Info("Backup completed")
+	}
+
+	if isReplicate {
+		errReplicate := replicateBackup(ctx, params.BucketName, params.Filename, "", viper.GetString("replicate-to"))
+		if errReplicate != nil {
+			return errReplicate
+		}
 	}
 
+	downloadDirectory := viper.GetString("download-directory")
 	if viper.GetBool("download") || viper.GetBool("restore") {
-		errDownload := client.DownloadBackup(params.BucketName, params.Filename, viper.GetString("download-directory"))
+		directory, errDownload := downloadBackup(ctx, params.BucketName, params.Filename)
 		if errDownload != nil {
 			return errDownload
 		}
+		downloadDirectory = directory
+	}
+
+	maskProfile, errProfile := getMaskProfile()
+	if errProfile != nil {
+		return errProfile
+	}
+	loginPasswords, errPasswords := getLoginPasswords(ctx)
+	if errPasswords != nil {
+		return errPasswords
 	}
 
 	basicRestoreParameters := client.BaseRestoreParameters{
-		Filename:          viper.GetString("filename"),
-		DatabaseName:      viper.GetString("database"),
-		DataName:          dataLogicalName,
-		LogName:           logLogicalName,
-		DownloadDirectory: viper.GetString("download-directory"),
+		BucketName:           viper.GetString("bucket"),
+		Filename:             viper.GetString("filename"),
+		DatabaseName:         viper.GetString("database"),
+		DataName:             dataLogicalName,
+		LogName:              logLogicalName,
+		DownloadDirectory:    downloadDirectory,
+		MaskProfile:          maskProfile,
+		PostRestoreDirectory: viper.GetString("post-restore"),
+		UserLogins:           viper.GetStringMapString("user-login"),
+		LoginPasswords:       loginPasswords,
 	}
 
 	if viper.GetBool("restore") {
 		if viper.GetBool("native") {
-			nativeParameters := &client.NativeRestoreParameters{
-				BaseRestoreParameters: basicRestoreParameters,
-				CustomDataPath:        viper.GetString("restore-data-directory"),
-				ServerPath:            viper.GetString("restore-server-directory"),
-			}
-			errNative := client.RestoreNative(nativeParameters)
+			nativeParameters := getNativeRestoreParameters(basicRestoreParameters)
+			errNative := client.RestoreNative(ctx, nativeParameters)
 			if errNative != nil {
 				return errNative
 			}
@@ -147,7 +184,7 @@ func runCreate() error {
 			Password:              viper.GetString("restore-password"),
 			Port:                  viper.GetInt("port"),
 		}
-		errRestore := client.Restore(restoreParameters)
+		errRestore := client.Restore(ctx, restoreParameters)
 		if errRestore != nil {
 			return errRestore
 		}
@@ -156,41 +193,89 @@ func runCreate() error {
 	return nil
 }
 
-func isBackupCompleted(c client.SQLClient, params *client.BackupParameters, taskID string) error {
-	done := false
-	var err error
-
-	for !done {
-		fmt.Printf(".")
-		time.Sleep(5 * time.Second)
-		done, err = isBackupDone(c, &params.DatabaseParameters, taskID)
-		if err != nil {
-			return err
-		}
+// startBackup starts a backup task, and records and notifies a failed backup
+// if the task cannot be started
+func startBackup(ctx context.Context, c client.SQLClient, params *client.BackupParameters) (string, error) {
+	taskID, err := c.StartBackup(ctx, params)
+	if err == nil && taskID == "" {
+		err = client.NewError(client.ErrorKindTask, errors.New("Unable to create a backup task"))
 	}
-
-	fmt.Println("")
 	if err != nil {
+		client.RecordBackupFailure(params.DatabaseName, client.BackupLifecycleNotStarted)
+		client.Notify(&client.Notification{
+			Event:        client.NotificationEventFailure,
+			Operation:    "backup",
+			DatabaseName: params.DatabaseName,
+			S3URI:        fmt.Sprintf("s3://%s/%s", params.BucketName, params.Filename),
+			Error:        err.Error(),
+		})
+		return "", err
+	}
+	return taskID, nil
+}
+
+// isBackupCompleted polls the status of a backup task every --poll-interval
+// until it completes (or the context is done) and records the outcome in metrics
+func isBackupCompleted(ctx context.Context, c client.SQLClient, params *client.BackupParameters, taskID string, log *slog.Logger) error {
+	startTime := time.Now()
+	notification := client.Notification{
+		Operation:    "backup",
+		DatabaseName: params.DatabaseName,
+		TaskID:       taskID,
+		S3URI:        fmt.Sprintf("s3://%s/%s", params.BucketName, params.Filename),
+	}
+	checkLongRunning := client.NewLongRunningCheck(notification)
+	fail := func(status string, err error) error {
+		client.RecordBackupFailure(params.DatabaseName, status)
+		notification.Event = client.NotificationEventFailure
+		notification.Error = err.Error()
+		notification.Duration = time.Since(startTime)
+		client.Notify(&notification)
 		return err
 	}
 
+	lastStatus := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return fail(lastStatus, ctx.Err())
+		case <-time.After(viper.GetDuration("poll-interval")):
+		}
+		status, done, err := isBackupDone(ctx, c, &params.DatabaseParameters, taskID)
+		log.Debug("Polled status of backup task", "status", status)
+		if err != nil {
+			return fail(status, err)
+		}
+		if status != lastStatus {
+			log.Info("Status of backup task changed", "status", status, "elapsed", time.Since(startTime).Round(time.Second).String())
+			lastStatus = status
+		}
+		if done {
+			break
+		}
+		checkLongRunning(time.Since(startTime))
+	}
+
+	client.RecordBackupCompleted(ctx, params, time.Since(startTime))
+	notification.Event = client.NotificationEventSuccess
+	notification.Duration = time.Since(startTime)
+	client.Notify(&notification)
 	return nil
 }
 
-func isBackupDone(c client.SQLClient, params *client.DatabaseParameters, taskID string) (bool, error) {
-	status, err := c.GetStatus(params, taskID)
+func isBackupDone(ctx context.Context, c client.SQLClient, params *client.DatabaseParameters, taskID string) (string, bool, error) {
+	status, err := c.GetStatus(ctx, params, taskID)
 	if err != nil {
-		return false, err
+		return "", false, client.NewError(client.ErrorKindTask, err)
 	}
-	if status == "ERROR" {
-		errorMessage, errErr := c.GetTaskMessage(params)
+	if status == "ERROR" || status == "CANCELLED" {
+		errorMessage, errErr := c.GetTaskMessage(ctx, params)
 		if errErr != nil {
-			return false, errErr
+			return status, false, client.NewError(client.ErrorKindTask, errErr)
 		}
-		fmt.Println(errorMessage)
-		return false, errors.New(errorMessage)
+		return status, false, client.NewError(client.ErrorKindTask, errors.New(errorMessage))
 	}
-	return status == "SUCCESS", nil
+	return status, status == "SUCCESS", nil
 }
 
 func validateCreateOptions() error {
@@ -211,8 +296,17 @@ func validateCreateOptions() error {
 	if viper.GetString("bucket") == "" {
 		messages.WriteString("--bucket AWS S3 Bucket must be specified\n")
 	}
-	if viper.GetString("filename") == "" {
-		messages.WriteString("--filename Filename must be specified\n")
+	if viper.GetString("filename") == client.LatestFilename {
+		messages.WriteString(fmt.Sprintf("--filename '%s' cannot be used in creating a backup\n", client.LatestFilename))
+	}
+
+	validateAwsOptions(&messages)
+	validatePollOptions(&messages)
+	validateSQLClientOptions(&messages)
+	if viper.GetString("replicate-to") != "" {
+		if _, _, errURI := client.ParseS3URI(viper.GetString("replicate-to")); errURI != nil {
+			messages.WriteString(fmt.Sprintf("--replicate-to %s\n", errURI.Error()))
+		}
 	}
 
 	if viper.GetBool("download") || viper.GetBool("restore") {
@@ -226,15 +320,7 @@ func validateCreateOptions() error {
 
 	if viper.GetBool("restore") {
 		if viper.GetBool("native") {
-			if viper.GetInt("port") != client.DefaultServerPort {
-				messages.WriteString("--port Port cannot be used in restoring to local native SQL server\n")
-			}
-			restoreServerDirectory := viper.GetString("restore-server-directory")
-			if restoreServerDirectory != "" {
-				if _, errServerDirectory := os.Stat(restoreServerDirectory); os.IsNotExist(errServerDirectory) {
-					messages.WriteString("the specified restore-server-directory does not exist\n")
-				}
-			}
+			validateNativeRestoreOptions(&messages)
 		} else {
 			if viper.GetString("container") == "" {
 				messages.WriteString("--container Container name must be specified\n")
@@ -248,6 +334,22 @@ func validateCreateOptions() error {
 			if viper.GetString("restore-data-directory") != "" {
 				messages.WriteString("--restore-data-directory cannot be used in Docker container restore\n")
 			}
+			if viper.GetString("restore-server") != "" {
+				messages.WriteString("--restore-server cannot be used in Docker container restore\n")
+			}
+			if viper.GetString("restore-share") != "" {
+				messages.WriteString("--restore-share cannot be used in Docker container restore\n")
+			}
+		}
+		validateMaskOptions(&messages)
+		validatePostRestoreOptions(&messages)
+		validateOrphanedUserOptions(&messages)
+	} else {
+		if viper.GetString("mask-profile") != "" {
+			messages.WriteString("--mask-profile cannot be used without --restore\n")
+		}
+		if viper.GetString("post-restore") != "" {
+			messages.WriteString("--post-restore cannot be used without --restore\n")
 		}
 	}
 
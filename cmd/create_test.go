@@ -0,0 +1,208 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/alexhokl/rds-backup/client/clienttest"
+)
+
+func TestRunCreate(t *testing.T) {
+	tests := []struct {
+		name             string
+		settings         map[string]interface{}
+		lifecycle        []string
+		taskMessage      string
+		startError       error
+		commands         map[string]error
+		expectedKind     *client.ErrorKind
+		expectedCommands []string
+		expectedFile     bool
+	}{
+		{
+			name:      "start only",
+			lifecycle: []string{clienttest.StatusCreated},
+		},
+		{
+			name:      "wait until success",
+			settings:  map[string]interface{}{"wait": true},
+			lifecycle: []string{clienttest.StatusCreated, clienttest.StatusInProgress, clienttest.StatusSuccess},
+		},
+		{
+			name:         "task fails",
+			settings:     map[string]interface{}{"wait": true},
+			lifecycle:    []string{clienttest.StatusInProgress, clienttest.StatusError},
+			taskMessage:  "Aborted the task because of a task failure",
+			expectedKind: errorKind(client.ErrorKindTask),
+		},
+		{
+			name:         "task is cancelled",
+			settings:     map[string]interface{}{"wait": true},
+			lifecycle:    []string{clienttest.StatusCreated, clienttest.StatusCancelled},
+			expectedKind: errorKind(client.ErrorKindTask),
+		},
+		{
+			name:         "task cannot be started",
+			startError:   client.NewError(client.ErrorKindTask, errors.New("Msg 50000, Level 16")),
+			expectedKind: errorKind(client.ErrorKindTask),
+		},
+		{
+			name:             "download",
+			settings:         map[string]interface{}{"download": true},
+			lifecycle:        []string{clienttest.StatusInProgress, clienttest.StatusSuccess},
+			expectedCommands: []string{"aws help", "aws s3 ls", "aws s3 cp s3://bucket/db.bak"},
+			expectedFile:     true,
+		},
+		{
+			name:             "download is denied",
+			settings:         map[string]interface{}{"download": true},
+			commands:         map[string]error{"aws s3 cp": &client.CommandError{Err: errors.New("exit status 1"), Stderr: "An error occurred (AccessDenied) when calling the GetObject operation"}},
+			expectedKind:     errorKind(client.ErrorKindAuth),
+			expectedCommands: []string{"aws s3 cp s3://bucket/db.bak"},
+		},
+		{
+			name:         "AWS CLI credentials are not configured",
+			settings:     map[string]interface{}{"download": true},
+			commands:     map[string]error{"aws s3 ls": errors.New("exit status 255")},
+			expectedKind: errorKind(client.ErrorKindEnvironment),
+		},
+		{
+			name:             "restore in Docker",
+			settings:         map[string]interface{}{"restore": true, "container": "restored", "restore-password": "Passw0rd"},
+			expectedCommands: []string{"docker run --name restored", "docker exec -e SQLCMDPASSWORD restored", "docker exec -t -e SQLCMDPASSWORD restored"},
+			expectedFile:     true,
+		},
+		{
+			name:             "restore natively",
+			settings:         map[string]interface{}{"restore": true, "native": true},
+			expectedCommands: []string{"sqlcmd -b -x -Q"},
+			expectedFile:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			downloadDirectory := t.TempDir()
+			settings := map[string]interface{}{
+				"server":                   "rds.example.com",
+				"username":                 "admin",
+				"password":                 "secret",
+				"database":                 "db",
+				"bucket":                   "bucket",
+				"filename":                 "db.bak",
+				"download-directory":       downloadDirectory,
+				"restore-server-directory": newServerDirectory(t),
+			}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			runner := setUpFlow(t, settings)
+			runner.On("docker", "", nil).On("sqlcmd", "", nil)
+			for prefix, err := range test.commands {
+				runner.On(prefix, "", err)
+			}
+			sqlClient := clienttest.NewSQLClient(test.lifecycle...)
+			sqlClient.TaskMessage = test.taskMessage
+			sqlClient.StartError = test.startError
+			client.UseSQLClient(sqlClient)
+
+			err := runCreate(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, runner, test.expectedCommands)
+			if test.expectedKind == nil && len(sqlClient.Backups()) != 1 {
+				t.Errorf("expected 1 backup but got %d", len(sqlClient.Backups()))
+			}
+			_, errFile := os.Stat(filepath.Join(downloadDirectory, "db.bak"))
+			if test.expectedFile != (errFile == nil) {
+				t.Errorf("expected download of backup to be %v but got %v", test.expectedFile, errFile == nil)
+			}
+		})
+	}
+}
+
+func TestRunCreateStopsPollingWhenContextIsDone(t *testing.T) {
+	setUpFlow(t, map[string]interface{}{"database": "db", "bucket": "bucket", "filename": "db.bak", "wait": true})
+	client.UseSQLClient(clienttest.NewSQLClient(clienttest.StatusInProgress))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := runCreate(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+	}
+}
+
+func TestRunCreateRecordsBackupNotStarted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rds_backup.prom")
+	setUpFlow(t, map[string]interface{}{"database": "not_started", "bucket": "bucket", "filename": "db.bak", "metrics-textfile": path})
+	sqlClient := clienttest.NewSQLClient()
+	sqlClient.StartError = client.NewError(client.ErrorKindTask, errors.New("Msg 50000, Level 16"))
+	client.UseSQLClient(sqlClient)
+
+	err := runCreate(context.Background())
+	writeMetricsTextfile()
+
+	checkFlowResult(t, err, errorKind(client.ErrorKindTask), nil, nil)
+	content, errRead := os.ReadFile(path)
+	if errRead != nil {
+		t.Fatal(errRead)
+	}
+	expected := `rds_backup_failures_total{database="not_started",lifecycle="NOT_STARTED"} 1`
+	if !strings.Contains(string(content), expected) {
+		t.Errorf("expected %s in:\n%s", expected, content)
+	}
+}
+
+func TestRunCreateNotifiesBackupNotStarted(t *testing.T) {
+	payloads := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+	setUpFlow(t, map[string]interface{}{
+		"database":      "db",
+		"bucket":        "bucket",
+		"filename":      "db.bak",
+		"notifications": []map[string]interface{}{{"type": client.NotifierTypeWebhook, "url": server.URL, "events": []string{client.NotificationEventFailure}}},
+	})
+	t.Cleanup(func() { client.UseNotifiers(nil) })
+	sqlClient := clienttest.NewSQLClient()
+	sqlClient.StartError = client.NewError(client.ErrorKindTask, errors.New("Msg 50000, Level 16"))
+	client.UseSQLClient(sqlClient)
+
+	err := runCreate(context.Background())
+
+	checkFlowResult(t, err, errorKind(client.ErrorKindTask), nil, nil)
+	select {
+	case payload := <-payloads:
+		if payload["event"] != client.NotificationEventFailure || !strings.Contains(fmt.Sprint(payload["error"]), "Msg 50000") {
+			t.Errorf("expected failure with the error of the task but got %v", payload)
+		}
+	default:
+		t.Error("expected a failure to be notified")
+	}
+}
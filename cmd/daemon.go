@@ -0,0 +1,352 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const schedulesKey = "schedules"
+
+type backupSchedule struct {
+	name      string
+	params    client.BackupParameters
+	items     []client.ScheduleItem
+	nextRuns  []time.Time
+	retention client.RetentionPolicy
+}
+
+func init() {
+	opts := daemonOptions{}
+
+	var daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Takes backups according to the schedules in the configuration file",
+		Long: `Takes backups according to the schedules in the configuration file
+
+			A schedule specifies a profile (or database, server, username, password and bucket),
+			a plan such as "full daily 02:00, diff hourly" and optionally retention-count and retention-age`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
+			}
+			dumpParameters(cmd)
+			ctx := cmd.Context()
+			schedules, errOpt := getBackupSchedules(ctx)
+			if errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
+			}
+			return runDaemon(ctx, schedules, opts.stateDirectory, opts.metricsListen)
+		},
+	}
+
+	flags := daemonCmd.Flags()
+	bindDaemonOptions(flags, &opts)
+
+	RootCmd.AddCommand(daemonCmd)
+}
+
+func getDefaultStateDirectory() string {
+	configDirectory, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDirectory, "rds-backup")
+}
+
+func getBackupSchedules(ctx context.Context) ([]backupSchedule, error) {
+	names := []string{}
+	for name := range viper.GetStringMap(schedulesKey) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, errors.New("No schedules are found in the configuration file")
+	}
+
+	messages := strings.Builder{}
+	schedules := []backupSchedule{}
+	for _, name := range names {
+		items, err := client.ParseSchedulePlan(getScheduleSetting(name, "plan"))
+		if err != nil {
+			messages.WriteString(fmt.Sprintf("%s: %s\n", name, err.Error()))
+			continue
+		}
+		retention := client.RetentionPolicy{
+			Count: viper.GetInt(getScheduleKey(name, "retention-count")),
+		}
+		if age := getScheduleSetting(name, "retention-age"); age != "" {
+			maxAge, errAge := time.ParseDuration(age)
+			if errAge != nil {
+				messages.WriteString(fmt.Sprintf("%s: invalid retention-age %s\n", name, age))
+				continue
+			}
+			retention.MaxAge = maxAge
+		}
+		isComplete := true
+		for _, key := range []string{"server", "username", "password", "database", "bucket"} {
+			if getScheduleSetting(name, key) == "" {
+				messages.WriteString(fmt.Sprintf("%s: %s must be specified\n", name, key))
+				isComplete = false
+			}
+		}
+		if !isComplete {
+			continue
+		}
+		password, errPassword := client.ResolvePassword(ctx, getScheduleSetting(name, "password"))
+		if errPassword != nil {
+			messages.WriteString(fmt.Sprintf("%s: %s\n", name, errPassword.Error()))
+			continue
+		}
+		schedules = append(schedules, backupSchedule{
+			name: name,
+			params: client.BackupParameters{
+				DatabaseParameters: client.DatabaseParameters{
+					Server:       getScheduleSetting(name, "server"),
+					Username:     getScheduleSetting(name, "username"),
+					Password:     password,
+					DatabaseName: getScheduleSetting(name, "database"),
+				},
+				BucketName: getScheduleSetting(name, "bucket"),
+			},
+			items:     items,
+			retention: retention,
+		})
+	}
+	validateAwsOptions(&messages)
+	validateUnattendedAwsOptions(&messages)
+	validatePollOptions(&messages)
+	validateSQLClientOptions(&messages)
+
+	if messages.String() != "" {
+		return nil, errors.New(messages.String())
+	}
+	return schedules, nil
+}
+
+func getScheduleKey(name string, key string) string {
+	return fmt.Sprintf("%s.%s.%s", schedulesKey, name, key)
+}
+
+// getScheduleSetting returns a setting of a schedule, which falls back to its
+// profile, the defaults block and then the top level of the configuration file
+func getScheduleSetting(name string, key string) string {
+	if value := viper.GetString(getScheduleKey(name, key)); value != "" {
+		return value
+	}
+	if profile := viper.GetString(getScheduleKey(name, "profile")); profile != "" {
+		if value := viper.GetString(fmt.Sprintf("%s.%s", getProfileKey(profile), key)); value != "" {
+			return value
+		}
+		if value := viper.GetString(fmt.Sprintf("%s.%s", defaultsKey, key)); value != "" {
+			return value
+		}
+	}
+	return viper.GetString(key)
+}
+
+func runDaemon(ctx context.Context, schedules []backupSchedule, stateDirectory string, metricsListen string) error {
+	if stateDirectory == "" {
+		return client.NewError(client.ErrorKindValidation, errors.New("--state-directory must be specified"))
+	}
+	if errNotifications := configureNotifications(ctx); errNotifications != nil {
+		return errNotifications
+	}
+	c, errClient := getSQLClient(ctx)
+	if errClient != nil {
+		return errClient
+	}
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+
+	history := client.NewRunHistory(filepath.Join(stateDirectory, "history.jsonl"))
+	lockDirectory := filepath.Join(stateDirectory, "locks")
+
+	now := time.Now()
+	for i := range schedules {
+		schedules[i].nextRuns = make([]time.Time, len(schedules[i].items))
+		for j, item := range schedules[i].items {
+			schedules[i].nextRuns[j] = item.NextRun(now)
+		}
+		logger.Info("Schedule loaded", "schedule", schedules[i].name, "database", schedules[i].params.DatabaseName, "plan", getPlanDescription(schedules[i].items))
+	}
+
+	if metricsListen != "" {
+		go serveDaemonMetrics(metricsListen)
+	}
+
+	jobs := sync.WaitGroup{}
+	for {
+		nextRun := getNextRun(schedules)
+		timer := time.NewTimer(time.Until(nextRun))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("Cancelling running backups")
+			jobs.Wait()
+			return nil
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for i := range schedules {
+			backupType := getDueBackupType(&schedules[i], now)
+			if backupType == "" {
+				continue
+			}
+			jobs.Add(1)
+			go func(schedule *backupSchedule, backupType string) {
+				defer jobs.Done()
+				runScheduledBackup(ctx, c, history, lockDirectory, schedule, backupType)
+			}(&schedules[i], backupType)
+		}
+	}
+}
+
+func serveDaemonMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Info("Serving metrics", "address", address)
+	if err := server.ListenAndServe(); err != nil {
+		logger.Error("Unable to serve metrics", "address", address, "error", err)
+	}
+}
+
+func getPlanDescription(items []client.ScheduleItem) string {
+	descriptions := []string{}
+	for _, item := range items {
+		descriptions = append(descriptions, item.String())
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func getNextRun(schedules []backupSchedule) time.Time {
+	nextRun := time.Time{}
+	for _, schedule := range schedules {
+		for _, run := range schedule.nextRuns {
+			if nextRun.IsZero() || run.Before(nextRun) {
+				nextRun = run
+			}
+		}
+	}
+	return nextRun
+}
+
+// getDueBackupType returns the type of backup of a schedule due at the
+// specified time and moves the due items to their next runs; a full backup
+// takes the place of a differential backup due at the same time
+func getDueBackupType(schedule *backupSchedule, now time.Time) string {
+	backupType := ""
+	for i, item := range schedule.items {
+		if schedule.nextRuns[i].After(now) {
+			continue
+		}
+		schedule.nextRuns[i] = item.NextRun(now)
+		if backupType != client.BackupTypeFull {
+			backupType = item.BackupType
+		}
+	}
+	return backupType
+}
+
+func runScheduledBackup(ctx context.Context, c client.SQLClient, history *client.RunHistory, lockDirectory string, schedule *backupSchedule, backupType string) {
+	ctx, cancel := getOperationContext(ctx)
+	defer cancel()
+
+	params := schedule.params
+	params.Type = backupType
+	params.Filename = client.GetBackupFilename(params.DatabaseName, backupType, time.Now())
+
+	record := client.RunRecord{
+		Schedule:     schedule.name,
+		DatabaseName: params.DatabaseName,
+		BackupType:   backupType,
+		BucketName:   params.BucketName,
+		Filename:     params.Filename,
+		StartedAt:    time.Now().UTC(),
+	}
+
+	log := logger.With("schedule", schedule.name, "database", params.DatabaseName, "type", backupType, "bucket", params.BucketName, "filename", params.Filename)
+
+	release, errLock := client.AcquireLock(lockDirectory, client.GetDatabaseLockName(params.Server, params.DatabaseName))
+	if errLock != nil {
+		record.Status = client.RunStatusSkipped
+		record.Error = errLock.Error()
+		appendRunRecord(history, record, log)
+		return
+	}
+	defer release()
+
+	log.Info("Backup started")
+	taskID, err := startBackup(ctx, c, &params)
+	if err == nil {
+		record.TaskID = taskID
+		log = log.With("task_id", taskID)
+		err = isBackupCompleted(ctx, c, &params, taskID, log)
+	}
+	record.FinishedAt = time.Now().UTC()
+	if err != nil {
+		record.Status = client.RunStatusError
+		record.Error = err.Error()
+		appendRunRecord(history, record, log)
+		return
+	}
+	record.Status = client.RunStatusSuccess
+	if !appendRunRecord(history, record, log) {
+		return
+	}
+
+	deleted, errRetention := history.ApplyRetention(ctx, schedule.name, schedule.retention, time.Now().UTC())
+	for _, filename := range deleted {
+		log.Info("Deleted backup by retention policy", "deleted", filename)
+	}
+	if errRetention != nil {
+		log.Error("Unable to apply retention policy", "error", errRetention)
+	}
+}
+
+func appendRunRecord(history *client.RunHistory, record client.RunRecord, log *slog.Logger) bool {
+	if record.Status == client.RunStatusSuccess {
+		log.Info("Backup completed", "status", record.Status)
+	} else {
+		log.Error("Backup not completed", "status", record.Status, "error", record.Error)
+	}
+	if err := history.Append(record); err != nil {
+		log.Error("Unable to record run history", "error", err)
+		return false
+	}
+	return true
+}
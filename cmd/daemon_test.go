@@ -0,0 +1,118 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/viper"
+)
+
+func TestGetDueBackupType(t *testing.T) {
+	items, err := client.ParseSchedulePlan("full daily 02:00, diff hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC)
+	schedule := &backupSchedule{name: "nightly", items: items, nextRuns: make([]time.Time, len(items))}
+	for i, item := range items {
+		schedule.nextRuns[i] = item.NextRun(start)
+	}
+
+	tests := []struct {
+		now      time.Time
+		expected string
+	}{
+		{time.Date(2024, 3, 1, 0, 59, 0, 0, time.UTC), ""},
+		{time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC), client.BackupTypeDifferential},
+		{time.Date(2024, 3, 1, 1, 30, 0, 0, time.UTC), ""},
+		// a full backup takes the place of a differential backup due at the same time
+		{time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), client.BackupTypeFull},
+		{time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), client.BackupTypeDifferential},
+		// runs missed while the daemon is busy are taken once
+		{time.Date(2024, 3, 2, 5, 10, 0, 0, time.UTC), client.BackupTypeFull},
+		{time.Date(2024, 3, 2, 5, 20, 0, 0, time.UTC), ""},
+	}
+
+	for _, test := range tests {
+		if actual := getDueBackupType(schedule, test.now); actual != test.expected {
+			t.Errorf("%s: expected %q but got %q", test.now, test.expected, actual)
+		}
+	}
+	if expected := time.Date(2024, 3, 2, 6, 0, 0, 0, time.UTC); !schedule.nextRuns[1].Equal(expected) {
+		t.Errorf("expected next differential backup at %s but got %s", expected, schedule.nextRuns[1])
+	}
+}
+
+func TestGetBackupSchedules(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      map[string]interface{}
+		expectedError string
+	}{
+		{
+			name:     "role without MFA",
+			settings: map[string]interface{}{"role-arn": "arn:aws:iam::123456789012:role/backup"},
+		},
+		{
+			name: "role with MFA",
+			settings: map[string]interface{}{
+				"role-arn":   "arn:aws:iam::123456789012:role/backup",
+				"mfa-serial": "arn:aws:iam::123456789012:mfa/user",
+			},
+			expectedError: "--mfa-serial cannot be used with daemon or serve",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			viper.Set("poll-interval", time.Minute)
+			viper.Set(schedulesKey, map[string]interface{}{
+				"nightly": map[string]interface{}{
+					"plan":     "full daily 02:00",
+					"server":   "rds.example.com",
+					"username": "admin",
+					"password": "Passw0rd",
+					"database": "db",
+					"bucket":   "bucket",
+				},
+			})
+			for key, value := range test.settings {
+				viper.Set(key, value)
+			}
+
+			schedules, err := getBackupSchedules(context.Background())
+
+			if test.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+					t.Fatalf("expected error containing %q but got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if len(schedules) != 1 {
+				t.Errorf("expected 1 schedule but got %d", len(schedules))
+			}
+		})
+	}
+}
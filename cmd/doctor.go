@@ -0,0 +1,291 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// results of checks of doctor
+const checkPassed = "PASS"
+const checkFailed = "FAIL"
+const checkSkipped = "SKIP"
+const checkInfo = "INFO"
+
+// backupRestoreOptionCheck is the name of the check of the option group of the RDS instance
+const backupRestoreOptionCheck = "SQLSERVER_BACKUP_RESTORE option"
+
+// dockerImages are the images checked by doctor with what they are used for
+var dockerImages = []struct {
+	name  string
+	image string
+	usage string
+}{
+	{name: "SQL tools image", image: client.SQLToolsImage, usage: "for --sql-client docker"},
+	{name: "SQL server image", image: client.SQLServerImage, usage: "for restores in containers"},
+	{name: "SQL server 2022 image", image: client.SQLServer2022Image, usage: "for restore --from-url in containers"},
+}
+
+// checkResult is the outcome of a check of the environment with a hint to
+// fix it if it fails
+type checkResult struct {
+	name   string
+	status string
+	detail string
+	hint   string
+}
+
+func init() {
+	opts := doctorOptions{}
+
+	var doctorCmd = &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnoses the environment and the connectivity to AWS and the SQL server",
+		Long:  "Diagnoses the environment and the connectivity to AWS and the SQL server, with a hint to fix each failed check",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
+			}
+			dumpParameters(cmd)
+			if errOpt := validateDoctorOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
+			}
+			ctx, cancel := getOperationContext(cmd.Context())
+			defer cancel()
+			return runDoctor(ctx)
+		},
+	}
+
+	flags := doctorCmd.Flags()
+	bindDoctorOptions(flags, &opts)
+
+	RootCmd.AddCommand(doctorCmd)
+}
+
+func runDoctor(ctx context.Context) error {
+	results := diagnose(ctx)
+
+	failed := 0
+	for _, result := range results {
+		fmt.Printf("%s  %-32s %s\n", result.status, result.name, result.detail)
+		if result.status == checkFailed {
+			failed++
+		}
+		if result.hint != "" {
+			fmt.Printf("      Hint: %s\n", result.hint)
+		}
+	}
+
+	if failed > 0 {
+		return newEnvironmentError(fmt.Sprintf("%d of %d checks failed", failed, len(results)))
+	}
+	return nil
+}
+
+// diagnose runs the checks in order; a check is skipped if the options it
+// needs are not specified or a check it depends on has failed
+func diagnose(ctx context.Context) []checkResult {
+	var results []checkResult
+	add := func(name string, detail string, err error, hint string) bool {
+		if err != nil {
+			results = append(results, checkResult{name: name, status: checkFailed, detail: getErrorDetail(err), hint: hint})
+			return false
+		}
+		results = append(results, checkResult{name: name, status: checkPassed, detail: detail})
+		return true
+	}
+	skip := func(name string, reason string) {
+		results = append(results, checkResult{name: name, status: checkSkipped, detail: reason})
+	}
+
+	// sqlcmd and Docker are alternatives (to the built-in client) unless one
+	// of them is selected by --sql-client; an alternative which cannot be run
+	// is reported for information only
+	sqlClientName := viper.GetString("sql-client")
+	isDockerRequired := sqlClientName == client.SQLClientDocker
+	check := func(name string, detail string, err error, hint string, isRequired bool) bool {
+		if err != nil && !isRequired {
+			results = append(results, checkResult{name: name, status: checkInfo, detail: getErrorDetail(err), hint: hint})
+			return false
+		}
+		return add(name, detail, err, hint)
+	}
+
+	isSQLCmdInstalled := false
+	if sqlClientName == client.SQLClientDocker || sqlClientName == client.SQLClientGo {
+		skip("sqlcmd", fmt.Sprintf("--sql-client is %s", sqlClientName))
+	} else {
+		sqlcmdVersion, errSQLCmd := client.GetSQLCmdVersion(ctx)
+		isSQLCmdInstalled = check("sqlcmd", sqlcmdVersion, errSQLCmd, "Install sqlcmd (Microsoft ODBC driver and mssql-tools), or use Docker or the built-in client (--sql-client docker or go)", sqlClientName == client.SQLClientNative)
+	}
+
+	isDockerAvailable := false
+	dockerVersion, errDocker := client.GetDockerServerVersion(ctx)
+	if check("Docker daemon", dockerVersion, errDocker, "Install Docker and start its daemon (required by --sql-client docker and restores in containers)", isDockerRequired) {
+		var errTrust error
+		if !client.IsDockerContentTrustDisabled() {
+			errTrust = errors.New("DOCKER_CONTENT_TRUST is 1")
+		}
+		isDockerAvailable = check("Docker Content Trust", "disabled", errTrust, "Run 'export DOCKER_CONTENT_TRUST=0' as the SQL server image is not signed", isDockerRequired)
+
+		// images which have not been pulled are pulled as they are used
+		for _, image := range dockerImages {
+			var errImage error
+			if !client.IsDockerImageAvailable(ctx, image.image) {
+				errImage = fmt.Errorf("%s has not been pulled", image.image)
+			}
+			check(image.name, image.image, errImage, fmt.Sprintf("Run 'docker pull %s' (%s) to avoid pulling it on first use", image.image, image.usage), false)
+		}
+	} else {
+		skip("Docker Content Trust", "Docker daemon is not running")
+		for _, image := range dockerImages {
+			skip(image.name, "Docker daemon is not running")
+		}
+	}
+	if selected, ok := getSelectedSQLClientName(sqlClientName, isSQLCmdInstalled, isDockerAvailable); ok {
+		add("SQL client", selected, nil, "")
+	} else {
+		skip("SQL client", fmt.Sprintf("SQL client %s cannot be run", selected))
+	}
+
+	var errAwsCli error
+	if !client.IsAwsCliInstalled(ctx) {
+		errAwsCli = errors.New("aws is not found")
+	}
+	isAwsAuthenticated := false
+	if add("AWS CLI", "installed", errAwsCli, "Install AWS CLI (https://aws.amazon.com/cli/)") {
+		identity, errIdentity := "", configureAwsCredentials(ctx)
+		if errIdentity == nil {
+			identity, errIdentity = client.GetAwsCallerIdentity(ctx)
+		}
+		isAwsAuthenticated = add("AWS credentials", identity, errIdentity, "Run 'aws configure' or check --role-arn, --external-id and --mfa-serial")
+	} else {
+		skip("AWS credentials", "AWS CLI is not installed")
+	}
+
+	bucketName := viper.GetString("bucket")
+	switch {
+	case bucketName == "":
+		skip("S3 bucket", "--bucket is not specified")
+	case !isAwsAuthenticated:
+		skip("S3 bucket", "AWS credentials are not available")
+	default:
+		add("S3 bucket", bucketName, client.CheckBucketAccess(ctx, bucketName), fmt.Sprintf("Check that bucket %s exists and s3:ListBucket on it is allowed to the AWS credentials", bucketName))
+	}
+
+	server := viper.GetString("server")
+	if server == "" {
+		for _, name := range []string{"Connection to server", "Login", backupRestoreOptionCheck} {
+			skip(name, "--server is not specified")
+		}
+		return results
+	}
+
+	address := client.GetServerAddress(server)
+	isConnected := add("Connection to server", address, client.CheckServerConnection(ctx, server), fmt.Sprintf("Check that the security group of the RDS instance allows inbound TCP connections to %s from this machine, and that the instance is publicly accessible or reachable via VPN", address))
+
+	switch {
+	case viper.GetString("username") == "" || viper.GetString("password") == "":
+		skip("Login", "--username or --password is not specified")
+	case !isConnected:
+		skip("Login", "The server cannot be connected")
+	default:
+		serverVersion, errLogin := getServerVersion(ctx)
+		add("Login", serverVersion, errLogin, "Check --username and --password, and that the login is allowed to connect to the database")
+	}
+
+	if isAwsAuthenticated {
+		enabled, errOption := client.IsBackupRestoreOptionEnabled(ctx, server)
+		if errOption == nil && !enabled {
+			errOption = errors.New("No option group of the instance contains SQLSERVER_BACKUP_RESTORE")
+		}
+		add(backupRestoreOptionCheck, "enabled", errOption, "Add option SQLSERVER_BACKUP_RESTORE with an IAM role allowed to access the bucket to an option group of the instance (rds:DescribeDBInstances and rds:DescribeOptionGroups are required to check it)")
+	} else {
+		skip(backupRestoreOptionCheck, "AWS credentials are not available")
+	}
+
+	return results
+}
+
+// getSelectedSQLClientName returns the name of the SQL client selected as
+// GetClient of client selects it, and whether it can be run; the built-in
+// client can always be run
+func getSelectedSQLClientName(name string, isSQLCmdInstalled bool, isDockerAvailable bool) (string, bool) {
+	switch {
+	case name == client.SQLClientNative:
+		return name, isSQLCmdInstalled
+	case name == client.SQLClientDocker:
+		return name, isDockerAvailable
+	case name != "":
+		return name, true
+	case isSQLCmdInstalled:
+		return client.SQLClientNative, true
+	case isDockerAvailable:
+		return client.SQLClientDocker, true
+	}
+	return client.SQLClientGo, true
+}
+
+func getServerVersion(ctx context.Context) (string, error) {
+	if errPassword := resolvePasswords(ctx); errPassword != nil {
+		return "", errPassword
+	}
+	c, errClient := getSQLClient(ctx)
+	if errClient != nil {
+		return "", errClient
+	}
+	databaseName := viper.GetString("database")
+	if databaseName == "" {
+		databaseName = "master"
+	}
+	return c.GetServerVersion(ctx, &client.DatabaseParameters{
+		Server:       viper.GetString("server"),
+		Username:     viper.GetString("username"),
+		Password:     viper.GetString("password"),
+		DatabaseName: databaseName,
+	})
+}
+
+// getErrorDetail returns the first line of the error, or of the standard
+// error of the command if it is the error of an external command
+func getErrorDetail(err error) string {
+	text := err.Error()
+	var commandError *client.CommandError
+	if errors.As(err, &commandError) && strings.TrimSpace(commandError.Stderr) != "" {
+		text = commandError.Stderr
+	}
+	return strings.SplitN(strings.TrimSpace(text), "\n", 2)[0]
+}
+
+func validateDoctorOptions() error {
+	messages := strings.Builder{}
+
+	validateAwsOptions(&messages)
+	validateSQLClientOptions(&messages)
+
+	if messages.String() != "" {
+		return errors.New(messages.String())
+	}
+
+	return nil
+}
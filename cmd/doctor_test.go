@@ -0,0 +1,182 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/alexhokl/rds-backup/client/clienttest"
+)
+
+var errDockerDaemon = &client.CommandError{Err: errors.New("exit status 1"), Stderr: "Cannot connect to the Docker daemon at unix:///var/run/docker.sock."}
+
+func TestDiagnose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	server := fmt.Sprintf("tcp:127.0.0.1,%d", listener.Addr().(*net.TCPAddr).Port)
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		commands map[string]error
+		expected map[string]string
+	}{
+		{
+			name:     "all checks pass",
+			settings: map[string]interface{}{"server": server, "username": "admin", "password": "secret", "bucket": "bucket"},
+			expected: map[string]string{
+				"sqlcmd":                 checkPassed,
+				"Docker daemon":          checkPassed,
+				"SQL client":             checkPassed,
+				"SQL tools image":        checkPassed,
+				"SQL server image":       checkPassed,
+				"AWS credentials":        checkPassed,
+				"S3 bucket":              checkPassed,
+				"Connection to server":   checkPassed,
+				"Login":                  checkPassed,
+				backupRestoreOptionCheck: checkPassed,
+			},
+		},
+		{
+			name:     "options are not specified",
+			expected: map[string]string{"S3 bucket": checkSkipped, "Connection to server": checkSkipped, "Login": checkSkipped},
+		},
+		{
+			name:     "Docker daemon is not running",
+			commands: map[string]error{"docker version": errDockerDaemon},
+			expected: map[string]string{"Docker daemon": checkInfo, "SQL tools image": checkSkipped, "SQL server image": checkSkipped, "SQL client": checkPassed},
+		},
+		{
+			name:     "Docker daemon is not running for --sql-client docker",
+			settings: map[string]interface{}{"sql-client": client.SQLClientDocker},
+			commands: map[string]error{"docker version": errDockerDaemon},
+			expected: map[string]string{"sqlcmd": checkSkipped, "Docker daemon": checkFailed, "SQL client": checkSkipped},
+		},
+		{
+			name:     "sqlcmd is not installed",
+			commands: map[string]error{"sqlcmd -?": errors.New("executable file not found in $PATH")},
+			expected: map[string]string{"sqlcmd": checkInfo, "Docker daemon": checkPassed, "SQL client": checkPassed},
+		},
+		{
+			name:     "sqlcmd is not installed for --sql-client native",
+			settings: map[string]interface{}{"sql-client": client.SQLClientNative},
+			commands: map[string]error{"sqlcmd -?": errors.New("executable file not found in $PATH")},
+			expected: map[string]string{"sqlcmd": checkFailed, "SQL client": checkSkipped},
+		},
+		{
+			name:     "neither sqlcmd nor Docker daemon is available",
+			commands: map[string]error{"sqlcmd -?": errors.New("executable file not found in $PATH"), "docker version": errDockerDaemon},
+			expected: map[string]string{"sqlcmd": checkInfo, "Docker daemon": checkInfo, "SQL client": checkPassed},
+		},
+		{
+			name:     "images have not been pulled",
+			settings: map[string]interface{}{"sql-client": client.SQLClientGo},
+			commands: map[string]error{"docker image inspect": errors.New("exit status 1")},
+			expected: map[string]string{"sqlcmd": checkSkipped, "SQL tools image": checkInfo, "SQL server image": checkInfo, "SQL client": checkPassed},
+		},
+		{
+			name:     "AWS credentials are expired",
+			settings: map[string]interface{}{"server": server, "bucket": "bucket"},
+			commands: map[string]error{"aws sts": &client.CommandError{Err: errors.New("exit status 255"), Stderr: "An error occurred (ExpiredToken) when calling the GetCallerIdentity operation"}},
+			expected: map[string]string{"AWS credentials": checkFailed, "S3 bucket": checkSkipped, backupRestoreOptionCheck: checkSkipped},
+		},
+		{
+			name:     "server cannot be connected",
+			settings: map[string]interface{}{"server": "tcp:127.0.0.1,1", "username": "admin", "password": "secret"},
+			expected: map[string]string{"Connection to server": checkFailed, "Login": checkSkipped},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setUpDoctor(t, test.settings, test.commands)
+
+			results := map[string]checkResult{}
+			for _, result := range diagnose(context.Background()) {
+				results[result.name] = result
+			}
+			for name, expected := range test.expected {
+				if results[name].status != expected {
+					t.Errorf("expected %s of %s but got %+v", expected, name, results[name])
+				}
+				if (expected == checkFailed || expected == checkInfo) && results[name].hint == "" {
+					t.Errorf("expected a hint for %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	tests := []struct {
+		name         string
+		settings     map[string]interface{}
+		commands     map[string]error
+		expectedKind *client.ErrorKind
+	}{
+		{
+			name:     "built-in client is used without sqlcmd and Docker",
+			commands: map[string]error{"sqlcmd -?": errors.New("executable file not found in $PATH"), "docker version": errDockerDaemon},
+		},
+		{
+			name:         "selected client cannot be run",
+			settings:     map[string]interface{}{"sql-client": client.SQLClientNative},
+			commands:     map[string]error{"sqlcmd -?": errors.New("executable file not found in $PATH")},
+			expectedKind: errorKind(client.ErrorKindEnvironment),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := setUpDoctor(t, test.settings, test.commands)
+
+			err := runDoctor(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, runner, nil)
+		})
+	}
+}
+
+// setUpDoctor sets up a flow in which all checks of doctor pass except the
+// ones of the failing commands
+func setUpDoctor(t *testing.T, settings map[string]interface{}, commands map[string]error) *clienttest.CommandRunner {
+	runner := setUpFlow(t, settings)
+	runner.
+		On("sqlcmd -?", "Microsoft (R) SQL Server Command Line Tool\nVersion 17.10.0001.1 Linux\n", nil).
+		On("docker version", "24.0.7\n", nil).
+		On("docker image inspect", "sha256:0123\n", nil).
+		On("aws sts get-caller-identity", "arn:aws:iam::123456789012:user/admin\n", nil).
+		On("aws s3api head-bucket", "", nil).
+		On("aws rds describe-db-instances", "default-sqlserver-se-15-00\tbackup-restore\n", nil).
+		OnFunc("aws rds describe-option-groups", func(command *client.Command) (string, error) {
+			if command.Args[3] == "backup-restore" {
+				return "SQLSERVER_BACKUP_RESTORE\n", nil
+			}
+			return "\n", nil
+		})
+	for prefix, err := range commands {
+		runner.On(prefix, "", err)
+	}
+	client.UseSQLClient(clienttest.NewSQLClient())
+	return runner
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -33,22 +34,20 @@ func init() {
 		Use:   "download",
 		Short: "Download a backup from AWS S3 with option of restore",
 		Long:  "Download a backup from AWS S3 with option of restore",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			bindConfiguration(cmd)
-			viper.Set("verbose", opts.verbose)
-			if viper.GetBool("verbose") {
-				dumpParameters(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
 			}
-			errOpt := validateDownloadOptions()
-			if errOpt != nil {
-				fmt.Println(errOpt.Error())
-				cmd.HelpFunc()(cmd, args)
-				return
-			}
-			err := runDownload()
-			if err != nil {
-				fmt.Println(err.Error())
+			dumpParameters(cmd)
+			if errOpt := validateDownloadOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
 			}
+			ctx, cancel := getOperationContext(cmd.Context())
+			defer cancel()
+			err := runDownload(ctx)
+			writeMetricsTextfile()
+			return err
 		},
 	}
 
@@ -58,35 +57,67 @@ func init() {
 	RootCmd.AddCommand(downloadCmd)
 }
 
-func runDownload() error {
-	if !client.IsAwsCliInstalled() {
-		return errors.New("AWS CLI is required")
+func runDownload(ctx context.Context) error {
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+	if errPassword := resolvePasswords(ctx); errPassword != nil {
+		return errPassword
+	}
+	if errEncryption := configureEncryption(); errEncryption != nil {
+		return errEncryption
+	}
+	if errNotifications := configureNotifications(ctx); errNotifications != nil {
+		return errNotifications
+	}
+
+	if !client.IsAwsCliInstalled(ctx) {
+		return newEnvironmentError("AWS CLI is required")
 	}
-	if !client.IsAwsCredentialsConfigured() {
-		return errors.New("AWS CLI credentials are not configured yet. Please try 'aws configure'")
+	if !client.IsAwsCredentialsConfigured(ctx) {
+		return newEnvironmentError("AWS CLI credentials are not configured yet. Please try 'aws configure'")
 	}
 
-	errDownload := client.DownloadBackup(viper.GetString("bucket"), viper.GetString("filename"), viper.GetString("download-directory"))
+	if isLatestBackupRequested() {
+		filename, errLatest := client.GetLatestBackupFilename(ctx, viper.GetString("bucket"), viper.GetString("database"))
+		if errLatest != nil {
+			return errLatest
+		}
+		logger.Info("Resolved the latest backup", "database", viper.GetString("database"), "bucket", viper.GetString("bucket"), "filename", filename)
+		viper.Set("filename", filename)
+	}
+
+	downloadDirectory, errDownload := downloadBackup(ctx, viper.GetString("bucket"), viper.GetString("filename"))
 	if errDownload != nil {
 		return errDownload
 	}
 
+	maskProfile, errProfile := getMaskProfile()
+	if errProfile != nil {
+		return errProfile
+	}
+	loginPasswords, errPasswords := getLoginPasswords(ctx)
+	if errPasswords != nil {
+		return errPasswords
+	}
+
 	basicRestoreParameters := client.BaseRestoreParameters{
-		Filename:          viper.GetString("filename"),
-		DatabaseName:      viper.GetString("database"),
-		DataName:          viper.GetString("mdf"),
-		LogName:           viper.GetString("ldf"),
-		DownloadDirectory: viper.GetString("download-directory"),
+		BucketName:           viper.GetString("bucket"),
+		Filename:             viper.GetString("filename"),
+		DatabaseName:         viper.GetString("database"),
+		DataName:             viper.GetString("mdf"),
+		LogName:              viper.GetString("ldf"),
+		DownloadDirectory:    downloadDirectory,
+		MaskProfile:          maskProfile,
+		PostRestoreDirectory: viper.GetString("post-restore"),
+		UserLogins:           viper.GetStringMapString("user-login"),
+		LoginPasswords:       loginPasswords,
 	}
 
 	if viper.GetBool("restore") {
 		if viper.GetBool("native") {
-			nativeParameters := &client.NativeRestoreParameters{
-				BaseRestoreParameters: basicRestoreParameters,
-				CustomDataPath:        viper.GetString("restore-data-directory"),
-				ServerPath:            viper.GetString("restore-server-directory"),
-			}
-			errNative := client.RestoreNative(nativeParameters)
+			nativeParameters := getNativeRestoreParameters(basicRestoreParameters)
+			errNative := client.RestoreNative(ctx, nativeParameters)
 			if errNative != nil {
 				return errNative
 			}
@@ -98,7 +129,7 @@ func runDownload() error {
 			Password:              viper.GetString("restore-password"),
 			Port:                  viper.GetInt("port"),
 		}
-		errRestore := client.Restore(restoreParameters)
+		errRestore := client.Restore(ctx, restoreParameters)
 		if errRestore != nil {
 			return errRestore
 		}
@@ -113,10 +144,15 @@ func validateDownloadOptions() error {
 	if viper.GetString("bucket") == "" {
 		messages.WriteString("--bucket AWS S3 Bucket must be specified\n")
 	}
-	if viper.GetString("filename") == "" {
+	if isLatestBackupRequested() {
+		if viper.GetString("database") == "" {
+			messages.WriteString("--database Name of database must be specified to find the latest backup\n")
+		}
+	} else if viper.GetString("filename") == "" {
 		messages.WriteString("--filename Filename must be specified\n")
 	}
 
+	validateAwsOptions(&messages)
 	downloadDirectory := viper.GetString("download-directory")
 	if downloadDirectory != "" {
 		if _, errDownloadDirectory := os.Stat(downloadDirectory); os.IsNotExist(errDownloadDirectory) {
@@ -135,15 +171,7 @@ func validateDownloadOptions() error {
 			messages.WriteString("--ldf Logical name of log must be specified\n")
 		}
 		if viper.GetBool("native") {
-			if viper.GetInt("port") != client.DefaultServerPort {
-				messages.WriteString("--port Port cannot be used in restoring to local native SQL server\n")
-			}
-			restoreServerDirectory := viper.GetString("restore-server-directory")
-			if restoreServerDirectory != "" {
-				if _, errServerDirectory := os.Stat(restoreServerDirectory); os.IsNotExist(errServerDirectory) {
-					messages.WriteString("the specified restore-server-directory does not exist\n")
-				}
-			}
+			validateNativeRestoreOptions(&messages)
 		} else {
 			if viper.GetString("container") == "" {
 				messages.WriteString("--container Container name must be specified\n")
@@ -157,6 +185,22 @@ func validateDownloadOptions() error {
 			if viper.GetString("restore-data-directory") != "" {
 				messages.WriteString("--restore-data-directory cannot be used in Docker container restore\n")
 			}
+			if viper.GetString("restore-server") != "" {
+				messages.WriteString("--restore-server cannot be used in Docker container restore\n")
+			}
+			if viper.GetString("restore-share") != "" {
+				messages.WriteString("--restore-share cannot be used in Docker container restore\n")
+			}
+		}
+		validateMaskOptions(&messages)
+		validatePostRestoreOptions(&messages)
+		validateOrphanedUserOptions(&messages)
+	} else {
+		if viper.GetString("mask-profile") != "" {
+			messages.WriteString("--mask-profile cannot be used without --restore\n")
+		}
+		if viper.GetString("post-restore") != "" {
+			messages.WriteString("--post-restore cannot be used without --restore\n")
 		}
 	}
 
@@ -0,0 +1,133 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexhokl/rds-backup/client"
+)
+
+func TestRunDownload(t *testing.T) {
+	tests := []struct {
+		name             string
+		settings         map[string]interface{}
+		commands         map[string]error
+		useCache         bool
+		expectedKind     *client.ErrorKind
+		expectedCommands []string
+		expectedFile     bool
+	}{
+		{
+			name:             "download to directory",
+			expectedCommands: []string{"aws s3 cp s3://bucket/db.bak"},
+			expectedFile:     true,
+		},
+		{
+			name:             "download to cache",
+			useCache:         true,
+			expectedCommands: []string{"aws s3api head-object --bucket bucket --key db.bak", "aws s3 cp s3://bucket/db.bak"},
+			expectedFile:     true,
+		},
+		{
+			name:             "backup does not exist",
+			commands:         map[string]error{"aws s3 cp": &client.CommandError{Err: errors.New("exit status 1"), Stderr: "fatal error: An error occurred (404) when calling the HeadObject operation: Key \"db.bak\" does not exist"}},
+			expectedKind:     errorKind(client.ErrorKindTransfer),
+			expectedCommands: []string{"aws s3 cp s3://bucket/db.bak"},
+		},
+		{
+			name:         "AWS CLI is not installed",
+			commands:     map[string]error{"aws help": errors.New("executable file not found in $PATH")},
+			expectedKind: errorKind(client.ErrorKindEnvironment),
+		},
+		{
+			name:             "download and restore in Docker",
+			settings:         map[string]interface{}{"restore": true, "container": "restored", "restore-password": "Passw0rd"},
+			expectedCommands: []string{"aws s3 cp s3://bucket/db.bak", "docker run --name restored", "docker exec -t -e SQLCMDPASSWORD restored"},
+			expectedFile:     true,
+		},
+		{
+			name:             "download and restore fails",
+			settings:         map[string]interface{}{"restore": true, "native": true},
+			commands:         map[string]error{"sqlcmd": errors.New("exit status 1")},
+			expectedKind:     errorKind(client.ErrorKindRestore),
+			expectedCommands: []string{"aws s3 cp s3://bucket/db.bak", "sqlcmd -b -x -Q"},
+			expectedFile:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			downloadDirectory := t.TempDir()
+			settings := map[string]interface{}{
+				"bucket":                   "bucket",
+				"database":                 "db",
+				"filename":                 "db.bak",
+				"mdf":                      "Data",
+				"ldf":                      "Log",
+				"restore-server-directory": newServerDirectory(t),
+			}
+			if test.useCache {
+				settings["cache-directory"] = downloadDirectory
+			} else {
+				settings["download-directory"] = downloadDirectory
+			}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			runner := setUpFlow(t, settings)
+			runner.On("docker", "", nil).On("sqlcmd", "", nil)
+			for prefix, err := range test.commands {
+				runner.On(prefix, "", err)
+			}
+
+			err := runDownload(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, runner, test.expectedCommands)
+			pattern := filepath.Join(downloadDirectory, "db.bak")
+			if test.useCache {
+				pattern = filepath.Join(downloadDirectory, "bucket", "etag", "db.bak")
+			}
+			files, _ := filepath.Glob(pattern)
+			if test.expectedFile != (len(files) == 1) {
+				t.Errorf("expected download of backup to be %v but got %v", test.expectedFile, files)
+			}
+			if partials, _ := filepath.Glob(filepath.Join(downloadDirectory, "*.part")); len(partials) > 0 {
+				t.Errorf("expected partial downloads to be removed but got %v", partials)
+			}
+		})
+	}
+}
+
+func TestRunDownloadReusesCachedBackup(t *testing.T) {
+	cacheDirectory := t.TempDir()
+	runner := setUpFlow(t, map[string]interface{}{"bucket": "bucket", "filename": "db.bak", "cache-directory": cacheDirectory})
+
+	for i := 0; i < 2; i++ {
+		if err := runDownload(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := runner.Count("aws s3 cp"); count != 1 {
+		t.Errorf("expected 1 download but got %d", count)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDirectory, "bucket", "etag", "db.bak")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	encryptOpts := fileEncryptionOptions{}
+	decryptOpts := fileEncryptionOptions{}
+
+	var encryptCmd = &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypts a backup file",
+		Long:  "Encrypts a backup file so that it can be restored with --encryption-key-file or --encryption-key-env",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			if errOpt := validateFileEncryptionOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
+			}
+			return runEncrypt()
+		},
+	}
+
+	var decryptCmd = &cobra.Command{
+		Use:   "decrypt",
+		Short: "Decrypts a backup file",
+		Long:  "Decrypts a backup file encrypted by rds-backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(false); errLog != nil {
+				return errLog
+			}
+			if errOpt := validateFileEncryptionOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
+			}
+			return runDecrypt()
+		},
+	}
+
+	bindFileEncryptionOptions(encryptCmd.Flags(), &encryptOpts)
+	bindFileEncryptionOptions(decryptCmd.Flags(), &decryptOpts)
+
+	RootCmd.AddCommand(encryptCmd)
+	RootCmd.AddCommand(decryptCmd)
+}
+
+func runEncrypt() error {
+	key, err := getEncryptionKey()
+	if err != nil {
+		return err
+	}
+	input := viper.GetString("input")
+	output := viper.GetString("output")
+	if output == "" {
+		output = input + client.EncryptedExtension
+	}
+
+	errEncrypt := client.EncryptFile(input, output, key)
+	if errEncrypt != nil {
+		return errEncrypt
+	}
+	fmt.Printf("Encrypted %s to %s\n", input, output)
+	return nil
+}
+
+func runDecrypt() error {
+	key, err := getEncryptionKey()
+	if err != nil {
+		return err
+	}
+	input := viper.GetString("input")
+	output := viper.GetString("output")
+	if output == "" {
+		if !strings.HasSuffix(input, client.EncryptedExtension) {
+			return client.NewError(client.ErrorKindValidation, fmt.Errorf("--output must be specified as %s does not end with %s", input, client.EncryptedExtension))
+		}
+		output = strings.TrimSuffix(input, client.EncryptedExtension)
+	}
+
+	errDecrypt := client.DecryptFile(input, output, key)
+	if errDecrypt != nil {
+		return errDecrypt
+	}
+	fmt.Printf("Decrypted %s to %s\n", input, output)
+	return nil
+}
+
+func validateFileEncryptionOptions() error {
+	messages := strings.Builder{}
+
+	input := viper.GetString("input")
+	if input == "" {
+		messages.WriteString("--input Path to the input file must be specified\n")
+	} else if _, errInput := os.Stat(input); os.IsNotExist(errInput) {
+		messages.WriteString(fmt.Sprintf("the specified input (%s) does not exist\n", input))
+	}
+	if !isEncryptionRequested() {
+		messages.WriteString("--encryption-key-file or --encryption-key-env must be specified\n")
+	}
+
+	if messages.String() != "" {
+		return errors.New(messages.String())
+	}
+
+	return nil
+}
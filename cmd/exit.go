@@ -0,0 +1,97 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// exit codes of the process by the kind of the error (see README)
+const exitCodeFailure = 1
+const exitCodeValidation = 2
+const exitCodeEnvironment = 3
+const exitCodeAuth = 4
+const exitCodeTask = 5
+const exitCodeTransfer = 6
+const exitCodeRestore = 7
+const exitCodeTimeout = 124
+const exitCodeInterrupted = 130
+
+func getExitCode(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return exitCodeTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return exitCodeInterrupted
+	}
+	switch client.GetErrorKind(err) {
+	case client.ErrorKindValidation:
+		return exitCodeValidation
+	case client.ErrorKindEnvironment:
+		return exitCodeEnvironment
+	case client.ErrorKindAuth:
+		return exitCodeAuth
+	case client.ErrorKindTask:
+		return exitCodeTask
+	case client.ErrorKindTransfer:
+		return exitCodeTransfer
+	case client.ErrorKindRestore:
+		return exitCodeRestore
+	}
+	return exitCodeFailure
+}
+
+// reportError writes the error of a command to standard error; messages of
+// validation errors span lines and are written as they are
+func reportError(err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.Error("Operation timed out", "timeout", viper.GetDuration("timeout").String(), "exit_code", exitCodeTimeout)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		logger.Error("Operation was interrupted", "exit_code", exitCodeInterrupted)
+		return
+	}
+	kind := client.GetErrorKind(err)
+	if kind == client.ErrorKindValidation {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err.Error()))
+		return
+	}
+	logger.Error(err.Error(), "kind", kind.String(), "exit_code", getExitCode(err))
+}
+
+// invalidOptions shows the help of the command and returns the error as a validation error
+func invalidOptions(cmd *cobra.Command, args []string, err error) error {
+	cmd.HelpFunc()(cmd, args)
+	return client.NewError(client.ErrorKindValidation, err)
+}
+
+// flagError returns errors in parsing flags as validation errors
+func flagError(cmd *cobra.Command, err error) error {
+	fmt.Fprintln(os.Stderr, cmd.UsageString())
+	return client.NewError(client.ErrorKindValidation, err)
+}
+
+func newEnvironmentError(message string) error {
+	return client.NewError(client.ErrorKindEnvironment, errors.New(message))
+}
@@ -0,0 +1,157 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/alexhokl/rds-backup/client/clienttest"
+	"github.com/spf13/viper"
+)
+
+const testBackupContent = "backup"
+
+// setUpFlow resets the configuration to the settings and replaces external
+// commands with a runner which passes the checks of AWS CLI and downloads backups
+func setUpFlow(t *testing.T, settings map[string]interface{}) *clienttest.CommandRunner {
+	viper.Reset()
+	viper.Set("poll-interval", time.Millisecond)
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+
+	runner := clienttest.NewCommandRunner().
+		On("aws help", "", nil).
+		On("aws s3 ls", "", nil).
+		On("aws s3api head-object", `{"ETag":"\"etag\"","ContentLength":6}`, nil).
+		OnFunc("aws s3 cp s3://", downloadTestBackup)
+	client.UseCommandRunner(runner)
+
+	t.Cleanup(func() {
+		client.UseCommandRunner(nil)
+		client.UseSQLClient(nil)
+		viper.Reset()
+	})
+	return runner
+}
+
+// restoreFlow is a flow restoring db.bak of database db which captures the
+// batches run by sqlcmd natively or in containers
+type restoreFlow struct {
+	runner  *clienttest.CommandRunner
+	batches []string
+}
+
+// setUpRestoreFlow sets up a flow restoring db.bak, which is in the download
+// directory, with the settings merged over the ones of the restore; respond
+// returns the output of each batch
+func setUpRestoreFlow(t *testing.T, settings map[string]interface{}, respond func(batch string) (string, error)) *restoreFlow {
+	downloadDirectory := t.TempDir()
+	merged := map[string]interface{}{
+		"database":                 "db",
+		"filename":                 "db.bak",
+		"mdf":                      "Data",
+		"ldf":                      "Log",
+		"download-directory":       downloadDirectory,
+		"restore-server-directory": newServerDirectory(t),
+	}
+	for key, value := range settings {
+		merged[key] = value
+	}
+	if err := os.WriteFile(filepath.Join(downloadDirectory, "db.bak"), []byte(testBackupContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flow := &restoreFlow{runner: setUpFlow(t, merged)}
+	captureBatch := func(command *client.Command) (string, error) {
+		if command.Stdin == nil {
+			return "", nil
+		}
+		batch, err := io.ReadAll(command.Stdin)
+		if err != nil {
+			return "", err
+		}
+		flow.batches = append(flow.batches, string(batch))
+		if respond == nil {
+			return "", nil
+		}
+		return respond(string(batch))
+	}
+	flow.runner.OnFunc("docker", captureBatch).OnFunc("sqlcmd", captureBatch)
+	return flow
+}
+
+// checkBatches checks that each of the expected statements is in a batch
+func (f *restoreFlow) checkBatches(t *testing.T, expected []string) {
+	t.Helper()
+	all := strings.Join(f.batches, "\n")
+	for _, statement := range expected {
+		if !strings.Contains(all, statement) {
+			t.Errorf("expected %s in batches:\n%s", statement, all)
+		}
+	}
+}
+
+// downloadTestBackup writes a backup to the destination of "aws s3 cp", or
+// returns it if it is copied to standard output
+func downloadTestBackup(command *client.Command) (string, error) {
+	destination := command.Args[len(command.Args)-1]
+	if destination == "-" {
+		return testBackupContent, nil
+	}
+	return "", os.WriteFile(destination, []byte(testBackupContent), 0600)
+}
+
+// newServerDirectory returns a directory of a native SQL server with the
+// directory where backups are copied to before restore
+func newServerDirectory(t *testing.T) string {
+	directory := t.TempDir()
+	if err := os.Mkdir(filepath.Join(directory, "Backup"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+// checkFlowResult checks the error of a flow against its expected kind (nil
+// if no error is expected) and that the expected commands have been run
+func checkFlowResult(t *testing.T, err error, expectedKind *client.ErrorKind, runner *clienttest.CommandRunner, expectedCommands []string) {
+	t.Helper()
+	if expectedKind == nil && err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expectedKind != nil {
+		if err == nil {
+			t.Fatalf("expected %s error but got nil", expectedKind)
+		}
+		if kind := client.GetErrorKind(err); kind != *expectedKind {
+			t.Fatalf("expected %s error but got %s: %v", expectedKind, kind, err)
+		}
+	}
+	for _, prefix := range expectedCommands {
+		if runner.Count(prefix) == 0 {
+			t.Errorf("expected command %s in:\n%s", prefix, strings.Join(runner.Commands(), "\n"))
+		}
+	}
+}
+
+func errorKind(kind client.ErrorKind) *client.ErrorKind {
+	return &kind
+}
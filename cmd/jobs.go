@@ -0,0 +1,200 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const jobTypeBackup = "backup"
+const jobTypeRestore = "restore"
+
+const jobStateRunning = "RUNNING"
+const jobStateSuccess = "SUCCESS"
+const jobStateError = "ERROR"
+
+// finished jobs are kept for finishedJobRetention and up to maxFinishedJobs
+// of them so that a long-running server does not keep every job
+const finishedJobRetention = 24 * time.Hour
+const maxFinishedJobs = 100
+
+// errContainerBusy is returned in starting a job onto a container which a
+// running job is restoring onto
+var errContainerBusy = errors.New("Container is being restored by another job")
+
+// job is a long-running operation started via the API
+type job struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	State         string     `json:"state"`
+	DatabaseName  string     `json:"database"`
+	BucketName    string     `json:"bucket,omitempty"`
+	Filename      string     `json:"filename,omitempty"`
+	ContainerName string     `json:"container,omitempty"`
+	TaskID        string     `json:"task_id,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// jobStore keeps the running jobs and the recently finished jobs
+type jobStore struct {
+	ctx     context.Context
+	mutex   sync.Mutex
+	jobs    map[string]*job
+	running sync.WaitGroup
+}
+
+// newJobStore returns a job store whose running jobs are cancelled once the context is done
+func newJobStore(ctx context.Context) *jobStore {
+	return &jobStore{ctx: ctx, jobs: map[string]*job{}}
+}
+
+// start assigns an ID to the job and runs it in the background with a
+// logger carrying the ID of the job; errContainerBusy is returned if the
+// container of the job is being restored onto by a running job
+func (s *jobStore) start(j *job, run func(ctx context.Context, id string, log *slog.Logger) error) (job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return job{}, err
+	}
+
+	s.mutex.Lock()
+	if j.ContainerName != "" && s.isContainerBusy(j.ContainerName) {
+		s.mutex.Unlock()
+		return job{}, errContainerBusy
+	}
+	s.prune(time.Now().UTC())
+	j.ID = id
+	j.State = jobStateRunning
+	j.CreatedAt = time.Now().UTC()
+	s.jobs[id] = j
+	started := *j
+	s.mutex.Unlock()
+
+	log := logger.With("job_id", id, "type", started.Type, "database", started.DatabaseName)
+	log.Info("Job started")
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		ctx, cancel := getOperationContext(s.ctx)
+		defer cancel()
+		errRun := run(ctx, id, log)
+		if errRun != nil {
+			log.Error("Job failed", "error", errRun)
+		} else {
+			log.Info("Job completed")
+		}
+		s.update(id, func(j *job) {
+			finishedAt := time.Now().UTC()
+			j.FinishedAt = &finishedAt
+			j.State = jobStateSuccess
+			if errRun != nil {
+				j.State = jobStateError
+				j.Error = errRun.Error()
+			}
+		})
+	}()
+	return started, nil
+}
+
+func (s *jobStore) update(id string, change func(j *job)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		change(j)
+	}
+}
+
+func (s *jobStore) get(id string) (job, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// list returns all jobs, the most recent first
+func (s *jobStore) list() []job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	jobs := []job{}
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.SliceStable(jobs, func(i, k int) bool {
+		return jobs[i].CreatedAt.After(jobs[k].CreatedAt)
+	})
+	return jobs
+}
+
+// isContainerBusy returns if a running job is restoring onto the specified
+// container; the caller must hold the mutex
+func (s *jobStore) isContainerBusy(containerName string) bool {
+	for _, j := range s.jobs {
+		if j.State == jobStateRunning && j.ContainerName == containerName {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the finished jobs beyond finishedJobRetention and
+// maxFinishedJobs, the oldest first; the caller must hold the mutex
+func (s *jobStore) prune(now time.Time) {
+	finished := []*job{}
+	for id, j := range s.jobs {
+		if j.FinishedAt == nil {
+			continue
+		}
+		if now.Sub(*j.FinishedAt) > finishedJobRetention {
+			delete(s.jobs, id)
+			continue
+		}
+		finished = append(finished, j)
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.SliceStable(finished, func(i, k int) bool {
+		return finished[i].FinishedAt.After(*finished[k].FinishedAt)
+	})
+	for _, j := range finished[maxFinishedJobs:] {
+		delete(s.jobs, j.ID)
+	}
+}
+
+// wait blocks until all running jobs complete
+func (s *jobStore) wait() {
+	s.running.Wait()
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
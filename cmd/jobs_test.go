@@ -0,0 +1,166 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/viper"
+)
+
+func TestJobStoreRejectsBusyContainer(t *testing.T) {
+	store := newJobStore(context.Background())
+	release := make(chan struct{})
+	blocked := func(ctx context.Context, id string, log *slog.Logger) error {
+		<-release
+		return nil
+	}
+
+	results := make(chan error, 10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.start(&job{Type: jobTypeRestore, ContainerName: "orders-dev"}, blocked)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	started := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			started++
+		case !errors.Is(err, errContainerBusy):
+			t.Errorf("expected %v but got %v", errContainerBusy, err)
+		}
+	}
+	if started != 1 {
+		t.Errorf("expected 1 job to be started but got %d", started)
+	}
+	if _, err := store.start(&job{Type: jobTypeRestore, ContainerName: "sales-dev"}, blocked); err != nil {
+		t.Errorf("expected job onto another container to be started but got %v", err)
+	}
+	if _, err := store.start(&job{Type: jobTypeBackup}, blocked); err != nil {
+		t.Errorf("expected backup job to be started but got %v", err)
+	}
+
+	close(release)
+	store.wait()
+	if _, err := store.start(&job{Type: jobTypeRestore, ContainerName: "orders-dev"}, blocked); err != nil {
+		t.Errorf("expected job onto the container to be started once the restore finished but got %v", err)
+	}
+	store.wait()
+}
+
+func TestJobStorePrune(t *testing.T) {
+	store := newJobStore(context.Background())
+	now := time.Now().UTC()
+	store.jobs["running"] = &job{ID: "running", State: jobStateRunning, CreatedAt: now.Add(-48 * time.Hour)}
+	expired := now.Add(-finishedJobRetention - time.Minute)
+	store.jobs["expired"] = &job{ID: "expired", State: jobStateSuccess, FinishedAt: &expired}
+	for i := 0; i <= maxFinishedJobs; i++ {
+		finishedAt := now.Add(-time.Duration(i) * time.Minute)
+		id := fmt.Sprintf("finished-%d", i)
+		store.jobs[id] = &job{ID: id, State: jobStateSuccess, FinishedAt: &finishedAt}
+	}
+
+	store.prune(now)
+
+	if _, ok := store.get("running"); !ok {
+		t.Error("expected running job to be kept")
+	}
+	if _, ok := store.get("expired"); ok {
+		t.Error("expected job finished before the retention to be removed")
+	}
+	if _, ok := store.get(fmt.Sprintf("finished-%d", maxFinishedJobs)); ok {
+		t.Error("expected the oldest finished job beyond the limit to be removed")
+	}
+	if jobs := store.list(); len(jobs) != maxFinishedJobs+1 {
+		t.Errorf("expected %d jobs but got %d", maxFinishedJobs+1, len(jobs))
+	}
+}
+
+func TestHandleBackupsRejectsLockedDatabase(t *testing.T) {
+	viper.Reset()
+	viper.Set("server", "rds.example.com")
+	t.Cleanup(viper.Reset)
+	server := &apiServer{token: "token", jobs: newJobStore(context.Background()), lockDirectory: t.TempDir()}
+	// the database is being backed up by daemon
+	release, err := client.AcquireLock(server.lockDirectory, client.GetDatabaseLockName("rds.example.com", "Orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	request := httptest.NewRequest(http.MethodPost, "/backups", strings.NewReader(`{"database": "Orders", "type": "full"}`))
+	request.Header.Set("Authorization", bearerPrefix+"token")
+	recorder := httptest.NewRecorder()
+
+	server.handler().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("expected status %d but got %d: %s", http.StatusConflict, recorder.Code, recorder.Body.String())
+	}
+	if jobs := server.jobs.list(); len(jobs) != 0 {
+		t.Errorf("expected no jobs but got %d", len(jobs))
+	}
+}
+
+func TestHandleRestores(t *testing.T) {
+	viper.Reset()
+	viper.Set("restore-password", "password")
+	t.Cleanup(viper.Reset)
+	server := &apiServer{token: "token", jobs: newJobStore(context.Background())}
+	server.jobs.jobs["running"] = &job{ID: "running", State: jobStateRunning, ContainerName: "orders-dev"}
+	tests := []struct {
+		name           string
+		token          string
+		body           string
+		expectedStatus int
+	}{
+		{"missing token", "", `{"database": "Orders", "container": "sales-dev"}`, http.StatusUnauthorized},
+		{"invalid request", "token", `{"database": "Orders", "container": "-"}`, http.StatusBadRequest},
+		{"unknown field", "token", `{"database": "Orders", "container": "sales-dev", "size": 1}`, http.StatusBadRequest},
+		{"busy container", "token", `{"database": "Orders", "container": "orders-dev"}`, http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/restores", strings.NewReader(test.body))
+			if test.token != "" {
+				request.Header.Set("Authorization", bearerPrefix+test.token)
+			}
+			recorder := httptest.NewRecorder()
+
+			server.handler().ServeHTTP(recorder, request)
+
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("expected status %d but got %d: %s", test.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/viper"
+)
+
+const logFormatText = "text"
+const logFormatJSON = "json"
+
+// logger receives the logs of the commands and, once logging is configured, of the client package
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// configureLogging sets up the logger as specified by --log-level, --log-format
+// and --log-file; --verbose is a shorthand of --log-level debug
+func configureLogging(isVerbose bool) error {
+	level := slog.LevelInfo
+	if isVerbose {
+		level = slog.LevelDebug
+	} else if errLevel := level.UnmarshalText([]byte(viper.GetString("log-level"))); errLevel != nil {
+		return client.NewError(client.ErrorKindValidation, fmt.Errorf("--log-level %s is not one of debug, info, warn and error", viper.GetString("log-level")))
+	}
+
+	var output io.Writer = os.Stderr
+	if path := viper.GetString("log-file"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return client.NewError(client.ErrorKindEnvironment, err)
+		}
+		output = file
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(viper.GetString("log-format")) {
+	case logFormatText, "logfmt":
+		handler = slog.NewTextHandler(output, options)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(output, options)
+	default:
+		return client.NewError(client.ErrorKindValidation, fmt.Errorf("--log-format %s is not one of %s and %s", viper.GetString("log-format"), logFormatText, logFormatJSON))
+	}
+
+	logger = slog.New(handler)
+	client.UseLogger(logger)
+	return nil
+}
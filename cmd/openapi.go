@@ -0,0 +1,205 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+// openAPIDescription describes the API served by the serve command
+const openAPIDescription = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "rds-backup",
+    "description": "Takes, lists and restores backups of MSSQL servers on AWS RDS",
+    "version": "1.0.0"
+  },
+  "security": [{ "bearerAuth": [] }],
+  "paths": {
+    "/backups": {
+      "get": {
+        "summary": "Lists the backups of a database in the bucket, the most recent first",
+        "parameters": [
+          { "name": "database", "in": "query", "required": true, "schema": { "type": "string" } }
+        ],
+        "responses": {
+          "200": {
+            "description": "Backups",
+            "content": { "application/json": { "schema": { "type": "array", "items": { "$ref": "#/components/schemas/Backup" } } } }
+          },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "502": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "post": {
+        "summary": "Starts a backup job",
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/BackupRequest" } } }
+        },
+        "responses": {
+          "202": { "$ref": "#/components/responses/Job" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/tasks": {
+      "get": {
+        "summary": "Gets the status of a RDS backup task of a database",
+        "parameters": [
+          { "name": "database", "in": "query", "required": true, "schema": { "type": "string" } },
+          { "name": "task_id", "in": "query", "description": "The most recent task if not specified", "schema": { "type": "string" } }
+        ],
+        "responses": {
+          "200": {
+            "description": "Task status",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/TaskStatus" } } }
+          },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "502": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/restores": {
+      "post": {
+        "summary": "Starts a job restoring a backup onto a new Docker container",
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/RestoreRequest" } } }
+        },
+        "responses": {
+          "202": { "$ref": "#/components/responses/Job" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" },
+          "501": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "summary": "Gets metrics of the operations run by the server in Prometheus text exposition format",
+        "responses": {
+          "200": { "description": "Metrics", "content": { "text/plain": { "schema": { "type": "string" } } } },
+          "401": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/jobs": {
+      "get": {
+        "summary": "Lists the running jobs and the jobs finished in the last 24 hours (up to 100), the most recent first",
+        "responses": {
+          "200": {
+            "description": "Jobs",
+            "content": { "application/json": { "schema": { "type": "array", "items": { "$ref": "#/components/schemas/Job" } } } }
+          },
+          "401": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/jobs/{id}": {
+      "get": {
+        "summary": "Gets a job",
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }
+        ],
+        "responses": {
+          "200": {
+            "description": "Job",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Job" } } }
+          },
+          "401": { "$ref": "#/components/responses/Error" },
+          "404": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": { "type": "http", "scheme": "bearer" }
+    },
+    "responses": {
+      "Job": {
+        "description": "Job started",
+        "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Job" } } }
+      },
+      "Error": {
+        "description": "Error",
+        "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Error" } } }
+      }
+    },
+    "schemas": {
+      "Backup": {
+        "type": "object",
+        "properties": {
+          "filename": { "type": "string" },
+          "size": { "type": "integer", "format": "int64" },
+          "last_modified": { "type": "string", "format": "date-time" }
+        }
+      },
+      "BackupRequest": {
+        "type": "object",
+        "required": ["database"],
+        "properties": {
+          "database": { "type": "string" },
+          "type": { "type": "string", "enum": ["full", "diff"], "default": "full" }
+        }
+      },
+      "RestoreRequest": {
+        "type": "object",
+        "required": ["database", "container"],
+        "properties": {
+          "database": { "type": "string" },
+          "filename": { "type": "string", "default": "latest", "description": "File name of the backup in the bucket or latest for the most recent backup" },
+          "container": { "type": "string", "description": "Name of the Docker container to be created" },
+          "port": { "type": "integer", "default": 1433 },
+          "mdf": { "type": "string", "description": "Logical name of data, taken from the source database if not specified" },
+          "ldf": { "type": "string", "description": "Logical name of log, taken from the source database if not specified" }
+        }
+      },
+      "TaskStatus": {
+        "type": "object",
+        "properties": {
+          "database": { "type": "string" },
+          "task_id": { "type": "string" },
+          "status": { "type": "string" }
+        }
+      },
+      "Job": {
+        "type": "object",
+        "properties": {
+          "id": { "type": "string" },
+          "type": { "type": "string", "enum": ["backup", "restore"] },
+          "state": { "type": "string", "enum": ["RUNNING", "SUCCESS", "ERROR"] },
+          "database": { "type": "string" },
+          "bucket": { "type": "string" },
+          "filename": { "type": "string" },
+          "container": { "type": "string" },
+          "task_id": { "type": "string" },
+          "error": { "type": "string" },
+          "created_at": { "type": "string", "format": "date-time" },
+          "finished_at": { "type": "string", "format": "date-time" }
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": { "type": "string" }
+        }
+      }
+    }
+  }
+}
+`
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/alexhokl/rds-backup/client"
 	"github.com/spf13/cobra"
@@ -9,6 +13,9 @@ import (
 	"github.com/spf13/viper"
 )
 
+// defaultPollInterval is the default interval of polling the status of backup tasks
+const defaultPollInterval = 5 * time.Second
+
 type basicOptions struct {
 	verbose      bool
 	databaseName string
@@ -17,6 +24,10 @@ type basicOptions struct {
 type nativeRestoreOptions struct {
 	restoreDataDirectory               string
 	restoreServerInstallationDirectory string
+	restoreServer                      string
+	restoreUsername                    string
+	restoreShare                       string
+	restoreShareServerPath             string
 }
 
 type dockerRestoreOptions struct {
@@ -29,6 +40,10 @@ type basicBackupOptions struct {
 	filename string
 }
 
+type backupSelectionOptions struct {
+	isLatest bool
+}
+
 type basicRestoreOptions struct {
 	restoreDatabaseName string
 	dataName            string
@@ -36,6 +51,25 @@ type basicRestoreOptions struct {
 	isNative            bool
 }
 
+type urlRestoreOptions struct {
+	fromURL    bool
+	s3Endpoint string
+	s3Region   string
+}
+
+type maskOptions struct {
+	maskProfile string
+}
+
+type postRestoreOptions struct {
+	postRestoreDirectory string
+}
+
+type orphanedUserOptions struct {
+	userLogins     map[string]string
+	loginPasswords map[string]string
+}
+
 type basicDownloadOptions struct {
 	bucketName string
 }
@@ -44,12 +78,60 @@ type localDownloadOptions struct {
 	downloadDirectory string
 }
 
+type cacheOptions struct {
+	cacheDirectory string
+	cacheSize      int64
+}
+
+type replicationOptions struct {
+	destinationRegion        string
+	kmsKeyID                 string
+	isBucketOwnerFullControl bool
+}
+
+type awsOptions struct {
+	roleArn    string
+	externalID string
+	mfaSerial  string
+}
+
+type encryptionOptions struct {
+	encryptionKeyFile string
+	encryptionKeyEnv  string
+}
+
+type fileEncryptionOptions struct {
+	encryptionOptions
+	input  string
+	output string
+}
+
+type pollOptions struct {
+	pollInterval time.Duration
+}
+
+type metricsOptions struct {
+	metricsTextfile string
+}
+
+type sqlClientOptions struct {
+	sqlClient string
+}
+
 type serverOptions struct {
+	sqlClientOptions
 	server         string
 	serverUsername string
 	serverPassword string
 }
 
+type doctorOptions struct {
+	basicOptions
+	serverOptions
+	basicDownloadOptions
+	awsOptions
+}
+
 type statusOptions struct {
 	basicOptions
 	serverOptions
@@ -60,8 +142,18 @@ type restoreOptions struct {
 	nativeRestoreOptions
 	dockerRestoreOptions
 	basicBackupOptions
+	backupSelectionOptions
 	basicRestoreOptions
+	urlRestoreOptions
+	maskOptions
+	postRestoreOptions
+	orphanedUserOptions
+	basicDownloadOptions
 	localDownloadOptions
+	cacheOptions
+	awsOptions
+	encryptionOptions
+	metricsOptions
 }
 
 type downloadOptions struct {
@@ -70,33 +162,92 @@ type downloadOptions struct {
 	nativeRestoreOptions
 	dockerRestoreOptions
 	basicBackupOptions
+	backupSelectionOptions
+	maskOptions
+	postRestoreOptions
+	orphanedUserOptions
 	basicDownloadOptions
 	localDownloadOptions
+	cacheOptions
+	awsOptions
+	encryptionOptions
+	metricsOptions
 	isRestore bool
 }
 
+type copyOptions struct {
+	basicOptions
+	basicBackupOptions
+	basicDownloadOptions
+	replicationOptions
+	awsOptions
+	sourceRegion string
+	destination  string
+}
+
 type createOptions struct {
 	basicOptions
 	nativeRestoreOptions
 	dockerRestoreOptions
 	basicBackupOptions
 	serverOptions
+	maskOptions
+	postRestoreOptions
+	orphanedUserOptions
 	basicDownloadOptions
 	localDownloadOptions
+	cacheOptions
+	replicationOptions
+	awsOptions
+	encryptionOptions
+	metricsOptions
+	pollOptions
+	replicateTo         string
 	isNative            bool
 	isDownload          bool
 	isWaitForCompletion bool
 	isRestore           bool
 }
 
+type daemonOptions struct {
+	pollOptions
+	sqlClientOptions
+	verbose        bool
+	stateDirectory string
+	metricsListen  string
+}
+
+type serveOptions struct {
+	verbose bool
+	serverOptions
+	pollOptions
+	maskOptions
+	postRestoreOptions
+	orphanedUserOptions
+	basicDownloadOptions
+	cacheOptions
+	awsOptions
+	encryptionOptions
+	restorePassword string
+	stateDirectory  string
+	listen          string
+	apiToken        string
+	tlsCert         string
+	tlsKey          string
+}
+
 func bindBasicOptions(flags *pflag.FlagSet, opts *basicOptions) {
-	flags.BoolVarP(&opts.verbose, "verbose", "v", false, "Verbose mode")
+	flags.BoolVarP(&opts.verbose, "verbose", "v", false, "Verbose mode (same as --log-level debug)")
 	flags.StringVarP(&opts.databaseName, "database", "d", "", "Name of database")
 }
 
 func bindNativeRestoreOptions(flags *pflag.FlagSet, opts *nativeRestoreOptions) {
-	flags.StringVar(&opts.restoreDataDirectory, "restore-data-directory", "", "Path to the directory where MDF and LDF files to be located")
-	flags.StringVar(&opts.restoreServerInstallationDirectory, "restore-server-directory", client.DefaultServerInstallationPath, "Path to the directory the native SQL server")
+	flags.StringVar(&opts.restoreDataDirectory, "restore-data-directory", "", "Path on the native SQL server to the directory where MDF and LDF files to be located (the default data and log directories of the server if not specified)")
+	flags.StringVar(&opts.restoreServerInstallationDirectory, "restore-server-directory", "", "Path to the installation directory of the native SQL server containing Backup, DATA and LOG (discovered from the server if not specified)")
+	flags.StringVar(&opts.restoreServer, "restore-server", "", "Native SQL server to restore onto (the default instance on this machine if not specified)")
+	flags.StringVar(&opts.restoreUsername, "restore-username", "", "Login name of the native SQL server with --restore-password (Windows authentication if not specified)")
+	flags.StringVar(&opts.restoreShare, "restore-share", "", "Path on this machine to a directory the native SQL server can read, to copy backups to before restore (the backup directory of the server if not specified)")
+	flags.StringVar(&opts.restoreShareServerPath, "restore-share-server-path", "", "Path of --restore-share as seen by the native SQL server (such as \\\\fileserver\\backups)")
 }
 
 func bindDockerRestoreOptions(flags *pflag.FlagSet, opts *dockerRestoreOptions) {
@@ -106,7 +257,11 @@ func bindDockerRestoreOptions(flags *pflag.FlagSet, opts *dockerRestoreOptions)
 }
 
 func bindBasicBackupOptions(flags *pflag.FlagSet, opts *basicBackupOptions) {
-	flags.StringVarP(&opts.filename, "filename", "f", "", "File name of the backup")
+	flags.StringVarP(&opts.filename, "filename", "f", "", fmt.Sprintf("File name of the backup (use '%s' for the most recent backup of the database)", client.LatestFilename))
+}
+
+func bindBackupSelectionOptions(flags *pflag.FlagSet, opts *backupSelectionOptions) {
+	flags.BoolVar(&opts.isLatest, "latest", false, "Use the most recent backup of the database instead of --filename")
 }
 
 func bindBasicRestoreOptions(flags *pflag.FlagSet, opts *basicRestoreOptions) {
@@ -116,6 +271,25 @@ func bindBasicRestoreOptions(flags *pflag.FlagSet, opts *basicRestoreOptions) {
 	flags.StringVarP(&opts.logName, "ldf", "l", "", "Logical name of log")
 }
 
+func bindURLRestoreOptions(flags *pflag.FlagSet, opts *urlRestoreOptions) {
+	flags.BoolVar(&opts.fromURL, "from-url", false, "Restore straight from the S3 bucket with RESTORE FROM URL (SQL Server 2022 or later) instead of a downloaded backup; static access keys are required")
+	flags.StringVar(&opts.s3Endpoint, "s3-endpoint", "", "Host (and port) of an S3-compatible service to restore from with --from-url")
+	flags.StringVar(&opts.s3Region, "s3-region", "", "Region of the bucket to restore from with --from-url (looked up if not specified)")
+}
+
+func bindMaskOptions(flags *pflag.FlagSet, opts *maskOptions) {
+	flags.StringVar(&opts.maskProfile, "mask-profile", "", "Path to the YAML profile of the columns to be masked once restored")
+}
+
+func bindPostRestoreOptions(flags *pflag.FlagSet, opts *postRestoreOptions) {
+	flags.StringVar(&opts.postRestoreDirectory, "post-restore", "", "Path to the directory of .sql and .sh files to be run in the order of their names once restored")
+}
+
+func bindOrphanedUserOptions(flags *pflag.FlagSet, opts *orphanedUserOptions) {
+	flags.StringToStringVar(&opts.userLogins, "user-login", nil, "Logins to remap orphaned users of the restored database to (user=login)")
+	flags.StringToStringVar(&opts.loginPasswords, "login-password", nil, "Passwords of the logins to be created for orphaned users of the restored database (user=password or a reference to it such as env://VARIABLE)")
+}
+
 func bindBasicDownloadOptions(flags *pflag.FlagSet, opts *basicDownloadOptions) {
 	flags.StringVarP(&opts.bucketName, "bucket", "b", "", "Bucket name")
 }
@@ -124,7 +298,48 @@ func bindLocalDownloadOptions(flags *pflag.FlagSet, opts *localDownloadOptions)
 	flags.StringVar(&opts.downloadDirectory, "download-directory", "", "Path to the directory where backup from AWS S3 located")
 }
 
+func bindCacheOptions(flags *pflag.FlagSet, opts *cacheOptions) {
+	flags.StringVar(&opts.cacheDirectory, "cache-directory", client.DefaultCacheDirectory(), "Path to the directory where backups are cached if --download-directory is not specified")
+	flags.Int64Var(&opts.cacheSize, "cache-size", client.DefaultCacheSize, "Size limit of the backup cache in megabytes")
+}
+
+func bindReplicationOptions(flags *pflag.FlagSet, opts *replicationOptions) {
+	flags.StringVar(&opts.destinationRegion, "destination-region", "", "AWS region of the destination bucket")
+	flags.StringVar(&opts.kmsKeyID, "kms-key-id", "", "AWS KMS key to encrypt the copy of the backup with")
+	flags.BoolVar(&opts.isBucketOwnerFullControl, "bucket-owner-full-control", false, "Grant the owner of the destination bucket full control of the copy")
+}
+
+func bindAwsOptions(flags *pflag.FlagSet, opts *awsOptions) {
+	flags.StringVar(&opts.roleArn, "role-arn", "", "ARN of the AWS IAM role to be assumed in AWS S3 operations")
+	flags.StringVar(&opts.externalID, "external-id", "", "External ID in assuming the role")
+	flags.StringVar(&opts.mfaSerial, "mfa-serial", "", "Serial number (or ARN) of the MFA device in assuming the role")
+}
+
+func bindEncryptionOptions(flags *pflag.FlagSet, opts *encryptionOptions) {
+	flags.StringVar(&opts.encryptionKeyFile, "encryption-key-file", "", "Path to a file containing a base64 encoded 256-bit key to keep downloaded backups encrypted with")
+	flags.StringVar(&opts.encryptionKeyEnv, "encryption-key-env", "", "Name of the environment variable containing a base64 encoded 256-bit key to keep downloaded backups encrypted with")
+}
+
+func bindFileEncryptionOptions(flags *pflag.FlagSet, opts *fileEncryptionOptions) {
+	bindEncryptionOptions(flags, &opts.encryptionOptions)
+	flags.StringVarP(&opts.input, "input", "i", "", "Path to the input file")
+	flags.StringVarP(&opts.output, "output", "o", "", "Path to the output file")
+}
+
+func bindPollOptions(flags *pflag.FlagSet, opts *pollOptions) {
+	flags.DurationVar(&opts.pollInterval, "poll-interval", defaultPollInterval, "Interval of polling the status of backup tasks")
+}
+
+func bindMetricsOptions(flags *pflag.FlagSet, opts *metricsOptions) {
+	flags.StringVar(&opts.metricsTextfile, "metrics-textfile", "", "Path to the file to write Prometheus metrics to (for the textfile collector of node_exporter)")
+}
+
+func bindSQLClientOptions(flags *pflag.FlagSet, opts *sqlClientOptions) {
+	flags.StringVar(&opts.sqlClient, "sql-client", "", "SQL client to run queries with (native, docker or go); detected if not specified")
+}
+
 func bindServerOptions(flags *pflag.FlagSet, opts *serverOptions) {
+	bindSQLClientOptions(flags, &opts.sqlClientOptions)
 	flags.StringVarP(&opts.server, "server", "s", "", "Source SQL server")
 	flags.StringVarP(&opts.serverUsername, "username", "u", "", "Source SQL server login name")
 	flags.StringVarP(&opts.serverPassword, "password", "p", "", "Source SQL server login password")
@@ -135,13 +350,30 @@ func bindStatusOptions(flags *pflag.FlagSet, opts *statusOptions) {
 	bindServerOptions(flags, &opts.serverOptions)
 }
 
+func bindDoctorOptions(flags *pflag.FlagSet, opts *doctorOptions) {
+	bindBasicOptions(flags, &opts.basicOptions)
+	bindServerOptions(flags, &opts.serverOptions)
+	bindBasicDownloadOptions(flags, &opts.basicDownloadOptions)
+	bindAwsOptions(flags, &opts.awsOptions)
+}
+
 func bindRestoreOptions(flags *pflag.FlagSet, opts *restoreOptions) {
 	bindBasicOptions(flags, &opts.basicOptions)
 	bindNativeRestoreOptions(flags, &opts.nativeRestoreOptions)
 	bindDockerRestoreOptions(flags, &opts.dockerRestoreOptions)
 	bindBasicBackupOptions(flags, &opts.basicBackupOptions)
+	bindBackupSelectionOptions(flags, &opts.backupSelectionOptions)
 	bindBasicRestoreOptions(flags, &opts.basicRestoreOptions)
+	bindURLRestoreOptions(flags, &opts.urlRestoreOptions)
+	bindMaskOptions(flags, &opts.maskOptions)
+	bindPostRestoreOptions(flags, &opts.postRestoreOptions)
+	bindOrphanedUserOptions(flags, &opts.orphanedUserOptions)
+	bindBasicDownloadOptions(flags, &opts.basicDownloadOptions)
 	bindLocalDownloadOptions(flags, &opts.localDownloadOptions)
+	bindCacheOptions(flags, &opts.cacheOptions)
+	bindAwsOptions(flags, &opts.awsOptions)
+	bindEncryptionOptions(flags, &opts.encryptionOptions)
+	bindMetricsOptions(flags, &opts.metricsOptions)
 }
 
 func bindDownloadOptions(flags *pflag.FlagSet, opts *downloadOptions) {
@@ -150,25 +382,80 @@ func bindDownloadOptions(flags *pflag.FlagSet, opts *downloadOptions) {
 	bindNativeRestoreOptions(flags, &opts.nativeRestoreOptions)
 	bindDockerRestoreOptions(flags, &opts.dockerRestoreOptions)
 	bindBasicBackupOptions(flags, &opts.basicBackupOptions)
+	bindBackupSelectionOptions(flags, &opts.backupSelectionOptions)
+	bindMaskOptions(flags, &opts.maskOptions)
+	bindPostRestoreOptions(flags, &opts.postRestoreOptions)
+	bindOrphanedUserOptions(flags, &opts.orphanedUserOptions)
 	bindBasicDownloadOptions(flags, &opts.basicDownloadOptions)
 	bindLocalDownloadOptions(flags, &opts.localDownloadOptions)
+	bindCacheOptions(flags, &opts.cacheOptions)
+	bindAwsOptions(flags, &opts.awsOptions)
+	bindEncryptionOptions(flags, &opts.encryptionOptions)
+	bindMetricsOptions(flags, &opts.metricsOptions)
 	flags.BoolVarP(&opts.isRestore, "restore", "r", false, "Restore backup in a docker container")
 }
 
+func bindCopyOptions(flags *pflag.FlagSet, opts *copyOptions) {
+	bindBasicOptions(flags, &opts.basicOptions)
+	bindBasicBackupOptions(flags, &opts.basicBackupOptions)
+	bindBasicDownloadOptions(flags, &opts.basicDownloadOptions)
+	bindReplicationOptions(flags, &opts.replicationOptions)
+	bindAwsOptions(flags, &opts.awsOptions)
+	flags.StringVar(&opts.sourceRegion, "source-region", "", "AWS region of the source bucket")
+	flags.StringVar(&opts.destination, "destination", "", "S3 URI of the destination (s3://bucket/prefix)")
+}
+
 func bindCreateOptions(flags *pflag.FlagSet, opts *createOptions) {
 	bindBasicOptions(flags, &opts.basicOptions)
 	bindNativeRestoreOptions(flags, &opts.nativeRestoreOptions)
 	bindDockerRestoreOptions(flags, &opts.dockerRestoreOptions)
 	bindBasicBackupOptions(flags, &opts.basicBackupOptions)
 	bindServerOptions(flags, &opts.serverOptions)
+	bindMaskOptions(flags, &opts.maskOptions)
+	bindPostRestoreOptions(flags, &opts.postRestoreOptions)
+	bindOrphanedUserOptions(flags, &opts.orphanedUserOptions)
 	bindBasicDownloadOptions(flags, &opts.basicDownloadOptions)
 	bindLocalDownloadOptions(flags, &opts.localDownloadOptions)
+	bindCacheOptions(flags, &opts.cacheOptions)
+	bindReplicationOptions(flags, &opts.replicationOptions)
+	bindAwsOptions(flags, &opts.awsOptions)
+	bindEncryptionOptions(flags, &opts.encryptionOptions)
+	bindMetricsOptions(flags, &opts.metricsOptions)
+	bindPollOptions(flags, &opts.pollOptions)
+	flags.StringVar(&opts.replicateTo, "replicate-to", "", "S3 URI (s3://bucket/prefix) to copy the backup to once it is completed")
 	flags.BoolVarP(&opts.isNative, "native", "n", false, "Restore to local native SQL server")
 	flags.BoolVarP(&opts.isWaitForCompletion, "wait", "w", false, "Wait for backup to complete")
 	flags.BoolVar(&opts.isDownload, "download", false, "Create and download the backup")
 	flags.BoolVarP(&opts.isRestore, "restore", "r", false, "Restore backup in a docker container")
 }
 
+func bindDaemonOptions(flags *pflag.FlagSet, opts *daemonOptions) {
+	flags.BoolVarP(&opts.verbose, "verbose", "v", false, "Verbose mode (same as --log-level debug)")
+	flags.StringVar(&opts.stateDirectory, "state-directory", getDefaultStateDirectory(), "Path to the directory where run history and locks are kept")
+	flags.StringVar(&opts.metricsListen, "metrics-listen", "", "Address to serve Prometheus metrics on /metrics (such as :9399)")
+	bindPollOptions(flags, &opts.pollOptions)
+	bindSQLClientOptions(flags, &opts.sqlClientOptions)
+}
+
+func bindServeOptions(flags *pflag.FlagSet, opts *serveOptions) {
+	flags.BoolVarP(&opts.verbose, "verbose", "v", false, "Verbose mode (same as --log-level debug)")
+	bindServerOptions(flags, &opts.serverOptions)
+	bindMaskOptions(flags, &opts.maskOptions)
+	bindPostRestoreOptions(flags, &opts.postRestoreOptions)
+	bindOrphanedUserOptions(flags, &opts.orphanedUserOptions)
+	bindBasicDownloadOptions(flags, &opts.basicDownloadOptions)
+	bindCacheOptions(flags, &opts.cacheOptions)
+	bindAwsOptions(flags, &opts.awsOptions)
+	bindEncryptionOptions(flags, &opts.encryptionOptions)
+	bindPollOptions(flags, &opts.pollOptions)
+	flags.StringVar(&opts.restorePassword, "restore-password", "", "Password of the MSSQL server in the containers to be created by restores")
+	flags.StringVar(&opts.stateDirectory, "state-directory", getDefaultStateDirectory(), "Path to the directory where locks shared with daemon are kept")
+	flags.StringVar(&opts.listen, "listen", ":8080", "Address to listen on")
+	flags.StringVar(&opts.apiToken, "api-token", "", "Bearer token to authenticate requests with (or a reference to it such as env://VARIABLE)")
+	flags.StringVar(&opts.tlsCert, "tls-cert", "", "Path to the TLS certificate to serve HTTPS with")
+	flags.StringVar(&opts.tlsKey, "tls-key", "", "Path to the private key of the TLS certificate")
+}
+
 func bindConfiguration(cmd *cobra.Command) {
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
 		viper.BindPFlag(f.Name, f)
@@ -177,6 +464,257 @@ func bindConfiguration(cmd *cobra.Command) {
 
 func dumpParameters(cmd *cobra.Command) {
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
-		fmt.Printf("%s: %s\n", f.Name, viper.GetString(f.Name))
+		logger.Debug("Parameter", "name", f.Name, "value", getMaskedValue(f.Name, viper.GetString(f.Name)))
+	})
+}
+
+// writeMetricsTextfile writes the metrics of this run to --metrics-textfile, if specified
+func writeMetricsTextfile() {
+	path := viper.GetString("metrics-textfile")
+	if path == "" {
+		return
+	}
+	if err := client.WriteMetricsFile(path); err != nil {
+		logger.Warn("Unable to write metrics", "path", path, "error", err)
+	}
+}
+
+func isLatestBackupRequested() bool {
+	return viper.GetBool("latest") || viper.GetString("filename") == client.LatestFilename
+}
+
+// configureAwsCredentials assumes the role of --role-arn if it is specified;
+// it is called before resolvePasswords as passwords can be secrets of AWS
+// Secrets Manager readable only by the role
+func configureAwsCredentials(ctx context.Context) error {
+	if viper.GetString("role-arn") == "" {
+		return nil
+	}
+	return client.AssumeRole(ctx, &client.AssumeRoleParameters{
+		RoleArn:    viper.GetString("role-arn"),
+		ExternalID: viper.GetString("external-id"),
+		MfaSerial:  viper.GetString("mfa-serial"),
 	})
 }
+
+func validatePollOptions(messages *strings.Builder) {
+	if viper.GetDuration("poll-interval") < time.Second {
+		messages.WriteString("--poll-interval must be at least 1s\n")
+	}
+}
+
+func validateSQLClientOptions(messages *strings.Builder) {
+	name := viper.GetString("sql-client")
+	if name == "" {
+		return
+	}
+	for _, n := range client.SQLClientNames {
+		if name == n {
+			return
+		}
+	}
+	messages.WriteString(fmt.Sprintf("--sql-client must be one of %s\n", strings.Join(client.SQLClientNames, ", ")))
+}
+
+// getNativeRestoreParameters returns the parameters of a restore onto the
+// native SQL server specified by the options
+func getNativeRestoreParameters(basicRestoreParameters client.BaseRestoreParameters) *client.NativeRestoreParameters {
+	return &client.NativeRestoreParameters{
+		BaseRestoreParameters: basicRestoreParameters,
+		Server:                viper.GetString("restore-server"),
+		Username:              viper.GetString("restore-username"),
+		Password:              viper.GetString("restore-password"),
+		CustomDataPath:        viper.GetString("restore-data-directory"),
+		ServerPath:            viper.GetString("restore-server-directory"),
+		SharePath:             viper.GetString("restore-share"),
+		ShareServerPath:       viper.GetString("restore-share-server-path"),
+	}
+}
+
+func validateURLRestoreOptions(messages *strings.Builder) {
+	if !viper.GetBool("from-url") {
+		if viper.GetString("s3-endpoint") != "" || viper.GetString("s3-region") != "" {
+			messages.WriteString("--s3-endpoint and --s3-region cannot be used without --from-url\n")
+		}
+		return
+	}
+	if viper.GetString("bucket") == "" {
+		messages.WriteString("--bucket must be specified with --from-url\n")
+	}
+	if isEncryptionRequested() {
+		messages.WriteString("--from-url cannot be used with encrypted backups\n")
+	}
+	// SQL server accepts only access keys without session tokens, which
+	// assumed roles never have
+	if viper.GetString("role-arn") != "" || viper.GetString("mfa-serial") != "" {
+		messages.WriteString("--role-arn and --mfa-serial cannot be used with --from-url as it requires static access keys\n")
+	}
+	validateAwsOptions(messages)
+}
+
+func validateMaskOptions(messages *strings.Builder) {
+	if _, err := getMaskProfile(); err != nil {
+		messages.WriteString(fmt.Sprintf("--mask-profile %s\n", err.Error()))
+	}
+}
+
+func validatePostRestoreOptions(messages *strings.Builder) {
+	directory := viper.GetString("post-restore")
+	if directory == "" {
+		return
+	}
+	if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+		messages.WriteString(fmt.Sprintf("the specified post-restore directory (%s) does not exist\n", directory))
+	}
+}
+
+func validateOrphanedUserOptions(messages *strings.Builder) {
+	loginPasswords := viper.GetStringMapString("login-password")
+	for user := range viper.GetStringMapString("user-login") {
+		if _, ok := loginPasswords[user]; ok {
+			messages.WriteString(fmt.Sprintf("--user-login and --login-password cannot be both specified for user %s\n", user))
+		}
+	}
+}
+
+// getLoginPasswords returns the passwords of --login-password with their
+// references resolved
+func getLoginPasswords(ctx context.Context) (map[string]string, error) {
+	passwords := map[string]string{}
+	for user, value := range viper.GetStringMapString("login-password") {
+		password, err := client.ResolvePassword(ctx, value)
+		if err != nil {
+			return nil, err
+		}
+		passwords[user] = password
+	}
+	return passwords, nil
+}
+
+// getMaskProfile returns the profile of --mask-profile, or nil if it is not specified
+func getMaskProfile() (*client.MaskProfile, error) {
+	path := viper.GetString("mask-profile")
+	if path == "" {
+		return nil, nil
+	}
+	return client.LoadMaskProfile(path)
+}
+
+func validateNativeRestoreOptions(messages *strings.Builder) {
+	if viper.GetInt("port") != client.DefaultServerPort {
+		messages.WriteString("--port Port cannot be used in restoring to local native SQL server\n")
+	}
+	restoreServerDirectory := viper.GetString("restore-server-directory")
+	if restoreServerDirectory != "" && viper.GetString("restore-server") == "" {
+		if _, errServerDirectory := os.Stat(restoreServerDirectory); os.IsNotExist(errServerDirectory) {
+			messages.WriteString("the specified restore-server-directory does not exist\n")
+		}
+	}
+	restoreShare := viper.GetString("restore-share")
+	if restoreShare != "" {
+		if _, errShare := os.Stat(restoreShare); os.IsNotExist(errShare) {
+			messages.WriteString("the specified restore-share does not exist\n")
+		}
+	} else {
+		if viper.GetString("restore-share-server-path") != "" {
+			messages.WriteString("--restore-share-server-path cannot be used without --restore-share\n")
+		}
+		// the backup is copied to the backup directory of the server on this machine
+		if !client.IsLocalServer(viper.GetString("restore-server")) {
+			messages.WriteString("--restore-share is required when --restore-server is not this machine\n")
+		}
+	}
+	if viper.GetString("restore-username") != "" && viper.GetString("restore-password") == "" {
+		messages.WriteString("--restore-password must be specified with --restore-username\n")
+	}
+}
+
+// getSQLClient returns the SQL client selected by --sql-client or the one
+// detected on this machine if it is not specified
+func getSQLClient(ctx context.Context) (client.SQLClient, error) {
+	return client.GetClient(ctx, viper.GetString("sql-client"))
+}
+
+// getOperationContext returns the context of an operation which is cancelled
+// on SIGINT or SIGTERM (via the context of the command) or once --timeout has elapsed
+func getOperationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := viper.GetDuration("timeout"); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func validateAwsOptions(messages *strings.Builder) {
+	if viper.GetString("role-arn") == "" {
+		if viper.GetString("external-id") != "" {
+			messages.WriteString("--external-id cannot be used without --role-arn\n")
+		}
+		if viper.GetString("mfa-serial") != "" {
+			messages.WriteString("--mfa-serial cannot be used without --role-arn\n")
+		}
+	}
+}
+
+// validateUnattendedAwsOptions rejects options requiring input on a terminal
+// as commands such as daemon assume the role again (without a terminal to
+// enter MFA codes on) once its credentials expire
+func validateUnattendedAwsOptions(messages *strings.Builder) {
+	if viper.GetString("mfa-serial") != "" {
+		messages.WriteString("--mfa-serial cannot be used with daemon or serve as MFA codes cannot be entered in renewing credentials of the role\n")
+	}
+}
+
+func isEncryptionRequested() bool {
+	return viper.GetString("encryption-key-file") != "" || viper.GetString("encryption-key-env") != ""
+}
+
+func getEncryptionKey() ([]byte, error) {
+	return client.ReadEncryptionKey(viper.GetString("encryption-key-file"), viper.GetString("encryption-key-env"))
+}
+
+func configureEncryption() error {
+	if !isEncryptionRequested() {
+		return nil
+	}
+	key, err := getEncryptionKey()
+	if err != nil {
+		return err
+	}
+	return client.UseEncryptionKey(key)
+}
+
+// configureNotifications sets up the notifiers in the notifications setting
+func configureNotifications(ctx context.Context) error {
+	configs := []client.NotifierConfig{}
+	if err := viper.UnmarshalKey(notificationsKey, &configs); err != nil {
+		return client.NewError(client.ErrorKindValidation, fmt.Errorf("Invalid %s: %s", notificationsKey, err.Error()))
+	}
+	for i := range configs {
+		if configs[i].SMTPPassword == "" {
+			continue
+		}
+		password, err := client.ResolvePassword(ctx, configs[i].SMTPPassword)
+		if err != nil {
+			return err
+		}
+		configs[i].SMTPPassword = password
+	}
+	return client.NewError(client.ErrorKindValidation, client.UseNotifiers(configs))
+}
+
+// resolvePasswords replaces references to secrets (such as ARNs of AWS Secrets
+// Manager secrets, file:// and env://) in password settings with the passwords
+func resolvePasswords(ctx context.Context) error {
+	for _, key := range []string{"password", "restore-password"} {
+		value := viper.GetString(key)
+		if value == "" {
+			continue
+		}
+		password, err := client.ResolvePassword(ctx, value)
+		if err != nil {
+			return err
+		}
+		viper.Set(key, password)
+	}
+	return nil
+}
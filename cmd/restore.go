@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -32,22 +33,20 @@ func init() {
 		Use:   "restore",
 		Short: "Restores the specified backup in a docker container",
 		Long:  "Restores the specified backup in a docker container",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			bindConfiguration(cmd)
-			viper.Set("verbose", opts.verbose)
-			if viper.GetBool("verbose") {
-				dumpParameters(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
 			}
-			errOpt := validateRestoreOptions()
-			if errOpt != nil {
-				fmt.Println(errOpt.Error())
-				cmd.HelpFunc()(cmd, args)
-				return
-			}
-			err := runRestore()
-			if err != nil {
-				fmt.Println(err.Error())
+			dumpParameters(cmd)
+			if errOpt := validateRestoreOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
 			}
+			ctx, cancel := getOperationContext(cmd.Context())
+			defer cancel()
+			err := runRestore(ctx)
+			writeMetricsTextfile()
+			return err
 		},
 	}
 
@@ -57,22 +56,71 @@ func init() {
 	RootCmd.AddCommand(restoreCmd)
 }
 
-func runRestore() error {
+func runRestore(ctx context.Context) error {
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+	if errPassword := resolvePasswords(ctx); errPassword != nil {
+		return errPassword
+	}
+	if errEncryption := configureEncryption(); errEncryption != nil {
+		return errEncryption
+	}
+	if errNotifications := configureNotifications(ctx); errNotifications != nil {
+		return errNotifications
+	}
+
+	var source *client.S3Source
+	if viper.GetBool("from-url") {
+		source = &client.S3Source{
+			BucketName: viper.GetString("bucket"),
+			Endpoint:   viper.GetString("s3-endpoint"),
+			Region:     viper.GetString("s3-region"),
+		}
+	}
+
+	if isLatestBackupRequested() {
+		var filename string
+		var errLatest error
+		if source != nil {
+			filename, errLatest = client.GetLatestBackupFilename(ctx, source.BucketName, viper.GetString("database"))
+		} else {
+			filename, errLatest = client.GetLatestLocalBackupFilename(viper.GetString("download-directory"), viper.GetString("cache-directory"), viper.GetString("bucket"), viper.GetString("database"))
+		}
+		if errLatest != nil {
+			return errLatest
+		}
+		logger.Info("Resolved the latest backup", "database", viper.GetString("database"), "filename", filename)
+		viper.Set("filename", filename)
+	}
+
+	maskProfile, errProfile := getMaskProfile()
+	if errProfile != nil {
+		return errProfile
+	}
+	loginPasswords, errPasswords := getLoginPasswords(ctx)
+	if errPasswords != nil {
+		return errPasswords
+	}
+
 	basicRestoreParameters := client.BaseRestoreParameters{
-		Filename:          viper.GetString("filename"),
-		DatabaseName:      viper.GetString("database"),
-		DataName:          viper.GetString("mdf"),
-		LogName:           viper.GetString("ldf"),
-		DownloadDirectory: viper.GetString("download-directory"),
+		BucketName:           viper.GetString("bucket"),
+		Filename:             viper.GetString("filename"),
+		DatabaseName:         viper.GetString("database"),
+		DataName:             viper.GetString("mdf"),
+		LogName:              viper.GetString("ldf"),
+		DownloadDirectory:    viper.GetString("download-directory"),
+		CacheDirectory:       viper.GetString("cache-directory"),
+		Source:               source,
+		MaskProfile:          maskProfile,
+		PostRestoreDirectory: viper.GetString("post-restore"),
+		UserLogins:           viper.GetStringMapString("user-login"),
+		LoginPasswords:       loginPasswords,
 	}
 
 	if viper.GetBool("native") {
-		nativeParameters := &client.NativeRestoreParameters{
-			BaseRestoreParameters: basicRestoreParameters,
-			CustomDataPath:        viper.GetString("restore-data-directory"),
-			ServerPath:            viper.GetString("restore-server-directory"),
-		}
-		errNative := client.RestoreNative(nativeParameters)
+		nativeParameters := getNativeRestoreParameters(basicRestoreParameters)
+		errNative := client.RestoreNative(ctx, nativeParameters)
 		if errNative != nil {
 			return errNative
 		}
@@ -84,7 +132,7 @@ func runRestore() error {
 		Password:              viper.GetString("restore-password"),
 		Port:                  viper.GetInt("port"),
 	}
-	errRestore := client.Restore(restoreParameters)
+	errRestore := client.Restore(ctx, restoreParameters)
 	if errRestore != nil {
 		return errRestore
 	}
@@ -94,19 +142,11 @@ func runRestore() error {
 func validateRestoreOptions() error {
 	messages := strings.Builder{}
 
-	if viper.GetString("filename") == "" {
+	if !isLatestBackupRequested() && viper.GetString("filename") == "" {
 		messages.WriteString("--filename Filename must be specified\n")
 	}
 	if viper.GetBool("native") {
-		if viper.GetInt("port") != client.DefaultServerPort {
-			messages.WriteString("--port Port cannot be used in restoring to local native SQL server\n")
-		}
-		restoreServerDirectory := viper.GetString("restore-server-directory")
-		if restoreServerDirectory != "" {
-			if _, errServerDirectory := os.Stat(restoreServerDirectory); os.IsNotExist(errServerDirectory) {
-				messages.WriteString("the specified restore-server-directory does not exist\n")
-			}
-		}
+		validateNativeRestoreOptions(&messages)
 	} else {
 		if viper.GetString("container") == "" {
 			messages.WriteString("--container Container name must be specified\n")
@@ -120,7 +160,17 @@ func validateRestoreOptions() error {
 		if viper.GetString("restore-data-directory") != "" {
 			messages.WriteString("--restore-data-directory cannot be used in Docker container restore\n")
 		}
+		if viper.GetString("restore-server") != "" {
+			messages.WriteString("--restore-server cannot be used in Docker container restore\n")
+		}
+		if viper.GetString("restore-share") != "" {
+			messages.WriteString("--restore-share cannot be used in Docker container restore\n")
+		}
 	}
+	validateURLRestoreOptions(&messages)
+	validateMaskOptions(&messages)
+	validatePostRestoreOptions(&messages)
+	validateOrphanedUserOptions(&messages)
 	if viper.GetString("database") == "" {
 		messages.WriteString("--database Name of database must be specified\n")
 	}
@@ -0,0 +1,444 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/rds-backup/client"
+)
+
+func TestRunRestore(t *testing.T) {
+	tests := []struct {
+		name             string
+		settings         map[string]interface{}
+		commands         map[string]error
+		withoutBackup    bool
+		serverPaths      string
+		share            string
+		expectedKind     *client.ErrorKind
+		expectedCommands []string
+		expectedPaths    []string
+	}{
+		{
+			name:             "restore in Docker",
+			settings:         map[string]interface{}{"container": "restored", "restore-password": "Passw0rd"},
+			expectedCommands: []string{"docker run --name restored", "docker exec -e SQLCMDPASSWORD restored /opt/mssql-tools/bin/sqlcmd -S . -U sa -Q SELECT 1", "docker exec -t -e SQLCMDPASSWORD restored"},
+		},
+		{
+			name:         "Docker Content Trust is enabled",
+			settings:     map[string]interface{}{"container": "restored", "restore-password": "Passw0rd"},
+			commands:     map[string]error{"docker run": errors.New("exit status 125")},
+			expectedKind: errorKind(client.ErrorKindEnvironment),
+		},
+		{
+			name:             "restore natively",
+			settings:         map[string]interface{}{"native": true},
+			expectedCommands: []string{"sqlcmd -b -x -Q"},
+		},
+		{
+			name:             "restore natively with paths of server",
+			settings:         map[string]interface{}{"native": true, "restore-server-directory": ""},
+			serverPaths:      "C:\\MSSQL\\DATA\\|D:\\MSSQL\\LOG|%s/Backup\n",
+			expectedCommands: []string{"sqlcmd -b -r 1 -h -1 -W -s | -x -Q", "sqlcmd -b -x -Q"},
+			expectedPaths:    []string{"/Backup/db.bak", "C:\\MSSQL\\DATA\\db.mdf", "D:\\MSSQL\\LOG\\db.ldf"},
+		},
+		{
+			name:             "restore natively onto remote server via share",
+			settings:         map[string]interface{}{"native": true, "restore-server": "sql01", "restore-username": "sa", "restore-password": "Passw0rd", "restore-server-directory": ""},
+			serverPaths:      "/var/opt/mssql/data|/var/opt/mssql/log|/var/opt/mssql/backup\n",
+			share:            "\\\\fileserver\\backups",
+			expectedCommands: []string{"sqlcmd -S sql01 -U sa -b -r 1", "sqlcmd -S sql01 -U sa -b -x -Q"},
+			expectedPaths:    []string{"\\\\fileserver\\backups\\db.bak", "/var/opt/mssql/data/db.mdf", "/var/opt/mssql/log/db.ldf"},
+		},
+		{
+			name:             "paths of server are not found",
+			settings:         map[string]interface{}{"native": true, "restore-server-directory": ""},
+			serverPaths:      "NULL|NULL|NULL\n",
+			expectedKind:     errorKind(client.ErrorKindRestore),
+			expectedCommands: []string{"sqlcmd -b -r 1"},
+		},
+		{
+			name:             "native restore fails",
+			settings:         map[string]interface{}{"native": true},
+			commands:         map[string]error{"sqlcmd": errors.New("exit status 1")},
+			expectedKind:     errorKind(client.ErrorKindRestore),
+			expectedCommands: []string{"sqlcmd -b -x -Q"},
+		},
+		{
+			name:          "backup does not exist",
+			settings:      map[string]interface{}{"native": true},
+			withoutBackup: true,
+			expectedKind:  errorKind(client.ErrorKindRestore),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			downloadDirectory := t.TempDir()
+			serverDirectory := newServerDirectory(t)
+			settings := map[string]interface{}{
+				"database":                 "db",
+				"filename":                 "db.bak",
+				"mdf":                      "Data",
+				"ldf":                      "Log",
+				"download-directory":       downloadDirectory,
+				"restore-server-directory": serverDirectory,
+			}
+			shareDirectory := t.TempDir()
+			if test.share != "" {
+				settings["restore-share"] = shareDirectory
+				settings["restore-share-server-path"] = test.share
+			}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			runner := setUpFlow(t, settings)
+			runner.On("docker", "", nil).On("sqlcmd", "", nil)
+			if test.serverPaths != "" {
+				runner.On("sqlcmd -b -r 1", fmt.Sprintf(test.serverPaths, serverDirectory), nil)
+				runner.On("sqlcmd -S sql01 -U sa -b -r 1", fmt.Sprintf(test.serverPaths, serverDirectory), nil)
+			}
+			for prefix, err := range test.commands {
+				runner.On(prefix, "", err)
+			}
+			if !test.withoutBackup {
+				if err := os.WriteFile(filepath.Join(downloadDirectory, "db.bak"), []byte(testBackupContent), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := runRestore(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, runner, test.expectedCommands)
+			for _, directory := range []string{filepath.Join(serverDirectory, "Backup"), shareDirectory} {
+				if copies, _ := filepath.Glob(filepath.Join(directory, "*")); len(copies) > 0 {
+					t.Errorf("expected copies of backup on server to be removed but got %v", copies)
+				}
+			}
+			commands := strings.Join(runner.Commands(), "\n")
+			for _, path := range test.expectedPaths {
+				if !strings.Contains(commands, path) {
+					t.Errorf("expected path %s in:\n%s", path, commands)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRestoreFromURL(t *testing.T) {
+	accessKey := `{"Version":1,"AccessKeyId":"AKIAEXAMPLE","SecretAccessKey":"wJalrXUtnFEMI/K7MDENG"}`
+	tests := []struct {
+		name             string
+		settings         map[string]interface{}
+		credentials      string
+		expectedKind     *client.ErrorKind
+		expectedCommands []string
+		expectedBatches  []string
+	}{
+		{
+			name:             "restore in Docker",
+			settings:         map[string]interface{}{"container": "restored", "restore-password": "Passw0rd", "port": client.DefaultServerPort, "s3-region": "ap-southeast-1"},
+			credentials:      accessKey,
+			expectedCommands: []string{"docker run --name restored -p 1433:1433 -e SA_PASSWORD -e ACCEPT_EULA=Y -d " + client.SQLServer2022Image, "docker exec -i -e SQLCMDPASSWORD restored /opt/mssql-tools18/bin/sqlcmd -C -S . -U sa -b -r 1"},
+			expectedBatches:  []string{"CREATE CREDENTIAL", "N'AKIAEXAMPLE:wJalrXUtnFEMI/K7MDENG'", "N's3://bucket.s3.ap-southeast-1.amazonaws.com/db.bak'", `RESTORE_OPTIONS='{"s3": {"region":"ap-southeast-1"}}'`, "N'/var/opt/mssql/data/db.mdf'", "DROP CREDENTIAL"},
+		},
+		{
+			name:             "restore natively with region of bucket",
+			settings:         map[string]interface{}{"native": true},
+			credentials:      accessKey,
+			expectedCommands: []string{"aws s3api get-bucket-location --bucket bucket", "sqlcmd -b -r 1"},
+			expectedBatches:  []string{"N's3://bucket.s3.us-east-1.amazonaws.com/db.bak'", "\\db.mdf'", "DROP CREDENTIAL"},
+		},
+		{
+			name:             "restore from S3-compatible endpoint",
+			settings:         map[string]interface{}{"native": true, "s3-endpoint": "minio.local:9000"},
+			credentials:      accessKey,
+			expectedCommands: []string{"sqlcmd -b -r 1"},
+			expectedBatches:  []string{"N's3://minio.local:9000/bucket'", "N's3://minio.local:9000/bucket/db.bak'", "DROP CREDENTIAL"},
+		},
+		{
+			name:         "credentials are temporary",
+			settings:     map[string]interface{}{"native": true, "s3-region": "ap-southeast-1"},
+			credentials:  `{"Version":1,"AccessKeyId":"ASIAEXAMPLE","SecretAccessKey":"secret","SessionToken":"token"}`,
+			expectedKind: errorKind(client.ErrorKindAuth),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverDirectory := newServerDirectory(t)
+			settings := map[string]interface{}{
+				"bucket":                   "bucket",
+				"from-url":                 true,
+				"download-directory":       t.TempDir(),
+				"restore-server-directory": "C:\\MSSQL",
+			}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			flow := setUpRestoreFlow(t, settings, nil)
+			flow.runner.
+				On("aws configure export-credentials", test.credentials, nil).
+				On("aws s3api get-bucket-location", "None\n", nil)
+
+			err := runRestore(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, flow.runner, test.expectedCommands)
+			if copies, _ := filepath.Glob(filepath.Join(serverDirectory, "Backup", "*")); len(copies) > 0 {
+				t.Errorf("expected no copies of backup on server but got %v", copies)
+			}
+			flow.checkBatches(t, test.expectedBatches)
+			if test.expectedKind != nil && len(flow.batches) > 0 {
+				t.Errorf("expected no batches but got:\n%s", strings.Join(flow.batches, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateURLRestoreOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      map[string]interface{}
+		expectedError string
+	}{
+		{
+			name:     "access keys",
+			settings: map[string]interface{}{"from-url": true, "bucket": "bucket"},
+		},
+		{
+			name:          "role",
+			settings:      map[string]interface{}{"from-url": true, "bucket": "bucket", "role-arn": "arn:aws:iam::123456789012:role/restore"},
+			expectedError: "--role-arn and --mfa-serial cannot be used with --from-url",
+		},
+		{
+			name:          "MFA",
+			settings:      map[string]interface{}{"from-url": true, "bucket": "bucket", "mfa-serial": "arn:aws:iam::123456789012:mfa/admin"},
+			expectedError: "--role-arn and --mfa-serial cannot be used with --from-url",
+		},
+		{
+			name:     "role without --from-url",
+			settings: map[string]interface{}{"role-arn": "arn:aws:iam::123456789012:role/restore"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setUpFlow(t, test.settings)
+			messages := strings.Builder{}
+
+			validateURLRestoreOptions(&messages)
+
+			if test.expectedError == "" && messages.String() != "" {
+				t.Errorf("expected no error but got %s", messages.String())
+			}
+			if !strings.Contains(messages.String(), test.expectedError) {
+				t.Errorf("expected error containing %q but got %q", test.expectedError, messages.String())
+			}
+		})
+	}
+}
+
+func TestValidateNativeRestoreOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      map[string]interface{}
+		expectedError string
+	}{
+		{
+			name:     "local server",
+			settings: map[string]interface{}{"restore-server": "localhost\\SQLEXPRESS"},
+		},
+		{
+			name:     "remote server via share",
+			settings: map[string]interface{}{"restore-server": "sql01.example.com", "restore-share": os.TempDir()},
+		},
+		{
+			name:          "remote server without share",
+			settings:      map[string]interface{}{"restore-server": "sql01.example.com"},
+			expectedError: "--restore-share is required when --restore-server is not this machine",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.settings["port"] = client.DefaultServerPort
+			setUpFlow(t, test.settings)
+			messages := strings.Builder{}
+
+			validateNativeRestoreOptions(&messages)
+
+			if test.expectedError == "" && messages.String() != "" {
+				t.Errorf("expected no error but got %s", messages.String())
+			}
+			if !strings.Contains(messages.String(), test.expectedError) {
+				t.Errorf("expected error containing %q but got %q", test.expectedError, messages.String())
+			}
+		})
+	}
+}
+
+func TestRunRestoreWithMaskProfile(t *testing.T) {
+	tests := []struct {
+		name            string
+		settings        map[string]interface{}
+		unmasked        string
+		expectedKind    *client.ErrorKind
+		expectedBatches []string
+	}{
+		{
+			name:            "mask in Docker",
+			settings:        map[string]interface{}{"container": "restored", "restore-password": "Passw0rd"},
+			unmasked:        "[dbo].[Customers]|[Email]|0\n",
+			expectedBatches: []string{"USE [db]", "UPDATE TOP (@batch_size) [dbo].[Customers] SET [Email]", "UNION ALL"},
+		},
+		{
+			name:            "unmasked rows remain after native restore",
+			settings:        map[string]interface{}{"native": true},
+			unmasked:        "[dbo].[Customers]|[Email]|2\n",
+			expectedKind:    errorKind(client.ErrorKindRestore),
+			expectedBatches: []string{"DROP DATABASE [db]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile := filepath.Join(t.TempDir(), "masking.yaml")
+			content := "tables:\n  - table: dbo.Customers\n    columns:\n      - column: Email\n        rule: hash_email\n      - column: Phone\n        rule: nullify\n"
+			if err := os.WriteFile(profile, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			settings := map[string]interface{}{"mask-profile": profile}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			flow := setUpRestoreFlow(t, settings, func(batch string) (string, error) {
+				if strings.Contains(batch, "UNION ALL") {
+					return test.unmasked, nil
+				}
+				return "0\n", nil
+			})
+
+			err := runRestore(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, flow.runner, nil)
+			flow.checkBatches(t, test.expectedBatches)
+		})
+	}
+}
+
+func TestRunRestoreWithPostRestore(t *testing.T) {
+	tests := []struct {
+		name                string
+		settings            map[string]interface{}
+		failingBatch        string
+		expectedKind        *client.ErrorKind
+		expectedBatches     []string
+		expectedEnvironment []string
+		expectedHooks       int
+	}{
+		{
+			name:                "run scripts and hooks in Docker",
+			settings:            map[string]interface{}{"container": "restored", "restore-password": "Passw0rd", "port": 1533},
+			expectedBatches:     []string{"USE [db];\nGO\nALTER DATABASE CURRENT SET RECOVERY SIMPLE;", "USE [db];\nGO\nCREATE PROCEDURE dbo.Cleanup AS SELECT 1;", "USE [db];\nGO\nDBCC SHRINKFILE (Log, 1);"},
+			expectedEnvironment: []string{"RDS_BACKUP_DATABASE=db", "RDS_BACKUP_SERVER=localhost,1533", "RDS_BACKUP_CONTAINER=restored", "RDS_BACKUP_USERNAME=sa", "SQLCMDPASSWORD=Passw0rd"},
+			expectedHooks:       1,
+		},
+		{
+			name:                "run scripts and hooks natively",
+			settings:            map[string]interface{}{"native": true},
+			expectedBatches:     []string{"USE [db];\nGO\nALTER DATABASE CURRENT SET RECOVERY SIMPLE;"},
+			expectedEnvironment: []string{"RDS_BACKUP_HOST=localhost", "RDS_BACKUP_PORT=1433", "RDS_BACKUP_CONTAINER="},
+			expectedHooks:       1,
+		},
+		{
+			name:         "script fails",
+			settings:     map[string]interface{}{"native": true},
+			failingBatch: "SHRINKFILE",
+			expectedKind: errorKind(client.ErrorKindRestore),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			postRestoreDirectory := t.TempDir()
+			files := map[string]string{
+				"01-recovery.sql": "ALTER DATABASE CURRENT SET RECOVERY SIMPLE;\nGO\nCREATE PROCEDURE dbo.Cleanup AS SELECT 1;\nGO\nDBCC SHRINKFILE (Log, 1);\n",
+				"02-logins.sh":    "sqlcmd -S \"$RDS_BACKUP_SERVER\" -U \"$RDS_BACKUP_USERNAME\" -i logins.sql\n",
+				"README.md":       "not run",
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(postRestoreDirectory, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			settings := map[string]interface{}{"post-restore": postRestoreDirectory}
+			for key, value := range test.settings {
+				settings[key] = value
+			}
+			flow := setUpRestoreFlow(t, settings, func(batch string) (string, error) {
+				if test.failingBatch != "" && strings.Contains(batch, test.failingBatch) {
+					return "", errors.New("exit status 1")
+				}
+				return "", nil
+			})
+			var environment []string
+			flow.runner.OnFunc("sh", func(command *client.Command) (string, error) {
+				environment = command.Environment
+				return "", nil
+			})
+
+			err := runRestore(context.Background())
+
+			checkFlowResult(t, err, test.expectedKind, flow.runner, nil)
+			flow.checkBatches(t, test.expectedBatches)
+			variables := strings.Join(environment, "\n")
+			for _, expected := range test.expectedEnvironment {
+				if !strings.Contains(variables, expected) {
+					t.Errorf("expected %s in environment:\n%s", expected, variables)
+				}
+			}
+			if count := flow.runner.Count("sh "); count != test.expectedHooks {
+				t.Errorf("expected %d hooks to be run but got %d", test.expectedHooks, count)
+			}
+		})
+	}
+}
+
+func TestRunRestoreFixesOrphanedUsers(t *testing.T) {
+	t.Setenv("REPORT_PASSWORD", "S3cret!")
+	flow := setUpRestoreFlow(t, map[string]interface{}{
+		"container":        "restored",
+		"restore-password": "Passw0rd",
+		"user-login":       map[string]string{"app": "sa"},
+		"login-password":   map[string]string{"report": "env://REPORT_PASSWORD"},
+	}, func(batch string) (string, error) {
+		if strings.Contains(batch, "sys.database_principals") {
+			return "app\nreport\n", nil
+		}
+		return "created\n", nil
+	})
+
+	err := runRestore(context.Background())
+
+	checkFlowResult(t, err, nil, flow.runner, nil)
+	flow.checkBatches(t, []string{"@login_name NVARCHAR(4000) = N'sa'", "@password NVARCHAR(4000) = N'S3cret!'"})
+}
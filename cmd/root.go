@@ -15,8 +15,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alexhokl/helper/cli"
 	"github.com/spf13/cobra"
@@ -32,27 +35,44 @@ var RootCmd = &cobra.Command{
 
 			For documentation or bug report, please visit
 			https://github.com/alexhokl/rds-backup/`,
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The process exits with a code of the kind of the error if a command fails.
+// Operations are cancelled on SIGINT or SIGTERM.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := RootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		reportError(err)
+		os.Exit(getExitCode(err))
 	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	RootCmd.SetFlagErrorFunc(flagError)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.rds-backup.yaml)")
+	RootCmd.PersistentFlags().StringVar(&profileName, "profile", "", fmt.Sprintf("profile in the config file (default is $%s)", profileEnvironmentVariable))
+	RootCmd.PersistentFlags().String("log-level", "info", "Level of logs (debug, info, warn or error)")
+	RootCmd.PersistentFlags().String("log-format", logFormatText, "Format of logs (text or json)")
+	RootCmd.PersistentFlags().String("log-file", "", "Path to the file to append logs to instead of standard error")
+	RootCmd.PersistentFlags().Duration("timeout", 0, "Time limit of an operation such as 2h; of each backup or job in daemon and serve (no limit by default)")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	cli.ConfigureViper(cfgFile, "rds-backup", true, "")
+	if err := applyProfile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCodeValidation)
+	}
 }
@@ -0,0 +1,538 @@
+// Copyright © 2017 Alex Ho <alexhokl@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const bearerPrefix = "Bearer "
+
+// containerNamePattern is the pattern of names Docker accepts for containers
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+type apiServer struct {
+	token  string
+	client client.SQLClient
+	jobs   *jobStore
+	// lockDirectory keeps the locks of databases being backed up, which are
+	// shared with daemon
+	lockDirectory string
+}
+
+type startBackupRequest struct {
+	DatabaseName string `json:"database"`
+	Type         string `json:"type"`
+}
+
+type startRestoreRequest struct {
+	DatabaseName  string `json:"database"`
+	Filename      string `json:"filename"`
+	ContainerName string `json:"container"`
+	Port          int    `json:"port"`
+	DataName      string `json:"mdf"`
+	LogName       string `json:"ldf"`
+}
+
+type taskStatus struct {
+	DatabaseName string `json:"database"`
+	TaskID       string `json:"task_id,omitempty"`
+	Status       string `json:"status"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func init() {
+	opts := serveOptions{}
+
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serves a REST API to take, list and restore backups",
+		Long: `Serves a REST API to take, list and restore backups
+
+			Requests have to be authenticated with header "Authorization: Bearer <api-token>".
+			The OpenAPI description of the API is served at /openapi.json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bindConfiguration(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
+			}
+			dumpParameters(cmd)
+			if errOpt := validateServeOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
+			}
+			return runServe(cmd.Context())
+		},
+	}
+
+	flags := serveCmd.Flags()
+	bindServeOptions(flags, &opts)
+
+	RootCmd.AddCommand(serveCmd)
+}
+
+func runServe(ctx context.Context) error {
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+	if errPassword := resolvePasswords(ctx); errPassword != nil {
+		return errPassword
+	}
+	token, errToken := client.ResolvePassword(ctx, viper.GetString("api-token"))
+	if errToken != nil {
+		return errToken
+	}
+	if errEncryption := configureEncryption(); errEncryption != nil {
+		return errEncryption
+	}
+	if errNotifications := configureNotifications(ctx); errNotifications != nil {
+		return errNotifications
+	}
+	if !client.IsAwsCliInstalled(ctx) {
+		return newEnvironmentError("AWS CLI is required")
+	}
+	if !client.IsAwsCredentialsConfigured(ctx) {
+		return newEnvironmentError("AWS CLI credentials are not configured yet. Please try 'aws configure'")
+	}
+	c, errClient := getSQLClient(ctx)
+	if errClient != nil {
+		return errClient
+	}
+
+	server := &apiServer{
+		token:         token,
+		client:        c,
+		jobs:          newJobStore(ctx),
+		lockDirectory: filepath.Join(viper.GetString("state-directory"), "locks"),
+	}
+	httpServer := &http.Server{
+		Addr:              viper.GetString("listen"),
+		Handler:           server.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errServe := make(chan error, 1)
+	go func() {
+		logger.Info("Serving API", "address", httpServer.Addr)
+		if viper.GetString("tls-cert") != "" {
+			errServe <- httpServer.ListenAndServeTLS(viper.GetString("tls-cert"), viper.GetString("tls-key"))
+			return
+		}
+		errServe <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errServe:
+		return err
+	case <-ctx.Done():
+		logger.Info("Cancelling running jobs")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errShutdown := httpServer.Shutdown(shutdownCtx)
+	server.jobs.wait()
+	return errShutdown
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	mux.Handle("/metrics", s.authenticate(handleMetrics))
+	mux.Handle("/backups", s.authenticate(s.handleBackups))
+	mux.Handle("/tasks", s.authenticate(s.handleTasks))
+	mux.Handle("/restores", s.authenticate(s.handleRestores))
+	mux.Handle("/jobs", s.authenticate(s.handleJobs))
+	mux.Handle("/jobs/", s.authenticate(s.handleJob))
+	return mux
+}
+
+func (s *apiServer) authenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, bearerPrefix)
+		if !strings.HasPrefix(header, bearerPrefix) || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rds-backup"`)
+			writeError(w, http.StatusUnauthorized, errors.New("Missing or invalid bearer token"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *apiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPIDescription))
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	client.WriteMetrics(w)
+}
+
+func (s *apiServer) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listBackups(w, r)
+	case http.MethodPost:
+		s.startBackup(w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *apiServer) listBackups(w http.ResponseWriter, r *http.Request) {
+	databaseName := r.URL.Query().Get("database")
+	if databaseName == "" {
+		writeError(w, http.StatusBadRequest, errors.New("database must be specified"))
+		return
+	}
+	backups, err := client.ListBackups(r.Context(), viper.GetString("bucket"), databaseName)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+func (s *apiServer) startBackup(w http.ResponseWriter, r *http.Request) {
+	request := startBackupRequest{}
+	if err := readJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.DatabaseName == "" {
+		writeError(w, http.StatusBadRequest, errors.New("database must be specified"))
+		return
+	}
+	backupType, errType := getBackupType(request.Type)
+	if errType != nil {
+		writeError(w, http.StatusBadRequest, errType)
+		return
+	}
+
+	params := &client.BackupParameters{
+		DatabaseParameters: s.getDatabaseParameters(request.DatabaseName),
+		BucketName:         viper.GetString("bucket"),
+		Filename:           client.GetBackupFilename(request.DatabaseName, backupType, time.Now()),
+		Type:               backupType,
+	}
+	release, errLock := client.AcquireLock(s.lockDirectory, client.GetDatabaseLockName(params.Server, params.DatabaseName))
+	if errLock != nil {
+		writeError(w, http.StatusConflict, errLock)
+		return
+	}
+	started, err := s.jobs.start(
+		&job{
+			Type:         jobTypeBackup,
+			DatabaseName: params.DatabaseName,
+			BucketName:   params.BucketName,
+			Filename:     params.Filename,
+		},
+		func(ctx context.Context, id string, log *slog.Logger) error {
+			defer release()
+			taskID, errBackup := startBackup(ctx, s.client, params)
+			if errBackup != nil {
+				return errBackup
+			}
+			s.jobs.update(id, func(j *job) { j.TaskID = taskID })
+			return isBackupCompleted(ctx, s.client, params, taskID, log.With("task_id", taskID))
+		},
+	)
+	if err != nil {
+		release()
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, started)
+}
+
+func (s *apiServer) handleTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	databaseName := r.URL.Query().Get("database")
+	if databaseName == "" {
+		writeError(w, http.StatusBadRequest, errors.New("database must be specified"))
+		return
+	}
+	taskID := r.URL.Query().Get("task_id")
+	params := s.getDatabaseParameters(databaseName)
+	status, err := s.client.GetStatus(r.Context(), &params, taskID)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, taskStatus{
+		DatabaseName: databaseName,
+		TaskID:       taskID,
+		Status:       status,
+	})
+}
+
+func (s *apiServer) handleRestores(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if viper.GetString("restore-password") == "" {
+		writeError(w, http.StatusNotImplemented, errors.New("restore-password is not configured on the server"))
+		return
+	}
+	request := startRestoreRequest{}
+	if err := readJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errRequest := validateRestoreRequest(&request); errRequest != nil {
+		writeError(w, http.StatusBadRequest, errRequest)
+		return
+	}
+
+	bucketName := viper.GetString("bucket")
+	started, err := s.jobs.start(
+		&job{
+			Type:          jobTypeRestore,
+			DatabaseName:  request.DatabaseName,
+			BucketName:    bucketName,
+			Filename:      request.Filename,
+			ContainerName: request.ContainerName,
+		},
+		func(ctx context.Context, id string, log *slog.Logger) error {
+			return s.restore(ctx, id, bucketName, &request, log)
+		},
+	)
+	if errors.Is(err, errContainerBusy) {
+		writeError(w, http.StatusConflict, fmt.Errorf("Container %s is being restored by another job", request.ContainerName))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, started)
+}
+
+func (s *apiServer) restore(ctx context.Context, id string, bucketName string, request *startRestoreRequest, log *slog.Logger) error {
+	filename := request.Filename
+	if filename == client.LatestFilename {
+		latestFilename, errLatest := client.GetLatestBackupFilename(ctx, bucketName, request.DatabaseName)
+		if errLatest != nil {
+			return errLatest
+		}
+		filename = latestFilename
+		s.jobs.update(id, func(j *job) { j.Filename = filename })
+		log.Info("Resolved the latest backup", "filename", filename)
+	}
+
+	dataName := request.DataName
+	logName := request.LogName
+	if dataName == "" || logName == "" {
+		params := s.getDatabaseParameters(request.DatabaseName)
+		sourceDataName, sourceLogName, errLogicalNames := s.client.GetLogicalNames(ctx, &params)
+		if errLogicalNames != nil {
+			return errLogicalNames
+		}
+		dataName = sourceDataName
+		logName = sourceLogName
+	}
+
+	downloadDirectory, errDownload := downloadBackup(ctx, bucketName, filename)
+	if errDownload != nil {
+		return errDownload
+	}
+	maskProfile, errProfile := getMaskProfile()
+	if errProfile != nil {
+		return errProfile
+	}
+	loginPasswords, errPasswords := getLoginPasswords(ctx)
+	if errPasswords != nil {
+		return errPasswords
+	}
+
+	return client.Restore(ctx, &client.RestoreParameters{
+		BaseRestoreParameters: client.BaseRestoreParameters{
+			BucketName:           bucketName,
+			Filename:             filename,
+			DatabaseName:         request.DatabaseName,
+			DataName:             dataName,
+			LogName:              logName,
+			DownloadDirectory:    downloadDirectory,
+			MaskProfile:          maskProfile,
+			PostRestoreDirectory: viper.GetString("post-restore"),
+			UserLogins:           viper.GetStringMapString("user-login"),
+			LoginPasswords:       loginPasswords,
+		},
+		ContainerName: request.ContainerName,
+		Password:      viper.GetString("restore-password"),
+		Port:          request.Port,
+	})
+}
+
+func (s *apiServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	j, ok := s.jobs.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Job %s is not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *apiServer) getDatabaseParameters(databaseName string) client.DatabaseParameters {
+	return client.DatabaseParameters{
+		Server:       viper.GetString("server"),
+		Username:     viper.GetString("username"),
+		Password:     viper.GetString("password"),
+		DatabaseName: databaseName,
+	}
+}
+
+func getBackupType(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", "full":
+		return client.BackupTypeFull, nil
+	case "diff", "differential":
+		return client.BackupTypeDifferential, nil
+	}
+	return "", fmt.Errorf("Unknown backup type %s (expected full or diff)", value)
+}
+
+func validateRestoreRequest(request *startRestoreRequest) error {
+	messages := strings.Builder{}
+
+	if request.DatabaseName == "" || strings.ContainsAny(request.DatabaseName, `/\`) {
+		messages.WriteString("database must be specified without path separators\n")
+	}
+	if request.Filename == "" {
+		request.Filename = client.LatestFilename
+	}
+	if strings.ContainsAny(request.Filename, `/\`) || strings.HasPrefix(request.Filename, ".") {
+		messages.WriteString("filename must be a file name in the bucket\n")
+	}
+	if !containerNamePattern.MatchString(request.ContainerName) {
+		messages.WriteString("container must be a valid Docker container name\n")
+	}
+	if request.Port == 0 {
+		request.Port = client.DefaultServerPort
+	}
+	if request.Port < 0 || request.Port > 65535 {
+		messages.WriteString("port must be between 1 and 65535\n")
+	}
+
+	if messages.String() != "" {
+		return errors.New(strings.TrimSpace(messages.String()))
+	}
+	return nil
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("Invalid request body: %s", err.Error())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, apiError{Error: err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+}
+
+func validateServeOptions() error {
+	messages := strings.Builder{}
+
+	if viper.GetString("api-token") == "" {
+		messages.WriteString("--api-token Token to authenticate requests with must be specified\n")
+	}
+	if viper.GetString("server") == "" {
+		messages.WriteString("--server AWS RDS SQL server must be specified\n")
+	}
+	if viper.GetString("username") == "" {
+		messages.WriteString("--username AWS RDS SQL server login name must be specified\n")
+	}
+	if viper.GetString("password") == "" {
+		messages.WriteString("--password AWS RDS SQL server login password must be specified\n")
+	}
+	if viper.GetString("bucket") == "" {
+		messages.WriteString("--bucket AWS S3 Bucket must be specified\n")
+	}
+	if viper.GetString("state-directory") == "" {
+		messages.WriteString("--state-directory must be specified\n")
+	}
+	if (viper.GetString("tls-cert") == "") != (viper.GetString("tls-key") == "") {
+		messages.WriteString("--tls-cert and --tls-key must be specified together\n")
+	}
+	validateAwsOptions(&messages)
+	validateUnattendedAwsOptions(&messages)
+	validatePollOptions(&messages)
+	validateSQLClientOptions(&messages)
+	validateMaskOptions(&messages)
+	validatePostRestoreOptions(&messages)
+	validateOrphanedUserOptions(&messages)
+
+	if messages.String() != "" {
+		return errors.New(messages.String())
+	}
+
+	return nil
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -32,22 +33,18 @@ func init() {
 		Use:   "status",
 		Short: "Show the status of the latest backup",
 		Long:  "Show the status of the latest backup",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			bindConfiguration(cmd)
-			viper.Set("verbose", opts.verbose)
-			if viper.GetBool("verbose") {
-				dumpParameters(cmd)
+			if errLog := configureLogging(opts.verbose); errLog != nil {
+				return errLog
 			}
-			errOpt := validateStatusOptions()
-			if errOpt != nil {
-				fmt.Println(errOpt.Error())
-				cmd.HelpFunc()(cmd, args)
-				return
-			}
-			err := runStatus()
-			if err != nil {
-				fmt.Println(err.Error())
+			dumpParameters(cmd)
+			if errOpt := validateStatusOptions(); errOpt != nil {
+				return invalidOptions(cmd, args, errOpt)
 			}
+			ctx, cancel := getOperationContext(cmd.Context())
+			defer cancel()
+			return runStatus(ctx)
 		},
 	}
 
@@ -57,7 +54,14 @@ func init() {
 	RootCmd.AddCommand(statusCmd)
 }
 
-func runStatus() error {
+func runStatus(ctx context.Context) error {
+	if errRole := configureAwsCredentials(ctx); errRole != nil {
+		return errRole
+	}
+	if errPassword := resolvePasswords(ctx); errPassword != nil {
+		return errPassword
+	}
+
 	params := &client.DatabaseParameters{
 		Server:       viper.GetString("server"),
 		Username:     viper.GetString("username"),
@@ -65,23 +69,23 @@ func runStatus() error {
 		DatabaseName: viper.GetString("database"),
 	}
 
-	c := client.GetClient()
-	if c == nil {
-		return errors.New("Unable to find a sqlcmd client")
+	c, errClient := getSQLClient(ctx)
+	if errClient != nil {
+		return errClient
 	}
 
-	output, err := c.GetStatus(params, "")
+	output, err := c.GetStatus(ctx, params, "")
 	if err != nil {
 		return err
 	}
 
 	if output == "ERROR" {
-		errorMessage, errErr := c.GetTaskMessage(params)
+		errorMessage, errErr := c.GetTaskMessage(ctx, params)
 		if errErr != nil {
 			return errErr
 		}
 		fmt.Println(errorMessage)
-		return nil
+		return client.NewError(client.ErrorKindTask, fmt.Errorf("The latest backup task of %s failed", params.DatabaseName))
 	}
 
 	fmt.Println(output)
@@ -105,6 +109,8 @@ func validateStatusOptions() error {
 		messages.WriteString("--database Name of database must be specified\n")
 	}
 
+	validateSQLClientOptions(&messages)
+
 	if messages.String() != "" {
 		return errors.New(messages.String())
 	}
@@ -0,0 +1,114 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/rds-backup/client"
+	"github.com/alexhokl/rds-backup/cmd"
+	"github.com/spf13/viper"
+)
+
+// execute runs rds-backup with the arguments and without any configuration file
+func execute(t *testing.T, args ...string) error {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "rds-backup.yaml")
+	if err := os.WriteFile(configFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	cmd.RootCmd.SetArgs(append(args, "--config", configFile))
+	return cmd.RootCmd.ExecuteContext(context.Background())
+}
+
+func useSimulator(t *testing.T, s *Simulator) {
+	client.UseCommandRunner(s.Runner())
+	client.UseSQLClient(&client.NativeClient{})
+	t.Cleanup(func() {
+		client.UseCommandRunner(nil)
+		client.UseSQLClient(nil)
+	})
+}
+
+func TestCreateDownloadRestore(t *testing.T) {
+	simulator := Start(t)
+	simulator.RunTasks(t, time.Second)
+	useSimulator(t, simulator)
+	downloadDirectory := t.TempDir()
+
+	errCreate := execute(t,
+		"create",
+		"--server", "localhost",
+		"--username", "sa",
+		"--password", SAPassword,
+		"--database", SampleDatabase,
+		"--bucket", Bucket,
+		"--filename", "sales.bak",
+		"--download",
+		"--download-directory", downloadDirectory,
+		"--poll-interval", "1s",
+		"--timeout", "5m",
+	)
+	if errCreate != nil {
+		t.Fatal(errCreate)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDirectory, "sales.bak")); err != nil {
+		t.Fatal(err)
+	}
+
+	dataDirectory := makeSharedDirectory(t, simulator.SharedDirectory, "data")
+	shareDirectory := makeSharedDirectory(t, simulator.SharedDirectory, "share")
+	errRestore := execute(t,
+		"restore",
+		"--native",
+		"--filename", "sales.bak",
+		"--database", "SalesRestored",
+		"--mdf", SampleDatabase,
+		"--ldf", SampleDatabase+"_log",
+		"--download-directory", downloadDirectory,
+		"--restore-share", shareDirectory,
+		"--restore-data-directory", dataDirectory,
+		"--timeout", "5m",
+	)
+	if errRestore != nil {
+		t.Fatal(errRestore)
+	}
+
+	if count := simulator.Query(t, "SET NOCOUNT ON; SELECT COUNT(*) FROM SalesRestored.dbo.Orders"); count != "3" {
+		t.Errorf("expected 3 orders in the restored database but got %s", count)
+	}
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	simulator := Start(t)
+
+	tests := []struct {
+		name     string
+		database string
+		expected []string
+	}{
+		{"backup succeeds", SampleDatabase, []string{"CREATED", "IN_PROGRESS", "SUCCESS"}},
+		{"database does not exist", "Missing", []string{"CREATED", "IN_PROGRESS", "ERROR"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulator.Query(t, "SET NOCOUNT ON; DECLARE @s TABLE (task_id INT, task_type VARCHAR(20), lifecycle VARCHAR(20), created_at DATETIME, last_updated DATETIME, database_name SYSNAME, S3_object_arn VARCHAR(MAX), overwrite_S3_backup_file BIT, KMS_master_key_arn VARCHAR(100), task_progress INT, task_info VARCHAR(MAX)); "+
+				"INSERT INTO @s EXEC msdb.dbo.rds_backup_database @source_db_name = N'"+test.database+"', @s3_arn_to_backup_to = 'arn:aws:s3:::"+Bucket+"/"+test.name+".bak', @overwrite_S3_backup_file = 1")
+			for i, expected := range test.expected {
+				if i > 0 {
+					simulator.Step(t)
+				}
+				status := simulator.Query(t, "SET NOCOUNT ON; SELECT TOP 1 lifecycle FROM msdb.dbo.rds_simulator_tasks WHERE database_name = N'"+test.database+"' ORDER BY task_id DESC")
+				if status != expected {
+					t.Fatalf("expected %s after %d steps but got %s", expected, i, status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+-- Stand-ins of the native backup and restore stored procedures of Amazon RDS
+-- for SQL Server. Tasks are queued by rds_backup_database and
+-- rds_restore_database and each call of rds_simulator_step moves the oldest
+-- unfinished task a step through its lifecycle:
+-- CREATED -> IN_PROGRESS -> SUCCESS or ERROR (or CANCELLED once
+-- rds_cancel_task is called). Objects in S3 are files under
+-- /var/opt/rds-simulator/s3/<bucket>/<key>, which the S3 stand-in serves.
+USE msdb;
+GO
+
+IF OBJECT_ID('dbo.rds_simulator_tasks') IS NULL
+CREATE TABLE dbo.rds_simulator_tasks (
+	task_id INT IDENTITY(1, 1) PRIMARY KEY,
+	task_type VARCHAR(20) NOT NULL,
+	database_name SYSNAME NOT NULL,
+	complete INT NOT NULL DEFAULT 0,
+	lifecycle VARCHAR(20) NOT NULL DEFAULT 'CREATED',
+	task_info VARCHAR(MAX) NULL,
+	last_updated DATETIME NOT NULL DEFAULT GETDATE(),
+	created_at DATETIME NOT NULL DEFAULT GETDATE(),
+	S3_object_arn VARCHAR(MAX) NOT NULL,
+	overwrite_S3_backup_file BIT NOT NULL DEFAULT 0,
+	backup_type VARCHAR(20) NOT NULL DEFAULT 'FULL',
+	with_norecovery BIT NOT NULL DEFAULT 0
+);
+GO
+
+-- rds_simulator_path returns the path of the file of an S3 object in form of arn:aws:s3:::bucket/key
+CREATE OR ALTER FUNCTION dbo.rds_simulator_path(@s3_arn VARCHAR(MAX))
+RETURNS NVARCHAR(4000)
+AS
+BEGIN
+	IF @s3_arn NOT LIKE 'arn:aws:s3:::_%/_%'
+		RETURN NULL;
+	RETURN N'/var/opt/rds-simulator/s3/' + SUBSTRING(@s3_arn, 14, LEN(@s3_arn));
+END
+GO
+
+-- rds_simulator_task returns a task in the layout of rds_backup_database and rds_restore_database
+CREATE OR ALTER PROCEDURE dbo.rds_simulator_task
+	@task_id INT
+AS
+BEGIN
+	SET NOCOUNT ON;
+	SELECT
+		task_id,
+		task_type,
+		lifecycle,
+		created_at,
+		last_updated,
+		database_name,
+		S3_object_arn,
+		overwrite_S3_backup_file,
+		CAST(NULL AS VARCHAR(100)) AS KMS_master_key_arn,
+		complete AS task_progress,
+		task_info
+	FROM dbo.rds_simulator_tasks
+	WHERE task_id = @task_id;
+END
+GO
+
+CREATE OR ALTER PROCEDURE dbo.rds_backup_database
+	@source_db_name SYSNAME,
+	@s3_arn_to_backup_to VARCHAR(MAX),
+	@kms_master_key_arn VARCHAR(100) = NULL,
+	@overwrite_S3_backup_file BIT = 0,
+	@type VARCHAR(20) = 'FULL',
+	@number_of_files INT = 1
+AS
+BEGIN
+	SET NOCOUNT ON;
+	IF dbo.rds_simulator_path(@s3_arn_to_backup_to) IS NULL
+		THROW 50000, 'The S3 ARN must be in form of arn:aws:s3:::bucket/key', 1;
+	IF @type NOT IN ('FULL', 'DIFFERENTIAL')
+		THROW 50000, 'The type must be FULL or DIFFERENTIAL', 1;
+
+	INSERT INTO dbo.rds_simulator_tasks (task_type, database_name, S3_object_arn, overwrite_S3_backup_file, backup_type)
+	VALUES ('BACKUP_DB', @source_db_name, @s3_arn_to_backup_to, @overwrite_S3_backup_file, @type);
+
+	DECLARE @task_id INT = SCOPE_IDENTITY();
+	EXEC dbo.rds_simulator_task @task_id;
+END
+GO
+
+CREATE OR ALTER PROCEDURE dbo.rds_restore_database
+	@restore_db_name SYSNAME,
+	@s3_arn_to_restore_from VARCHAR(MAX),
+	@kms_master_key_arn VARCHAR(100) = NULL,
+	@type VARCHAR(20) = 'FULL',
+	@with_norecovery BIT = 0
+AS
+BEGIN
+	SET NOCOUNT ON;
+	IF dbo.rds_simulator_path(@s3_arn_to_restore_from) IS NULL
+		THROW 50000, 'The S3 ARN must be in form of arn:aws:s3:::bucket/key', 1;
+	IF @type NOT IN ('FULL', 'DIFFERENTIAL')
+		THROW 50000, 'The type must be FULL or DIFFERENTIAL', 1;
+
+	INSERT INTO dbo.rds_simulator_tasks (task_type, database_name, S3_object_arn, backup_type, with_norecovery)
+	VALUES ('RESTORE_DB', @restore_db_name, @s3_arn_to_restore_from, @type, @with_norecovery);
+
+	DECLARE @task_id INT = SCOPE_IDENTITY();
+	EXEC dbo.rds_simulator_task @task_id;
+END
+GO
+
+CREATE OR ALTER PROCEDURE dbo.rds_task_status
+	@db_name SYSNAME = NULL,
+	@task_id INT = NULL
+AS
+BEGIN
+	SET NOCOUNT ON;
+	SELECT
+		task_id,
+		task_type,
+		database_name,
+		complete,
+		DATEDIFF(MINUTE, created_at, last_updated) AS duration,
+		lifecycle,
+		task_info,
+		last_updated,
+		created_at,
+		S3_object_arn,
+		overwrite_S3_backup_file,
+		CAST(NULL AS VARCHAR(100)) AS KMS_master_key_arn
+	FROM dbo.rds_simulator_tasks
+	WHERE (@db_name IS NULL OR database_name = @db_name)
+		AND (@task_id IS NULL OR task_id = @task_id)
+	ORDER BY task_id DESC;
+END
+GO
+
+CREATE OR ALTER PROCEDURE dbo.rds_cancel_task
+	@task_id INT
+AS
+BEGIN
+	SET NOCOUNT ON;
+	UPDATE dbo.rds_simulator_tasks
+	SET lifecycle = 'CANCEL_REQUESTED', last_updated = GETDATE()
+	WHERE task_id = @task_id AND lifecycle IN ('CREATED', 'IN_PROGRESS');
+	IF @@ROWCOUNT = 0
+		THROW 50000, 'The task does not exist or has finished already', 1;
+END
+GO
+
+-- rds_simulator_step moves the oldest unfinished task a step through its
+-- lifecycle; the backup or the restore of a task is run in the step from
+-- IN_PROGRESS to SUCCESS or ERROR
+CREATE OR ALTER PROCEDURE dbo.rds_simulator_step
+AS
+BEGIN
+	SET NOCOUNT ON;
+	DECLARE @task_id INT;
+	DECLARE @lifecycle VARCHAR(20);
+	DECLARE @task_type VARCHAR(20);
+	DECLARE @database_name SYSNAME;
+	DECLARE @path NVARCHAR(4000);
+	DECLARE @overwrite BIT;
+	DECLARE @backup_type VARCHAR(20);
+	DECLARE @with_norecovery BIT;
+
+	SELECT TOP 1
+		@task_id = task_id,
+		@lifecycle = lifecycle,
+		@task_type = task_type,
+		@database_name = database_name,
+		@path = dbo.rds_simulator_path(S3_object_arn),
+		@overwrite = overwrite_S3_backup_file,
+		@backup_type = backup_type,
+		@with_norecovery = with_norecovery
+	FROM dbo.rds_simulator_tasks
+	WHERE lifecycle IN ('CREATED', 'IN_PROGRESS', 'CANCEL_REQUESTED')
+	ORDER BY task_id;
+
+	IF @task_id IS NULL
+		RETURN;
+
+	IF @lifecycle = 'CANCEL_REQUESTED'
+	BEGIN
+		UPDATE dbo.rds_simulator_tasks
+		SET lifecycle = 'CANCELLED', task_info = 'Task has been cancelled', last_updated = GETDATE()
+		WHERE task_id = @task_id;
+		RETURN;
+	END
+
+	IF @lifecycle = 'CREATED'
+	BEGIN
+		UPDATE dbo.rds_simulator_tasks
+		SET lifecycle = 'IN_PROGRESS', task_info = 'Task is in progress', last_updated = GETDATE()
+		WHERE task_id = @task_id;
+		RETURN;
+	END
+
+	DECLARE @statement NVARCHAR(MAX);
+	DECLARE @message NVARCHAR(2048);
+	BEGIN TRY
+		IF @task_type = 'BACKUP_DB'
+		BEGIN
+			IF @overwrite = 0
+			BEGIN
+				DECLARE @exists BIT = 1;
+				BEGIN TRY
+					RESTORE LABELONLY FROM DISK = @path;
+				END TRY
+				BEGIN CATCH
+					SET @exists = 0;
+				END CATCH
+				IF @exists = 1
+				BEGIN
+					SET @message = N'The S3 object exists already and @overwrite_S3_backup_file is 0: ' + @path;
+					THROW 50000, @message, 1;
+				END
+			END
+			SET @statement = N'BACKUP DATABASE ' + QUOTENAME(@database_name) + N' TO DISK = @path WITH FORMAT, INIT'
+				+ CASE WHEN @backup_type = 'DIFFERENTIAL' THEN N', DIFFERENTIAL' ELSE N'' END;
+			EXEC sp_executesql @statement, N'@path NVARCHAR(4000)', @path = @path;
+		END
+		ELSE
+		BEGIN
+			IF DB_ID(@database_name) IS NOT NULL
+			BEGIN
+				SET @message = N'Database ' + @database_name + N' exists already';
+				THROW 50000, @message, 1;
+			END
+
+			DECLARE @files TABLE (
+				LogicalName NVARCHAR(128),
+				PhysicalName NVARCHAR(260),
+				Type CHAR(1),
+				FileGroupName NVARCHAR(128),
+				Size NUMERIC(20, 0),
+				MaxSize NUMERIC(20, 0),
+				FileId BIGINT,
+				CreateLSN NUMERIC(25, 0),
+				DropLSN NUMERIC(25, 0),
+				UniqueId UNIQUEIDENTIFIER,
+				ReadOnlyLSN NUMERIC(25, 0),
+				ReadWriteLSN NUMERIC(25, 0),
+				BackupSizeInBytes BIGINT,
+				SourceBlockSize INT,
+				FileGroupId INT,
+				LogGroupGUID UNIQUEIDENTIFIER,
+				DifferentialBaseLSN NUMERIC(25, 0),
+				DifferentialBaseGUID UNIQUEIDENTIFIER,
+				IsReadOnly BIT,
+				IsPresent BIT,
+				TDEThumbprint VARBINARY(32),
+				SnapshotUrl NVARCHAR(360)
+			);
+			INSERT INTO @files
+			EXEC sp_executesql N'RESTORE FILELISTONLY FROM DISK = @path', N'@path NVARCHAR(4000)', @path = @path;
+
+			DECLARE @moves NVARCHAR(MAX) = N'';
+			SELECT @moves = @moves + N', MOVE N''' + REPLACE(LogicalName, '''', '''''') + N''' TO N'''
+				+ REPLACE(N'/var/opt/mssql/data/' + @database_name + N'_' + LogicalName + CASE Type WHEN 'L' THEN N'.ldf' ELSE N'.mdf' END, '''', '''''')
+				+ N''''
+			FROM @files;
+
+			SET @statement = N'RESTORE DATABASE ' + QUOTENAME(@database_name) + N' FROM DISK = @path WITH FILE = 1'
+				+ CASE WHEN @with_norecovery = 1 THEN N', NORECOVERY' ELSE N', RECOVERY' END
+				+ @moves;
+			EXEC sp_executesql @statement, N'@path NVARCHAR(4000)', @path = @path;
+		END
+
+		UPDATE dbo.rds_simulator_tasks
+		SET lifecycle = 'SUCCESS', complete = 100, task_info = @task_type + ' has been completed', last_updated = GETDATE()
+		WHERE task_id = @task_id AND lifecycle = 'IN_PROGRESS';
+	END TRY
+	BEGIN CATCH
+		UPDATE dbo.rds_simulator_tasks
+		SET lifecycle = 'ERROR', task_info = ERROR_MESSAGE(), last_updated = GETDATE()
+		WHERE task_id = @task_id;
+	END CATCH
+END
+GO
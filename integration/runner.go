@@ -0,0 +1,75 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"strings"
+
+	"github.com/alexhokl/rds-backup/client"
+)
+
+// runner directs the external commands of package client to the simulator
+type runner struct {
+	simulator *Simulator
+	next      client.CommandRunner
+}
+
+// Runner returns a command runner which runs sqlcmd in the SQL server of the
+// simulator and AWS CLI against its S3 stand-in; other commands are run as
+// they are
+func (s *Simulator) Runner() client.CommandRunner {
+	return &runner{simulator: s, next: client.NewExecRunner()}
+}
+
+func (r *runner) Run(ctx context.Context, command *client.Command) (string, error) {
+	switch command.Name {
+	case "sqlcmd":
+		return r.next.Run(ctx, r.simulator.getSQLCmdCommand(command))
+	case "aws":
+		awsCommand := *command
+		awsCommand.Environment = append(append([]string{}, command.Environment...), r.simulator.getAwsEnvironment()...)
+		return r.next.Run(ctx, &awsCommand)
+	}
+	return r.next.Run(ctx, command)
+}
+
+// getSQLCmdCommand returns a command running the sqlcmd command in the SQL
+// server; it connects as sa unless a login is specified
+func (s *Simulator) getSQLCmdCommand(command *client.Command) *client.Command {
+	password := SAPassword
+	for _, variable := range command.Environment {
+		if value, ok := strings.CutPrefix(variable, "SQLCMDPASSWORD="); ok {
+			password = value
+		}
+	}
+	args := []string{"exec", "-i", "-e", "SQLCMDPASSWORD=" + password, s.serverContainer, sqlcmdPath, "-C"}
+	if !hasArg(command.Args, "-U") {
+		args = append(args, "-S", "localhost", "-U", "sa")
+	}
+	return &client.Command{
+		Name:   "docker",
+		Args:   append(args, command.Args...),
+		Stdin:  command.Stdin,
+		Stdout: command.Stdout,
+	}
+}
+
+func (s *Simulator) getAwsEnvironment() []string {
+	return []string{
+		"AWS_CONFIG_FILE=" + s.awsConfigFile,
+		"AWS_SHARED_CREDENTIALS_FILE=/dev/null",
+		"AWS_ACCESS_KEY_ID=" + s3AccessKey,
+		"AWS_SECRET_ACCESS_KEY=" + s3SecretKey,
+		"AWS_PROFILE=default",
+	}
+}
+
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
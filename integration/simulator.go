@@ -0,0 +1,240 @@
+//go:build integration
+
+// Package integration runs the operations of rds-backup end to end against a
+// local simulator of Amazon RDS for SQL Server, which consists of SQL Server
+// with stand-ins of the RDS backup and restore procedures and an S3 stand-in
+// serving the files the procedures write. Docker and AWS CLI are required.
+//
+//	go test -tags integration ./integration/
+package integration
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//go:embed rds_procedures.sql
+var procedures string
+
+const serverImage = "mcr.microsoft.com/mssql/server:2022-latest"
+const s3Image = "rclone/rclone:latest"
+const sqlcmdPath = "/opt/mssql-tools18/bin/sqlcmd"
+
+// s3Directory is the directory in the server container containing the S3 objects (see rds_procedures.sql)
+const s3Directory = "/var/opt/rds-simulator/s3"
+
+// SAPassword is the password of sa of the SQL server in the simulator
+const SAPassword = "Simulat0r!Passw0rd"
+
+// Bucket is the S3 bucket available in the simulator
+const Bucket = "rds-backups"
+
+// SampleDatabase is a database in the simulator with 3 rows in table Orders
+const SampleDatabase = "Sales"
+
+const s3AccessKey = "simulator"
+const s3SecretKey = "simulator-secret"
+
+// serverStartupTimeout limits the time waiting for SQL server in the simulator to accept queries
+const serverStartupTimeout = 3 * time.Minute
+
+// Simulator is a running simulator of Amazon RDS for SQL Server
+type Simulator struct {
+	serverContainer string
+	awsConfigFile   string
+	// SharedDirectory is a directory on this machine mounted at the same path
+	// in the SQL server so that paths of files can be passed to it as they are
+	SharedDirectory string
+}
+
+// Start starts a simulator, which is removed once the test completes; the
+// test is skipped if Docker or AWS CLI is not installed
+func Start(t *testing.T) *Simulator {
+	t.Helper()
+	for _, name := range []string{"docker", "aws"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s is required to run the simulator", name)
+		}
+	}
+
+	name := fmt.Sprintf("rds-simulator-%d", time.Now().UnixNano())
+	root := t.TempDir()
+	bucketDirectory := makeSharedDirectory(t, root, "s3", Bucket)
+	s := &Simulator{
+		serverContainer: name + "-sql",
+		SharedDirectory: makeSharedDirectory(t, root, "shared"),
+	}
+
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", s.serverContainer, name+"-s3").Run()
+	})
+	runDocker(t,
+		"run", "-d",
+		"--name", s.serverContainer,
+		"-e", "ACCEPT_EULA=Y",
+		"-e", "MSSQL_SA_PASSWORD="+SAPassword,
+		"-v", filepath.Dir(bucketDirectory)+":"+s3Directory,
+		"-v", s.SharedDirectory+":"+s.SharedDirectory,
+		serverImage,
+	)
+	runDocker(t,
+		"run", "-d",
+		"--name", name+"-s3",
+		"-p", "127.0.0.1::8080",
+		"-v", filepath.Dir(bucketDirectory)+":/data",
+		s3Image,
+		"serve", "s3", "/data",
+		"--addr", ":8080",
+		"--auth-key", s3AccessKey+","+s3SecretKey,
+	)
+	endpoint := strings.TrimSpace(runDocker(t, "port", name+"-s3", "8080"))
+	s.awsConfigFile = writeAwsConfig(t, root, strings.Split(endpoint, "\n")[0])
+
+	s.waitForServer(t)
+	s.install(t)
+	return s
+}
+
+// Query runs the statement in the SQL server of the simulator and returns its
+// output without headers
+func (s *Simulator) Query(t *testing.T, statement string) string {
+	t.Helper()
+	output, err := s.query(statement)
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", statement, err, output)
+	}
+	return strings.TrimSpace(output)
+}
+
+// Step moves the oldest unfinished task a step through its lifecycle
+func (s *Simulator) Step(t *testing.T) {
+	t.Helper()
+	s.Query(t, "EXEC msdb.dbo.rds_simulator_step")
+}
+
+// RunTasks moves tasks through their lifecycles every interval in background
+// until the test completes
+func (s *Simulator) RunTasks(t *testing.T, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			if output, err := s.query("EXEC msdb.dbo.rds_simulator_step"); err != nil && ctx.Err() == nil {
+				t.Logf("Unable to run a step of tasks: %v\n%s", err, output)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wait.Wait()
+	})
+}
+
+func (s *Simulator) query(statement string) (string, error) {
+	output, err := s.sqlcmd(nil, "-b", "-h", "-1", "-W", "-Q", statement).CombinedOutput()
+	return string(output), err
+}
+
+// sqlcmd returns a command running sqlcmd as sa in the SQL server with the
+// arguments and the input (if any)
+func (s *Simulator) sqlcmd(stdin io.Reader, args ...string) *exec.Cmd {
+	dockerArgs := []string{"exec"}
+	if stdin != nil {
+		dockerArgs = append(dockerArgs, "-i")
+	}
+	dockerArgs = append(dockerArgs,
+		"-e", "SQLCMDPASSWORD="+SAPassword,
+		s.serverContainer,
+		sqlcmdPath,
+		"-C",
+		"-S", "localhost",
+		"-U", "sa",
+	)
+	command := exec.Command("docker", append(dockerArgs, args...)...)
+	command.Stdin = stdin
+	return command
+}
+
+func (s *Simulator) waitForServer(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(serverStartupTimeout)
+	for {
+		output, err := s.query("SELECT 1")
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("SQL server of the simulator did not start in %s: %v\n%s", serverStartupTimeout, err, output)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// install installs the stand-ins of the RDS procedures and creates SampleDatabase
+func (s *Simulator) install(t *testing.T) {
+	t.Helper()
+	command := s.sqlcmd(strings.NewReader(procedures), "-b", "-i", "/dev/stdin")
+	if output, err := command.CombinedOutput(); err != nil {
+		t.Fatalf("Unable to install the RDS procedures: %v\n%s", err, output)
+	}
+
+	s.Query(t, fmt.Sprintf(`CREATE DATABASE [%s]`, SampleDatabase))
+	s.Query(t, fmt.Sprintf(`SET NOCOUNT ON;
+		CREATE TABLE [%[1]s].dbo.Orders (OrderID INT PRIMARY KEY, Customer NVARCHAR(100));
+		INSERT INTO [%[1]s].dbo.Orders VALUES (1, N'Alice'), (2, N'Bob'), (3, N'O''Brien');`, SampleDatabase))
+}
+
+func runDocker(t *testing.T, args ...string) string {
+	t.Helper()
+	output, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		t.Fatalf("docker %s: %v", strings.Join(args, " "), err)
+	}
+	return string(output)
+}
+
+// makeSharedDirectory makes a directory which the SQL server, running as a
+// user other than the one of this process, can write to
+func makeSharedDirectory(t *testing.T, elements ...string) string {
+	t.Helper()
+	path := filepath.Join(elements...)
+	if err := os.MkdirAll(path, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0777); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeAwsConfig writes a configuration of AWS CLI directing S3 requests to the S3 stand-in
+func writeAwsConfig(t *testing.T, directory string, endpoint string) string {
+	t.Helper()
+	path := filepath.Join(directory, "aws-config")
+	content := fmt.Sprintf(`[default]
+region = us-east-1
+endpoint_url = http://%s
+s3 =
+  addressing_style = path
+`, endpoint)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}